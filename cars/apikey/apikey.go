@@ -0,0 +1,278 @@
+package apikey
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+)
+
+// Header is the request header carrying an API key
+const Header = "X-API-Key"
+
+// keyPrefix marks strings issued by Generate, to make leaked keys easy to spot
+const keyPrefix = "cars_"
+
+/*
+Key describes one issued API key.  Only the SHA-256 hash of the key is
+stored, so the keys file does not hold usable secrets.
+*/
+type Key struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256"`
+	// RatePerSecond and Burst size the key's token bucket; zero means no rate limit
+	RatePerSecond float64 `yaml:"rate_per_second"`
+	Burst         int     `yaml:"burst"`
+	// DailyQuota caps the requests per UTC day; zero means no quota
+	DailyQuota int64 `yaml:"daily_quota"`
+	// Roles are granted to requests made with the key; viewer when empty
+	Roles []auth.Role `yaml:"roles"`
+}
+
+// keysFile is the layout of the keys file
+type keysFile struct {
+	Keys []Key `yaml:"keys"`
+}
+
+type entry struct {
+	Key
+	hash    []byte
+	limiter *rate.Limiter
+}
+
+/*
+Store checks API keys against the keys file, applies their rate limits
+and daily quotas, and counts their use
+*/
+type Store struct {
+	keys  []*entry
+	usage *Usage
+	now   func() time.Time
+}
+
+/*
+Generate returns a new random API key and the hash to record for it
+in the keys file
+*/
+func Generate() (key, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, Hash(key), nil
+}
+
+/*
+Hash returns the hex SHA-256 hash of key
+*/
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+Load reads the keys file at keysPath and the usage counters persisted at
+usagePath
+*/
+func Load(keysPath, usagePath string) (*Store, error) {
+	b, err := os.ReadFile(keysPath)
+	if err != nil {
+		return nil, fmt.Errorf("apikey: reading keys file: %v", err)
+	}
+	var file keysFile
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("apikey: parsing keys file %s: %v", keysPath, err)
+	}
+	usage, err := LoadUsage(usagePath)
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(file.Keys, usage)
+}
+
+/*
+NewStore creates a store for keys, counting their use in usage
+*/
+func NewStore(keys []Key, usage *Usage) (*Store, error) {
+	s := &Store{usage: usage, now: time.Now}
+	names := map[string]bool{}
+	for _, k := range keys {
+		if k.Name == "" {
+			return nil, fmt.Errorf("apikey: key with hash %q has no name", k.SHA256)
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("apikey: duplicate key name %q", k.Name)
+		}
+		names[k.Name] = true
+		hash, err := hex.DecodeString(k.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("apikey: key %q: sha256 must be 64 hex characters", k.Name)
+		}
+		if len(k.Roles) == 0 {
+			k.Roles = []auth.Role{auth.Viewer}
+		}
+		limit, burst := rate.Inf, 0
+		if k.RatePerSecond > 0 {
+			limit, burst = rate.Limit(k.RatePerSecond), k.Burst
+			if burst < 1 {
+				burst = int(math.Ceil(k.RatePerSecond))
+			}
+		}
+		s.keys = append(s.keys, &entry{Key: k, hash: hash, limiter: rate.NewLimiter(limit, burst)})
+	}
+	return s, nil
+}
+
+// lookup finds the key whose hash matches key, comparing in constant time
+func (s *Store) lookup(key string) *entry {
+	sum := sha256.Sum256([]byte(key))
+	var found *entry
+	for _, e := range s.keys {
+		if subtle.ConstantTimeCompare(sum[:], e.hash) == 1 {
+			found = e
+		}
+	}
+	return found
+}
+
+/*
+Middleware admits requests carrying a valid API key in the X-API-Key
+header, within the key's rate limit and daily quota, and passes the key's
+identity to the handler in the request context.  Every admitted request
+is counted against the key.
+
+Requests without the header are refused with a 401 unless
+allowOtherCredentials is set, in which case they are passed on for a
+later middleware to authenticate.
+*/
+func (s *Store) Middleware(allowOtherCredentials bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if key == "" {
+				if allowOtherCredentials {
+					next.ServeHTTP(w, r)
+					return
+				}
+				writeError(w, http.StatusUnauthorized, "an API key is required in the X-API-Key header")
+				return
+			}
+			e := s.lookup(key)
+			if e == nil {
+				writeError(w, http.StatusUnauthorized, "the API key is not valid")
+				return
+			}
+
+			now := s.now().UTC()
+			day := now.Format(dayLayout)
+			resetAt := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+
+			reservation := e.limiter.ReserveN(now, 1)
+			if delay := reservation.DelayFrom(now); !reservation.OK() || delay > 0 {
+				if reservation.OK() {
+					reservation.CancelAt(now)
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				}
+				setRateHeaders(w, e.limiter, now)
+				writeError(w, http.StatusTooManyRequests, "too many requests for this API key, slow down")
+				return
+			}
+
+			used, ok := s.usage.Take(day, e.Name, e.DailyQuota)
+			if e.DailyQuota > 0 {
+				setQuotaHeaders(w, e.DailyQuota, e.DailyQuota-used, resetAt)
+			}
+			if !ok {
+				reservation.CancelAt(now)
+				setRateHeaders(w, e.limiter, now)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(resetAt.Sub(now).Seconds()))))
+				writeError(w, http.StatusTooManyRequests, "the daily quota for this API key is used up")
+				return
+			}
+			setRateHeaders(w, e.limiter, now)
+
+			id := auth.Identity{Subject: "apikey:" + e.Name, Roles: e.Roles}
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), id)))
+		})
+	}
+}
+
+/*
+setRateHeaders describes the token bucket of a key with a rate limit as
+it stands at now: the burst, the requests that could be made at once, and
+the Unix time the bucket is full again
+*/
+func setRateHeaders(w http.ResponseWriter, limiter *rate.Limiter, now time.Time) {
+	if limiter.Limit() == rate.Inf {
+		return
+	}
+	burst := float64(limiter.Burst())
+	tokens := math.Max(limiter.TokensAt(now), 0)
+	refill := time.Duration((burst - tokens) / float64(limiter.Limit()) * float64(time.Second))
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.Burst()))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(math.Floor(tokens))))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(now.Add(refill).UnixNano())/1e9)), 10))
+}
+
+// setQuotaHeaders describes a key's daily quota, which resets at resetAt
+func setQuotaHeaders(w http.ResponseWriter, limit, remaining int64, resetAt time.Time) {
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set("X-Quota-Limit", strconv.FormatInt(limit, 10))
+	w.Header().Set("X-Quota-Remaining", strconv.FormatInt(remaining, 10))
+	w.Header().Set("X-Quota-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{ "message": %q }`, msg)
+}
+
+/*
+Run saves the usage counters every interval until ctx is done, and once
+more before returning
+*/
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.usage.Flush(); err != nil {
+				log.Printf("saving API key usage: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.usage.Flush(); err != nil {
+				log.Printf("saving API key usage: %v", err)
+			}
+		}
+	}
+}
+
+/*
+Usage returns the store's usage counters
+*/
+func (s *Store) Usage() *Usage {
+	return s.usage
+}
@@ -0,0 +1,196 @@
+package apikey
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+)
+
+func newTestStore(t *testing.T, keys ...Key) (*Store, *time.Time) {
+	usage, _ := LoadUsage("")
+	s, err := NewStore(keys, usage)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, &now
+}
+
+func serve(s *Store, key string, allowOther bool) *httptest.ResponseRecorder {
+	handler := s.Middleware(allowOther)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := auth.FromContext(r.Context())
+		w.Write([]byte(id.Subject))
+	}))
+	req := httptest.NewRequest("GET", "/cars", nil)
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareAuthenticates(t *testing.T) {
+	key, hash, _ := Generate()
+	s, _ := newTestStore(t, Key{Name: "partner-a", SHA256: hash})
+
+	rec := serve(s, key, false)
+	if rec.Code != http.StatusOK || rec.Body.String() != "apikey:partner-a" {
+		t.Errorf("Failed! valid key: got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := serve(s, "cars_wrong", false); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Failed! invalid key: expected 401, got %d", rec.Code)
+	}
+	if rec := serve(s, "", false); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Failed! missing key: expected 401, got %d", rec.Code)
+	}
+	if rec := serve(s, "", true); rec.Code != http.StatusOK || rec.Body.String() != "" {
+		t.Errorf("Failed! missing key should pass on when other credentials are allowed, got %d", rec.Code)
+	}
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	key, hash, _ := Generate()
+	s, now := newTestStore(t, Key{Name: "partner-a", SHA256: hash, RatePerSecond: 1, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		rec := serve(s, key, false)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Failed! request %d within burst: got %d", i, rec.Code)
+		}
+		if rec.Header().Get("X-RateLimit-Limit") != "2" || rec.Header().Get("X-RateLimit-Remaining") != strconv.Itoa(1-i) {
+			t.Errorf("Failed! unexpected rate limit headers on request %d: %v", i, rec.Header())
+		}
+	}
+	rec := serve(s, key, false)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Failed! request over the burst: got %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// the bucket of 2 at 1 a second is full again 2 seconds on
+	if rec.Header().Get("X-RateLimit-Remaining") != "0" || rec.Header().Get("X-RateLimit-Reset") != strconv.FormatInt(now.Add(2*time.Second).Unix(), 10) {
+		t.Errorf("Failed! unexpected rate limit headers over the burst: %v", rec.Header())
+	}
+
+	*now = now.Add(time.Second)
+	if rec := serve(s, key, false); rec.Code != http.StatusOK {
+		t.Errorf("Failed! request after the bucket refilled: got %d", rec.Code)
+	}
+	if got := s.Usage().Count("2021-05-01", "partner-a"); got != 3 {
+		t.Errorf("Failed! only admitted requests should be counted, got %d", got)
+	}
+}
+
+func TestMiddlewareDailyQuota(t *testing.T) {
+	key, hash, _ := Generate()
+	s, now := newTestStore(t, Key{Name: "partner-a", SHA256: hash, DailyQuota: 2})
+
+	rec := serve(s, key, false)
+	if rec.Header().Get("X-Quota-Limit") != "2" || rec.Header().Get("X-Quota-Remaining") != "1" {
+		t.Errorf("Failed! unexpected quota headers %v", rec.Header())
+	}
+	// 2021-05-02T00:00:00Z
+	if rec.Header().Get("X-Quota-Reset") != "1619913600" {
+		t.Errorf("Failed! quota should reset at UTC midnight, got %s", rec.Header().Get("X-Quota-Reset"))
+	}
+	if rec.Header().Get("X-RateLimit-Limit") != "" {
+		t.Errorf("Failed! a key without a rate limit was sent rate limit headers: %v", rec.Header())
+	}
+	serve(s, key, false)
+
+	rec = serve(s, key, false)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("X-Quota-Remaining") != "0" {
+		t.Errorf("Failed! request over quota: got %d, remaining %q", rec.Code, rec.Header().Get("X-Quota-Remaining"))
+	}
+	if rec.Header().Get("Retry-After") != "43200" {
+		t.Errorf("Failed! Retry-After should point at UTC midnight, got %q", rec.Header().Get("Retry-After"))
+	}
+
+	*now = now.Add(12 * time.Hour)
+	if rec := serve(s, key, false); rec.Code != http.StatusOK {
+		t.Errorf("Failed! quota should start again on a new day, got %d", rec.Code)
+	}
+}
+
+func TestUsagePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	usage, err := LoadUsage(path)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	usage.Take("2021-05-01", "partner-a", 0)
+	usage.Take("2021-05-01", "partner-a", 0)
+	usage.Take("2021-05-01", "partner-b", 0)
+	if err := usage.Flush(); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+
+	reloaded, err := LoadUsage(path)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	report := reloaded.Report("2021-05-01")
+	if report["partner-a"] != 2 || report["partner-b"] != 1 {
+		t.Errorf("Failed! unexpected report after reload %v", report)
+	}
+}
+
+func TestUsageConcurrentFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	usage, err := LoadUsage(path)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			usage.Take("2021-05-01", "partner-a", 0)
+			if err := usage.Flush(); err != nil {
+				t.Errorf("Failed! %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	reloaded, err := LoadUsage(path)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if n := reloaded.Count("2021-05-01", "partner-a"); n != 50 {
+		t.Errorf("Failed! expected the last flush to save 50 requests, got %d", n)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.yaml")
+	os.WriteFile(keysPath, []byte(`
+keys:
+  - name: partner-a
+    sha256: `+Hash("cars_secret")+`
+    daily_quota: 100
+    roles: [sales]
+`), 0o600)
+
+	s, err := Load(keysPath, filepath.Join(dir, "usage.json"))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if e := s.lookup("cars_secret"); e == nil || e.Roles[0] != auth.Sales {
+		t.Errorf("Failed! key from file not found or roles lost: %+v", e)
+	}
+
+	os.WriteFile(keysPath, []byte("keys:\n  - name: partner-a\n    sha256: abc\n"), 0o600)
+	if _, err := Load(keysPath, ""); err == nil {
+		t.Errorf("Failed! malformed hash was accepted")
+	}
+}
@@ -0,0 +1,137 @@
+package apikey
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// dayLayout names the UTC day usage is counted in
+const dayLayout = "2006-01-02"
+
+/*
+Usage counts the requests admitted for each key per UTC day, and saves
+the counts to a JSON file so quotas survive restarts and consumption can
+be reported per partner and day.  The file maps day to key name to count:
+
+	{ "2021-05-01": { "partner-a": 1200, "partner-b": 37 } }
+*/
+type Usage struct {
+	mu    sync.Mutex
+	path  string
+	days  map[string]map[string]int64
+	dirty bool
+
+	// flushMu serialises Flush from snapshot through rename, so an older
+	// snapshot is never renamed over a newer one
+	flushMu sync.Mutex
+}
+
+/*
+LoadUsage reads the counts saved at path.  A missing file starts empty;
+an empty path keeps the counts in memory only.
+*/
+func LoadUsage(path string) (*Usage, error) {
+	u := &Usage{path: path, days: map[string]map[string]int64{}}
+	if path == "" {
+		return u, nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("apikey: reading usage file: %v", err)
+	}
+	if err := json.Unmarshal(b, &u.days); err != nil {
+		return nil, fmt.Errorf("apikey: parsing usage file %s: %v", path, err)
+	}
+	return u, nil
+}
+
+/*
+Count returns the requests counted for name on day
+*/
+func (u *Usage) Count(day, name string) int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.days[day][name]
+}
+
+/*
+Take counts one request for name on day unless quota is already used up.
+A zero quota never runs out.  It returns the count after the request and
+whether the request was counted.
+*/
+func (u *Usage) Take(day, name string, quota int64) (int64, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counts, ok := u.days[day]
+	if !ok {
+		counts = map[string]int64{}
+		u.days[day] = counts
+	}
+	if quota > 0 && counts[name] >= quota {
+		return counts[name], false
+	}
+	counts[name]++
+	u.dirty = true
+	return counts[name], true
+}
+
+/*
+Report returns a copy of the counts for day, by key name
+*/
+func (u *Usage) Report(day string) map[string]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	report := map[string]int64{}
+	for name, n := range u.days[day] {
+		report[name] = n
+	}
+	return report
+}
+
+/*
+Flush saves the counts to the usage file if they changed since the last
+save.  The file is replaced atomically.
+*/
+func (u *Usage) Flush() error {
+	u.flushMu.Lock()
+	defer u.flushMu.Unlock()
+
+	u.mu.Lock()
+	if u.path == "" || !u.dirty {
+		u.mu.Unlock()
+		return nil
+	}
+	b, err := json.MarshalIndent(u.days, "", "  ")
+	u.dirty = false
+	u.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(u.path), filepath.Base(u.path)+".*")
+	if err == nil {
+		_, err = tmp.Write(b)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), u.path)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		u.mu.Lock()
+		u.dirty = true
+		u.mu.Unlock()
+		return fmt.Errorf("apikey: saving usage file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+)
+
+/*
+Role names a level of access.  Each role includes the access of the
+roles before it: viewer < sales < manager < admin.
+*/
+type Role string
+
+const (
+	Viewer  Role = "viewer"
+	Sales   Role = "sales"
+	Manager Role = "manager"
+	Admin   Role = "admin"
+)
+
+// rank orders the roles; unknown roles rank zero and grant nothing
+var rank = map[Role]int{
+	Viewer:  1,
+	Sales:   2,
+	Manager: 3,
+	Admin:   4,
+}
+
+/*
+Identity describes an authenticated caller
+*/
+type Identity struct {
+	Subject string
+	Roles   []Role
+}
+
+/*
+Has reports whether the identity holds role, directly or through a
+higher role
+*/
+func (id Identity) Has(role Role) bool {
+	need, ok := rank[role]
+	if !ok {
+		return false
+	}
+	for _, r := range id.Roles {
+		if rank[r] >= need {
+			return true
+		}
+	}
+	return false
+}
+
+type identityKey struct{}
+
+/*
+NewContext returns a copy of ctx carrying id
+*/
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+/*
+FromContext returns the identity carried by ctx, if there is one
+*/
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
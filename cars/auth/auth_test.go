@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	testIssuer   = "https://login.example.com"
+	testAudience = "cars"
+)
+
+// signingKey is a locally generated key published in a JWKS file
+type signingKey struct {
+	private *ecdsa.PrivateKey
+	kid     string
+}
+
+func newSigningKey(t *testing.T, kid string) signingKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	return signingKey{private: key, kid: kid}
+}
+
+func writeJWKS(t *testing.T, keys ...signingKey) string {
+	var set jose.JSONWebKeySet
+	for _, k := range keys {
+		set.Keys = append(set.Keys, jose.JSONWebKey{Key: &k.private.PublicKey, KeyID: k.kid, Algorithm: string(jose.ES256), Use: "sig"})
+	}
+	b, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	return path
+}
+
+func (k signingKey) sign(t *testing.T, claims jwt.Claims, roles ...Role) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: k.private},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", k.kid))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).Claims(roleClaims{Roles: roles}).CompactSerialize()
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	return token
+}
+
+func validClaims() jwt.Claims {
+	return jwt.Claims{
+		Subject:  "alice",
+		Issuer:   testIssuer,
+		Audience: jwt.Audience{testAudience},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		IssuedAt: jwt.NewNumericDate(time.Now()),
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := newSigningKey(t, "key-1")
+	v, err := NewVerifier(writeJWKS(t, key), testIssuer, testAudience)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+
+	id, err := v.Verify(key.sign(t, validClaims(), Sales))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if id.Subject != "alice" || !id.Has(Viewer) || !id.Has(Sales) || id.Has(Manager) {
+		t.Errorf("Failed! unexpected identity %+v", id)
+	}
+
+	expired := validClaims()
+	expired.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "https://elsewhere.example.com"
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.Audience{"billing"}
+	noExpiry := validClaims()
+	noExpiry.Expiry = nil
+
+	hmacSigner, _ := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte("secret")}, nil)
+	hmacToken, _ := jwt.Signed(hmacSigner).Claims(validClaims()).CompactSerialize()
+
+	invalid := map[string]string{
+		"expired":        key.sign(t, expired, Admin),
+		"wrong issuer":   key.sign(t, wrongIssuer, Admin),
+		"wrong audience": key.sign(t, wrongAudience, Admin),
+		"no expiry":      key.sign(t, noExpiry, Admin),
+		"unknown key":    newSigningKey(t, "key-1").sign(t, validClaims(), Admin),
+		"hmac":           hmacToken,
+		"garbage":        "not.a.token",
+	}
+	for name, token := range invalid {
+		if _, err := v.Verify(token); err == nil {
+			t.Errorf("Failed! %s token was accepted", name)
+		}
+	}
+}
+
+func TestNewVerifierRejectsPrivateKeys(t *testing.T) {
+	key := newSigningKey(t, "key-1")
+	b, _ := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: key.private, KeyID: "key-1"}}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, b, 0o600)
+	if _, err := NewVerifier(path, "", ""); err == nil {
+		t.Errorf("Failed! JWKS with a private key was accepted")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	key := newSigningKey(t, "key-1")
+	v, _ := NewVerifier(writeJWKS(t, key), testIssuer, testAudience)
+	handler := v.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := FromContext(r.Context())
+		w.Write([]byte(id.Subject))
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"valid", "Bearer " + key.sign(t, validClaims(), Viewer), http.StatusOK},
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong scheme", "Basic YWxpY2U6c2VjcmV0", http.StatusUnauthorized},
+		{"invalid", "Bearer not.a.token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/cars", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("Failed! %s: expected %d, got %d", tt.name, tt.want, rec.Code)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Failed! %s: 401 without a WWW-Authenticate header", tt.name)
+		}
+	}
+}
+
+func TestMetadataMiddleware(t *testing.T) {
+	key := newSigningKey(t, "key-1")
+	v, _ := NewVerifier(writeJWKS(t, key), testIssuer, testAudience)
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(subjectKey) + "|" + r.Header.Get(rolesKey)))
+	})
+
+	tests := []struct {
+		name     string
+		verifier *Verifier
+		header   string
+		want     string
+	}{
+		{"forged without verifier", nil, "", "|"},
+		{"forged without token", v, "", "|"},
+		{"valid token", v, "Bearer " + key.sign(t, validClaims(), Sales), "alice|sales"},
+		{"invalid token", v, "Bearer not.a.token", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/cars.CarService/Car", nil)
+		req.Header.Set(subjectKey, "mallory")
+		req.Header.Set(rolesKey, "admin")
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		MetadataMiddleware(tt.verifier, echo).ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("Failed! %s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/cars.CarService/Car", nil)
+	req.Header.Set("Authorization", "Bearer not.a.token")
+	rec := httptest.NewRecorder()
+	MetadataMiddleware(v, echo).ServeHTTP(rec, req)
+	if got := rec.Header().Get("grpc-status"); got != "16" {
+		t.Errorf("Failed! invalid token should be Unauthenticated, got grpc-status %q", got)
+	}
+}
+
+func TestPolicyOverGRPC(t *testing.T) {
+	policy := Policy{
+		"/grpc.health.v1.Health/Check": Manager,
+	}
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.UnaryInterceptor(policy.UnaryServerInterceptor))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{"no identity", context.Background(), codes.Unauthenticated},
+		{"viewer", NewContext(context.Background(), Identity{Subject: "v", Roles: []Role{Viewer}}), codes.PermissionDenied},
+		{"sales", NewContext(context.Background(), Identity{Subject: "s", Roles: []Role{Sales}}), codes.PermissionDenied},
+		{"manager", NewContext(context.Background(), Identity{Subject: "m", Roles: []Role{Manager}}), codes.OK},
+		{"admin", NewContext(context.Background(), Identity{Subject: "a", Roles: []Role{Viewer, Admin}}), codes.OK},
+		{"unknown role", NewContext(context.Background(), Identity{Subject: "u", Roles: []Role{"owner"}}), codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		_, err := client.Check(tt.ctx, &healthpb.HealthCheckRequest{})
+		if got := status.Code(err); got != tt.want {
+			t.Errorf("Failed! %s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	// methods missing from the policy are refused even for admins
+	_, err = policy.Authorize(context.Background(), "/cars.CarService/Unlisted")
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Failed! unlisted method should be refused, got %v", err)
+	}
+}
+
+func TestTrustInterceptor(t *testing.T) {
+	forwarded := metadata.Pairs(subjectKey, "mallory", rolesKey, "admin", "x-request-id", "r1")
+	verified := credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}}
+	tests := []struct {
+		name  string
+		auth  credentials.AuthInfo
+		trust Trust
+		want  bool
+	}{
+		{"plaintext", nil, VerifiedClient, false},
+		{"TLS without a client certificate", credentials.TLSInfo{}, VerifiedClient, false},
+		{"verified client certificate", verified, VerifiedClient, true},
+		{"in-process", nil, AnyCaller, true},
+	}
+	for _, tt := range tests {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: tt.auth})
+		ctx = metadata.NewIncomingContext(ctx, forwarded)
+		var got Identity
+		var trusted bool
+		interceptor := UnaryTrustInterceptor(tt.trust)
+		interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return UnaryIdentityInterceptor(ctx, req, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				got, trusted = FromContext(ctx)
+				if md, _ := metadata.FromIncomingContext(ctx); len(md.Get("x-request-id")) != 1 {
+					t.Errorf("Failed! %s: other metadata was dropped", tt.name)
+				}
+				return nil, nil
+			})
+		})
+		if trusted != tt.want {
+			t.Errorf("Failed! %s: expected trusted %v, got %v (%+v)", tt.name, tt.want, trusted, got)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys carrying the caller's identity from the REST service
+// to the microservice
+const (
+	subjectKey = "x-cars-subject"
+	rolesKey   = "x-cars-roles"
+)
+
+/*
+Policy maps a full gRPC method name to the role a caller needs.
+Methods listed with an empty role are public; methods that are not
+listed are refused.
+*/
+type Policy map[string]Role
+
+/*
+UnaryClientInterceptor forwards the identity in the call's context to
+the server in metadata
+*/
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(outgoing(ctx), method, req, reply, cc, opts...)
+}
+
+/*
+StreamClientInterceptor forwards the identity in the stream's context to
+the server in metadata
+*/
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(outgoing(ctx), desc, cc, method, opts...)
+}
+
+func outgoing(ctx context.Context) context.Context {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	roles := make([]string, len(id.Roles))
+	for i, r := range id.Roles {
+		roles[i] = string(r)
+	}
+	return metadata.AppendToOutgoingContext(ctx,
+		subjectKey, id.Subject,
+		rolesKey, strings.Join(roles, ","))
+}
+
+/*
+MetadataMiddleware guards HTTP handlers that pass request headers on as
+gRPC metadata, such as a gRPC-Web wrapper around a gRPC server.  It drops
+the identity headers the client sent, so callers cannot claim any
+identity, and when v is not nil forwards the identity of a valid bearer
+token in their place.  A request without a token goes on without an
+identity; one with an invalid token is refused as Unauthenticated.
+*/
+func MetadataMiddleware(v *Verifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(subjectKey)
+		r.Header.Del(rolesKey)
+		if v == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, err := BearerToken(r)
+		if err == ErrNoToken {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var id Identity
+		if err == nil {
+			id, err = v.Verify(token)
+		}
+		if err != nil {
+			// a trailers-only gRPC response
+			w.Header().Set("content-type", "application/grpc")
+			w.Header().Set("grpc-status", strconv.Itoa(int(codes.Unauthenticated)))
+			w.Header().Set("grpc-message", "a valid bearer token is required")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		roles := make([]string, len(id.Roles))
+		for i, role := range id.Roles {
+			roles[i] = string(role)
+		}
+		r.Header.Set(subjectKey, id.Subject)
+		r.Header.Set(rolesKey, strings.Join(roles, ","))
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+/*
+ForwardIncoming makes the metadata of the call in ctx outgoing, less any
+identity the caller claimed, so a proxy can pass an untrusted caller's
+call on to the microservice.  The identity in ctx, if any, is forwarded
+by the client interceptors as usual.
+*/
+func ForwardIncoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	md = md.Copy()
+	delete(md, subjectKey)
+	delete(md, rolesKey)
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+/*
+Trust decides whether the caller of the call in ctx may forward an
+identity.  Any caller can put one in its metadata, so it is only
+believed from callers the server can tell apart from the public.
+*/
+type Trust func(ctx context.Context) bool
+
+/*
+VerifiedClient trusts callers that presented a client certificate the
+server verified, such as the REST service with mutual TLS
+*/
+func VerifiedClient(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && len(info.State.VerifiedChains) > 0
+}
+
+/*
+AnyCaller trusts every caller.  It is only for servers the public cannot
+reach with metadata of their own: one dialled in-process, or one behind
+MetadataMiddleware.
+*/
+func AnyCaller(ctx context.Context) bool {
+	return true
+}
+
+/*
+UnaryTrustInterceptor drops the identity an untrusted caller forwards,
+so the interceptors after it see an anonymous call
+*/
+func UnaryTrustInterceptor(trust Trust) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(distrust(ctx, trust), req)
+	}
+}
+
+/*
+StreamTrustInterceptor drops the identity an untrusted caller forwards
+on a stream
+*/
+func StreamTrustInterceptor(trust Trust) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := distrust(ss.Context(), trust)
+		if ctx == ss.Context() {
+			return handler(srv, ss)
+		}
+		return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// distrust removes the identity metadata from ctx unless trust allows it
+func distrust(ctx context.Context, trust Trust) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || trust(ctx) || len(md.Get(subjectKey)) == 0 && len(md.Get(rolesKey)) == 0 {
+		return ctx
+	}
+	md = md.Copy()
+	delete(md, subjectKey)
+	delete(md, rolesKey)
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+// incoming reads the identity forwarded by the REST service
+func incoming(ctx context.Context) (Identity, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return Identity{}, false
+	}
+	subjects := md.Get(subjectKey)
+	if len(subjects) == 0 || subjects[0] == "" {
+		return Identity{}, false
+	}
+	id := Identity{Subject: subjects[0]}
+	for _, value := range md.Get(rolesKey) {
+		for _, r := range strings.Split(value, ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				id.Roles = append(id.Roles, Role(r))
+			}
+		}
+	}
+	return id, true
+}
+
+/*
+Authorize checks the identity forwarded in ctx against the role policy
+requires for method, and returns ctx carrying that identity
+*/
+func (policy Policy) Authorize(ctx context.Context, method string) (context.Context, error) {
+	need, listed := policy[method]
+	if !listed {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not available", method)
+	}
+	id, ok := incoming(ctx)
+	if need == "" {
+		if ok {
+			ctx = NewContext(ctx, id)
+		}
+		return ctx, nil
+	}
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "caller identity is missing")
+	}
+	if !id.Has(need) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s requires the %s role", method, need)
+	}
+	return NewContext(ctx, id), nil
+}
+
+/*
+UnaryServerInterceptor enforces policy on unary calls
+*/
+func (policy Policy) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := policy.Authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+/*
+StreamServerInterceptor enforces policy on streaming calls
+*/
+func (policy Policy) StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := policy.Authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &identityStream{ServerStream: ss, ctx: ctx})
+}
+
+// identityStream replaces the context of a server stream
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+/*
+UnaryIdentityInterceptor attaches the identity forwarded by the REST
+service to the call's context without enforcing any policy, so handlers
+can still tailor their results to the caller
+*/
+func UnaryIdentityInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if id, ok := incoming(ctx); ok {
+		ctx = NewContext(ctx, id)
+	}
+	return handler(ctx, req)
+}
+
+/*
+StreamIdentityInterceptor attaches the forwarded identity to a stream's
+context without enforcing any policy
+*/
+func StreamIdentityInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	id, ok := incoming(ss.Context())
+	if !ok {
+		return handler(srv, ss)
+	}
+	return handler(srv, &identityStream{ServerStream: ss, ctx: NewContext(ss.Context(), id)})
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// ErrNoToken is returned when a request carries no bearer token
+var ErrNoToken = errors.New("auth: no bearer token")
+
+// signatureAlgorithms are the accepted JWT signing algorithms; tokens are
+// only ever verified with the public keys from the JWKS file
+var signatureAlgorithms = map[string]bool{
+	string(jose.RS256): true, string(jose.RS384): true, string(jose.RS512): true,
+	string(jose.PS256): true, string(jose.PS384): true, string(jose.PS512): true,
+	string(jose.ES256): true, string(jose.ES384): true, string(jose.ES512): true,
+	string(jose.EdDSA): true,
+}
+
+/*
+Verifier validates JWT bearer tokens signed with keys from a JWKS file
+*/
+type Verifier struct {
+	keys     *jose.JSONWebKeySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+// roleClaims holds the claims beyond the registered ones
+type roleClaims struct {
+	Roles []Role `json:"roles"`
+}
+
+/*
+NewVerifier loads the JSON Web Key Set at jwksPath.  Tokens must be
+signed by one of its keys, identified by the token's kid header, and
+carry issuer and audience when those are not empty.
+*/
+func NewVerifier(jwksPath, issuer, audience string) (*Verifier, error) {
+	b, err := os.ReadFile(jwksPath)
+	if err != nil {
+		return nil, fmt.Errorf("auth: reading JWKS: %v", err)
+	}
+	var keys jose.JSONWebKeySet
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("auth: parsing JWKS %s: %v", jwksPath, err)
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("auth: no keys in JWKS %s", jwksPath)
+	}
+	for _, k := range keys.Keys {
+		if !k.IsPublic() {
+			return nil, fmt.Errorf("auth: JWKS %s holds a private key (kid %q); only public keys belong there", jwksPath, k.KeyID)
+		}
+	}
+	return &Verifier{
+		keys:     &keys,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   time.Minute,
+		now:      time.Now,
+	}, nil
+}
+
+/*
+Verify checks the signature and claims of token and returns the
+identity it describes
+*/
+func (v *Verifier) Verify(token string) (Identity, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return Identity{}, fmt.Errorf("auth: malformed token: %v", err)
+	}
+	for _, h := range parsed.Headers {
+		if !signatureAlgorithms[h.Algorithm] {
+			return Identity{}, fmt.Errorf("auth: signing algorithm %q is not accepted", h.Algorithm)
+		}
+	}
+
+	var std jwt.Claims
+	var custom roleClaims
+	if err := parsed.Claims(v.keys, &std, &custom); err != nil {
+		return Identity{}, fmt.Errorf("auth: invalid signature: %v", err)
+	}
+	expected := jwt.Expected{Issuer: v.issuer, Time: v.now()}
+	if v.audience != "" {
+		expected.Audience = jwt.Audience{v.audience}
+	}
+	if err := std.ValidateWithLeeway(expected, v.leeway); err != nil {
+		return Identity{}, fmt.Errorf("auth: %v", err)
+	}
+	if std.Expiry == nil {
+		return Identity{}, errors.New("auth: token has no expiry")
+	}
+	if std.Subject == "" {
+		return Identity{}, errors.New("auth: token has no subject")
+	}
+	return Identity{Subject: std.Subject, Roles: custom.Roles}, nil
+}
+
+/*
+BearerToken returns the token from the Authorization header of r
+*/
+func BearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", ErrNoToken
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", errors.New("auth: Authorization header is not a bearer token")
+	}
+	return token, nil
+}
+
+/*
+Middleware rejects requests without a valid bearer token with a 401, and
+passes the caller's identity to the handler in the request context.
+Requests already authenticated by an earlier middleware pass through.
+*/
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := FromContext(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, err := BearerToken(r)
+		if err == nil {
+			var id Identity
+			if id, err = v.Verify(token); err == nil {
+				next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+				return
+			}
+		}
+		writeUnauthorized(w, err)
+	})
+}
+
+func writeUnauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("content-type", "application/json")
+	if err == ErrNoToken {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="cars"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="cars", error="invalid_token"`)
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{ "message": "a valid bearer token is required" }`))
+}
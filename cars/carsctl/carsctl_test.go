@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carservice"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/catalog"
+	"github.com/simrie/go-grpc-car-service/cars/importer"
+)
+
+// startService serves CarService with roles enforced and writes a
+// configuration file whose current target calls it as a manager
+func startService(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	s := grpc.NewServer(carservice.ServerOptions(true, auth.AnyCaller)...)
+	carspb.RegisterCarServiceServer(s, carservice.New(nil, catalog.Builtin(), false))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := "current_target: manager\ntargets:\n" +
+		"  manager:\n    address: " + lis.Addr().String() + "\n    plaintext: true\n    subject: m\n    roles: [manager]\n" +
+		"  viewer:\n    address: " + lis.Addr().String() + "\n    plaintext: true\n    subject: v\n    roles: [viewer]\n"
+	if err := os.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	return path
+}
+
+// carsctl runs a command line and returns its exit code and output
+func carsctl(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCreateUpdateDelete(t *testing.T) {
+	config := startService(t)
+
+	code, out, errOut := carsctl("", "-config", config, "-o", "json", "create", "-make", "Mazda", "-model", "CX-5", "-cost-cents", "2500000")
+	if code != 0 {
+		t.Fatalf("Failed! create exited %d: %s", code, errOut)
+	}
+	var created []carOutput
+	if err := json.Unmarshal([]byte(out), &created); err != nil || len(created) != 1 || created[0].AcquisitionCostCents != 2500000 {
+		t.Fatalf("Failed! unexpected create output %q: %v", out, err)
+	}
+	id := strconv.FormatInt(created[0].Id, 10)
+
+	// flags may follow the id
+	code, out, errOut = carsctl("", "-config", config, "update", id, "-margin-cents", "310050")
+	if code != 0 || !strings.Contains(out, "25000.00") || !strings.Contains(out, "3100.50") {
+		t.Errorf("Failed! update exited %d with %q %s", code, out, errOut)
+	}
+
+	code, _, errOut = carsctl("", "-config", config, "-target", "viewer", "delete", id)
+	if code != 1 || !strings.Contains(errOut, "PermissionDenied") {
+		t.Errorf("Failed! viewer delete exited %d with %q", code, errOut)
+	}
+	if code, _, errOut = carsctl("", "-config", config, "delete", id); code != 0 {
+		t.Errorf("Failed! delete exited %d: %s", code, errOut)
+	}
+	code, _, errOut = carsctl("", "-config", config, "get", id)
+	if code != 1 || !strings.Contains(errOut, "no car with id "+id) {
+		t.Errorf("Failed! get of a deleted car exited %d with %q", code, errOut)
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	config := startService(t)
+
+	code, out, errOut := carsctl("", "-config", config, "-o", "yaml", "list", "-make", "toyota", "-model", "rav4")
+	if code != 0 {
+		t.Fatalf("Failed! list exited %d: %s", code, errOut)
+	}
+	if !strings.Contains(out, "model: Rav4") || strings.Contains(out, "Camry") {
+		t.Errorf("Failed! unexpected list output %q", out)
+	}
+}
+
+func TestImport(t *testing.T) {
+	config := startService(t)
+
+	csv := "make,model,margin_cents\nKia,Soul,100\nKia,Soul,100\nKia,,5\n"
+	code, out, errOut := carsctl(csv, "-config", config, "import", "-dry-run", "-")
+	if code != 1 || !strings.Contains(errOut, "1 of 3 rows are invalid") {
+		t.Errorf("Failed! dry run exited %d with %q", code, errOut)
+	}
+	for _, want := range []string{"1    create", "repeats row 1", "make and model are required", "dry run, nothing changed: 1 created"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Failed! dry run report %q does not contain %q", out, want)
+		}
+	}
+
+	code, out, errOut = carsctl("make,model\nKia,Rio\n", "-config", config, "-o", "json", "import", "-")
+	var report importOutput
+	if err := json.Unmarshal([]byte(out), &report); code != 0 || err != nil {
+		t.Fatalf("Failed! import exited %d with %q %s", code, out, errOut)
+	}
+	if report.DryRun || report.Created != 1 || report.Rows[0].Car.Id == 0 {
+		t.Errorf("Failed! unexpected import report %+v", report)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	config := startService(t)
+
+	tests := [][]string{
+		{},
+		{"-config", config, "bogus"},
+		{"-config", config, "-o", "xml", "get", "1"},
+		{"-config", config, "get", "one"},
+		{"-config", config, "create", "-make", "Kia"},
+		{"-config", config, "update", "1"},
+		{"-config", config, "-target", "nobody", "get", "1"},
+		{"-config", filepath.Join(t.TempDir(), "missing.yaml"), "get", "1"},
+	}
+	for _, args := range tests {
+		if code, _, _ := carsctl("", args...); code != 2 {
+			t.Errorf("Failed! %q exited %d, want 2", args, code)
+		}
+	}
+}
+
+func TestTargetSelection(t *testing.T) {
+	cfg := &Config{
+		CurrentTarget: "staging",
+		Targets: map[string]Target{
+			"staging": {Address: "staging.example:443"},
+			"prod":    {Address: "prod.example:443", TLS: TargetTLS{ServerName: "cars.example"}},
+		},
+	}
+	if got, err := cfg.target(""); err != nil || got.Address != "staging.example:443" {
+		t.Errorf("Failed! current target is %v, %v", got, err)
+	}
+	if got, err := cfg.target("prod"); err != nil || got.serverName() != "cars.example" {
+		t.Errorf("Failed! prod target is %v, %v", got, err)
+	}
+	if got, err := (&Config{}).target(""); err != nil || got.Address != defaultTarget.Address {
+		t.Errorf("Failed! without configuration got %v, %v", got, err)
+	}
+	cfg.CurrentTarget = ""
+	if _, err := cfg.target(""); err == nil || !strings.Contains(err.Error(), "prod, staging") {
+		t.Errorf("Failed! expected an error naming the targets, got %v", err)
+	}
+}
+
+func TestExportedFilesImport(t *testing.T) {
+	config := startService(t)
+	file := filepath.Join(t.TempDir(), "cars.csv")
+
+	if code, _, errOut := carsctl("", "-config", config, "export", "-out", file); code != 0 {
+		t.Fatalf("Failed! export exited %d with %q", code, errOut)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if !strings.HasPrefix(string(b), strings.Join(importer.Columns, ",")+"\n") {
+		t.Errorf("Failed! unexpected export %q", b)
+	}
+	// an exported file imports without changing anything but the
+	// spelling of the seeded Rav4, which the catalog has as RAV4
+	code, out, errOut := carsctl("", "-config", config, "-o", "json", "import", "-dry-run", file)
+	var report importOutput
+	if err := json.Unmarshal([]byte(out), &report); code != 0 || err != nil {
+		t.Fatalf("Failed! import exited %d with %q %s", code, out, errOut)
+	}
+	if report.Unchanged == 0 || report.Updated != 1 || int(report.Unchanged+report.Updated) != len(report.Rows) {
+		t.Errorf("Failed! exported file imported as %+v", report)
+	}
+	for _, row := range report.Rows {
+		if row.Action == "update" && (row.Car == nil || row.Car.Id != 3 || row.Car.Model != "RAV4") {
+			t.Errorf("Failed! unexpected update %+v", row.Car)
+		}
+	}
+
+	code, out, _ = carsctl("", "-config", config, "export", "-format", "ndjson", "-columns", "id,model")
+	if code != 0 || !strings.HasPrefix(out, `{"id":1,"model":"F10"}`+"\n") {
+		t.Errorf("Failed! NDJSON export exited %d with %q", code, out)
+	}
+	code, _, errOut = carsctl("", "-config", config, "-target", "viewer", "export", "-columns", "id,margin_cents")
+	if code != 1 || !strings.Contains(errOut, "not visible") {
+		t.Errorf("Failed! viewer's export of costs exited %d with %q", code, errOut)
+	}
+	if code, _, _ := carsctl("", "-config", config, "export", "-out", "cars.xlsx", "-format", "xlsx"); code != 2 {
+		t.Errorf("Failed! export to an unknown format exited %d, want 2", code)
+	}
+}
+
+func TestDuplicatesAndMerge(t *testing.T) {
+	config := startService(t)
+
+	var ids []string
+	for _, mileage := range []string{"52000", "52400"} {
+		code, out, errOut := carsctl("", "-config", config, "-o", "json", "create", "-make", "Volvo", "-model", "XC60",
+			"-vin", "yv4a22rk5m1000001", "-year", "2021", "-color", "Black", "-mileage", mileage)
+		var cars []carOutput
+		if err := json.Unmarshal([]byte(out), &cars); code != 0 || err != nil {
+			t.Fatalf("Failed! create exited %d with %q %s", code, out, errOut)
+		}
+		if cars[0].Vin != "YV4A22RK5M1000001" {
+			t.Errorf("Failed! VIN stored as %q", cars[0].Vin)
+		}
+		ids = append(ids, strconv.FormatInt(cars[0].Id, 10))
+	}
+
+	code, out, errOut := carsctl("", "-config", config, "duplicates")
+	want := strings.Join([]string{"vin", ids[0], "Volvo", "XC60", ids[1], "Volvo", "XC60", "same VIN YV4A22RK5M1000001"}, " ")
+	if code != 0 || !strings.Contains(strings.Join(strings.Fields(out), " "), want) {
+		t.Errorf("Failed! duplicates exited %d with %q %s", code, out, errOut)
+	}
+	if code, _, _ := carsctl("", "-config", config, "-target", "viewer", "duplicates"); code != 1 {
+		t.Errorf("Failed! viewer's duplicates exited %d, want 1", code)
+	}
+
+	if code, out, errOut := carsctl("", "-config", config, "merge", ids[0], ids[1]); code != 0 || !strings.Contains(out, ids[1]) {
+		t.Errorf("Failed! merge exited %d with %q %s", code, out, errOut)
+	}
+	code, out, errOut = carsctl("", "-config", config, "-o", "json", "history", ids[0])
+	var merges []mergeOutput
+	if err := json.Unmarshal([]byte(out), &merges); code != 0 || err != nil {
+		t.Fatalf("Failed! history exited %d with %q %s", code, out, errOut)
+	}
+	if len(merges) != 1 || merges[0].After.Mileage != 52400 || merges[0].Merged[0].Mileage != 52400 || merges[0].Before.Mileage != 52000 {
+		t.Errorf("Failed! unexpected history %+v", merges)
+	}
+	if code, _, _ := carsctl("", "-config", config, "merge", ids[0]); code != 2 {
+		t.Errorf("Failed! merge without duplicates exited %d, want 2", code)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	config := startService(t)
+
+	code, out, errOut := carsctl("", "-config", config, "-target", "viewer", "makes")
+	if code != 0 || !strings.Contains(strings.Join(strings.Fields(out), " "), "Chevrolet Chevy") {
+		t.Errorf("Failed! makes exited %d with %q %s", code, out, errOut)
+	}
+	code, out, errOut = carsctl("", "-config", config, "-o", "json", "models", "vw")
+	var models []catalogOutput
+	if err := json.Unmarshal([]byte(out), &models); code != 0 || err != nil {
+		t.Fatalf("Failed! models exited %d with %q %s", code, out, errOut)
+	}
+	if len(models) == 0 || models[0].Name != "Golf" || len(models[0].Trims) == 0 {
+		t.Errorf("Failed! unexpected models %+v", models)
+	}
+	if code, _, errOut := carsctl("", "-config", config, "models", "Toyta"); code != 1 || !strings.Contains(errOut, `did you mean "Toyota"?`) {
+		t.Errorf("Failed! models of an unknown make exited %d with %q", code, errOut)
+	}
+
+	// writes take the catalog's spelling, and warn about what it lacks
+	code, out, errOut = carsctl("", "-config", config, "-o", "json", "create", "-make", "toyota", "-model", "rav 4")
+	var cars []carOutput
+	if err := json.Unmarshal([]byte(out), &cars); code != 0 || err != nil || errOut != "" {
+		t.Fatalf("Failed! create exited %d with %q %q", code, out, errOut)
+	}
+	if cars[0].Make != "Toyota" || cars[0].Model != "RAV4" {
+		t.Errorf("Failed! created car is a %s %s", cars[0].Make, cars[0].Model)
+	}
+	code, _, errOut = carsctl("", "-config", config, "update", strconv.FormatInt(cars[0].Id, 10), "-model", "Rav5")
+	if code != 0 || !strings.Contains(errOut, `warning: unknown Toyota model "Rav5", did you mean "RAV4"?`) {
+		t.Errorf("Failed! update exited %d with %q", code, errOut)
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+// errReported is returned for a command line mistake the flag package
+// has already reported
+var errReported = errors.New("usage already reported")
+
+// flags returns the flag set for the command being run
+func (c *cli) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.cmd.name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: carsctl %s %s\n\n%s\n", c.cmd.name, c.cmd.args, c.cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+/*
+parseFlags parses args with fs, allowing flags after positional
+arguments as well as before, and returns the positional arguments
+*/
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return nil, err
+			}
+			return nil, errReported
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, usagef("%q is not a car id", s)
+	}
+	return id, nil
+}
+
+func getCars(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(c.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usagef("at least one car id is required")
+	}
+	ids := make([]int64, len(args))
+	for i, arg := range args {
+		if ids[i], err = parseID(arg); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	res, err := c.client.BatchGetCars(ctx, &carspb.BatchGetCarsRequest{Ids: ids})
+	if err != nil {
+		return err
+	}
+	if err := printCars(c.stdout, c.format, res.Result); err != nil {
+		return err
+	}
+	if len(res.Result) < len(ids) {
+		found := map[int64]bool{}
+		for _, car := range res.Result {
+			found[car.Id] = true
+		}
+		var missing []string
+		for _, id := range ids {
+			if !found[id] {
+				missing = append(missing, strconv.FormatInt(id, 10))
+			}
+		}
+		return fmt.Errorf("no car with id %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// allCars returns the whole inventory
+func (c *cli) allCars(ctx context.Context) ([]*carspb.Car, error) {
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	res, err := c.client.CarWithDeadline(ctx, &carspb.CarWithDeadlineRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Result, nil
+}
+
+func listCars(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags()
+	make_ := fs.String("make", "", "only cars of this make, ignoring case")
+	model := fs.String("model", "", "only cars of this model, ignoring case")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef("unexpected argument %q", args[0])
+	}
+	cars, err := c.allCars(ctx)
+	if err != nil {
+		return err
+	}
+	var matched []*carspb.Car
+	for _, car := range cars {
+		if (*make_ == "" || strings.EqualFold(car.Make, *make_)) &&
+			(*model == "" || strings.EqualFold(car.Model, *model)) {
+			matched = append(matched, car)
+		}
+	}
+	return printCars(c.stdout, c.format, matched)
+}
+
+// carFlags are the flags setting a car's fields for create and update
+type carFlags struct {
+	fs      *flag.FlagSet
+	make    *string
+	model   *string
+	vin     *string
+	year    *int
+	color   *string
+	mileage *int64
+	cost    *int64
+	margin  *int64
+}
+
+func newCarFlags(fs *flag.FlagSet) carFlags {
+	return carFlags{
+		fs:      fs,
+		make:    fs.String("make", "", "the car's make"),
+		model:   fs.String("model", "", "the car's model"),
+		vin:     fs.String("vin", "", "the car's vehicle identification number"),
+		year:    fs.Int("year", 0, "the car's model year"),
+		color:   fs.String("color", "", "the car's color"),
+		mileage: fs.Int64("mileage", 0, "the car's mileage in miles"),
+		cost:    fs.Int64("cost-cents", 0, "acquisition cost in cents"),
+		margin:  fs.Int64("margin-cents", 0, "margin in cents"),
+	}
+}
+
+// apply sets the fields of car whose flags were given
+func (f carFlags) apply(car *carspb.Car) {
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "make":
+			car.Make = *f.make
+		case "model":
+			car.Model = *f.model
+		case "vin":
+			car.Vin = *f.vin
+		case "year":
+			car.Year = int32(*f.year)
+		case "color":
+			car.Color = *f.color
+		case "mileage":
+			car.Mileage = *f.mileage
+		case "cost-cents":
+			car.AcquisitionCostCents = *f.cost
+		case "margin-cents":
+			car.MarginCents = *f.margin
+		}
+	})
+}
+
+func createCar(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags()
+	f := newCarFlags(fs)
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef("unexpected argument %q", args[0])
+	}
+	if *f.make == "" || *f.model == "" {
+		return usagef("-make and -model are required")
+	}
+	car := &carspb.Car{}
+	f.apply(car)
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	res, err := c.client.CreateCar(ctx, &carspb.CreateCarRequest{Car: car})
+	if err != nil {
+		return err
+	}
+	c.warn(res.Warnings)
+	return printCars(c.stdout, c.format, []*carspb.Car{res.Result})
+}
+
+func updateCar(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags()
+	f := newCarFlags(fs)
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef("one car id is required")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	if fs.NFlag() == 0 {
+		return usagef("nothing to change; give at least one field")
+	}
+
+	// fields that are not given keep their current values
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	got, err := c.client.BatchGetCars(ctx, &carspb.BatchGetCarsRequest{Ids: []int64{id}})
+	if err != nil {
+		return err
+	}
+	if len(got.Result) == 0 {
+		return fmt.Errorf("no car with id %d", id)
+	}
+	car := got.Result[0]
+	f.apply(car)
+	res, err := c.client.UpdateCar(ctx, &carspb.UpdateCarRequest{Car: car})
+	if err != nil {
+		return err
+	}
+	c.warn(res.Warnings)
+	return printCars(c.stdout, c.format, []*carspb.Car{res.Result})
+}
+
+// warn reports the warnings the service gave about a car it stored
+func (c *cli) warn(warnings []string) {
+	for _, w := range warnings {
+		fmt.Fprintf(c.stderr, "carsctl %s: warning: %s\n", c.cmd.name, w)
+	}
+}
+
+func deleteCar(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(c.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef("one car id is required")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	res, err := c.client.DeleteCar(ctx, &carspb.DeleteCarRequest{Id: id})
+	if err != nil {
+		return err
+	}
+	return printCars(c.stdout, c.format, []*carspb.Car{res.Result})
+}
+
+/*
+importCars imports a CSV or JSON file with one ImportCars call and
+prints what each row did, or would do with -dry-run.  It fails when
+any row was invalid.
+*/
+func importCars(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags()
+	format := fs.String("format", "", "file format, csv or json (default from the file extension, else csv)")
+	dryRun := fs.Bool("dry-run", false, "report what would change without changing anything")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef("one file is required")
+	}
+	fileFmt, err := fileFormat(*format, args[0], importFormats)
+	if err != nil {
+		return usagef("%v", err)
+	}
+	var r io.Reader = c.stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	req := &carspb.ImportCarsRequest{Format: fileFmt, Data: b, DryRun: *dryRun}
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	res, err := c.client.ImportCars(ctx, req)
+	if err != nil {
+		return err
+	}
+	if err := printImport(c.stdout, c.format, res); err != nil {
+		return err
+	}
+	if res.Invalid > 0 {
+		return fmt.Errorf("%d of %d rows are invalid", res.Invalid, len(res.Rows))
+	}
+	return nil
+}
+
+func exportCars(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags()
+	format := fs.String("format", "", "file format, csv, ndjson or parquet (default from the -out extension, else csv)")
+	columns := fs.String("columns", "", "comma-separated columns to export, in order (default every column you may see)")
+	out := fs.String("out", "-", "file to write, or - for standard output")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef("unexpected argument %q", args[0])
+	}
+	req := &carspb.ExportCarsRequest{}
+	if req.Format, err = fileFormat(*format, *out, exportFormats); err != nil {
+		return usagef("%v", err)
+	}
+	for _, col := range strings.Split(*columns, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			req.Columns = append(req.Columns, col)
+		}
+	}
+
+	// the file is written as it arrives, however long that takes
+	stream, err := c.client.ExportCars(c.target.callContext(ctx), req)
+	if err != nil {
+		return err
+	}
+	if *out == "-" {
+		return writeChunks(c.stdout, stream)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := writeChunks(f, stream); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func findDuplicates(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags()
+	tolerance := fs.Int64("tolerance", 0, "how far apart, in miles, the mileages of likely duplicates may be (default 1000)")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef("unexpected argument %q", args[0])
+	}
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	res, err := c.client.FindDuplicates(ctx, &carspb.FindDuplicatesRequest{MileageTolerance: *tolerance})
+	if err != nil {
+		return err
+	}
+	return printDuplicates(c.stdout, c.format, res.Matches)
+}
+
+func mergeCars(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(c.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return usagef("a car id and at least one duplicate id are required")
+	}
+	req := &carspb.MergeCarsRequest{}
+	for i, arg := range args {
+		id, err := parseID(arg)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			req.Id = id
+		} else {
+			req.DuplicateIds = append(req.DuplicateIds, id)
+		}
+	}
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	res, err := c.client.MergeCars(ctx, req)
+	if err != nil {
+		return err
+	}
+	return printMerges(c.stdout, c.format, []*carspb.CarMerge{res.Merge})
+}
+
+func mergeHistory(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(c.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef("one car id is required")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	res, err := c.client.MergeHistory(ctx, &carspb.MergeHistoryRequest{Id: id})
+	if err != nil {
+		return err
+	}
+	return printMerges(c.stdout, c.format, res.Merges)
+}
+
+func listMakes(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(c.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef("unexpected argument %q", args[0])
+	}
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	res, err := c.client.ListMakes(ctx, &carspb.ListMakesRequest{})
+	if err != nil {
+		return err
+	}
+	return printMakes(c.stdout, c.format, res.Makes)
+}
+
+func listModels(ctx context.Context, c *cli, args []string) error {
+	args, err := parseFlags(c.flags(), args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return usagef("one make is required")
+	}
+	ctx, cancel := c.call(ctx)
+	defer cancel()
+	res, err := c.client.ListModels(ctx, &carspb.ListModelsRequest{Make: args[0]})
+	if err != nil {
+		return err
+	}
+	return printModels(c.stdout, c.format, res.Models)
+}
+
+/*
+watch prints inventory changes until interrupted or until the service
+ends the stream
+*/
+func watch(ctx context.Context, c *cli, args []string) error {
+	fs := c.flags()
+	after := fs.Uint64("after", 0, "start after this sequence number, replaying the changes since (default only new changes)")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef("unexpected argument %q", args[0])
+	}
+	stream, err := c.client.Watch(c.target.callContext(ctx), &carspb.WatchRequest{AfterSequence: *after})
+	if err != nil {
+		return err
+	}
+	for {
+		e, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil || err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := printEvent(c.stdout, c.format, e); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+// importFormats are the file formats import reads
+var importFormats = map[string]carspb.ImportFormat{
+	"csv":  carspb.ImportFormat_IMPORT_FORMAT_CSV,
+	"json": carspb.ImportFormat_IMPORT_FORMAT_JSON,
+}
+
+// exportFormats are the file formats export writes
+var exportFormats = map[string]carspb.ExportFormat{
+	"csv":     carspb.ExportFormat_EXPORT_FORMAT_CSV,
+	"ndjson":  carspb.ExportFormat_EXPORT_FORMAT_NDJSON,
+	"parquet": carspb.ExportFormat_EXPORT_FORMAT_PARQUET,
+}
+
+/*
+fileFormat returns format, or the format named by the extension of path
+when format is empty, checking it is one of known.  CSV is assumed when
+neither says.
+*/
+func fileFormat[F any](format, path string, known map[string]F) (F, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if _, ok := known[format]; !ok {
+			format = "csv"
+		}
+	}
+	f, ok := known[format]
+	if !ok {
+		names := make([]string, 0, len(known))
+		for name := range known {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		last := len(names) - 1
+		return f, fmt.Errorf("unknown file format %q, use %s or %s", format, strings.Join(names[:last], ", "), names[last])
+	}
+	return f, nil
+}
+
+// writeChunks writes the file stream sends to w, until it ends
+func writeChunks(w io.Writer, stream grpc.ServerStreamingClient[carspb.ExportChunk]) error {
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc/status"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+/*
+carsctl is a command-line client for CarService, for scripting and
+operations.  It calls the microservice, or the REST service when that
+serves gRPC, chosen from the targets in its configuration file.
+*/
+func main() {
+	// SIGINT or SIGTERM cancels the command, ending watch cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// cli is what every command runs with
+type cli struct {
+	cmd     *command
+	client  carspb.CarServiceClient
+	target  Target
+	format  string
+	timeout time.Duration
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+/*
+call returns the context for one call: the target's credentials and the
+-timeout deadline
+*/
+func (c *cli) call(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.target.callContext(ctx), c.timeout)
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = []command{
+	{"get", "ID...", "print the cars with the given ids", getCars},
+	{"list", "[-make MAKE] [-model MODEL]", "print every car, or those matching the filters", listCars},
+	{"create", "-make MAKE -model MODEL [-vin VIN] [-year YEAR] [-color COLOR] [-mileage MILES] [-cost-cents N] [-margin-cents N]", "add a car and print it with its new id", createCar},
+	{"update", "ID [-make MAKE] [-model MODEL] [-vin VIN] [-year YEAR] [-color COLOR] [-mileage MILES] [-cost-cents N] [-margin-cents N]", "change the given fields of a car", updateCar},
+	{"delete", "ID", "delete a car and print it as it was", deleteCar},
+	{"import", "[-format csv|json] [-dry-run] FILE", "add and update the cars in FILE, or standard input for -", importCars},
+	{"export", "[-format csv|ndjson|parquet] [-columns COLS] [-out FILE]", "write every car as CSV, NDJSON or Parquet", exportCars},
+	{"duplicates", "[-tolerance MILES]", "print the cars that are probably the same vehicle", findDuplicates},
+	{"merge", "ID DUPLICATE_ID...", "merge duplicates into a car, deleting them", mergeCars},
+	{"history", "ID", "print the merges a car was made from", mergeHistory},
+	{"makes", "", "print the makes in the catalog", listMakes},
+	{"models", "MAKE", "print a make's models in the catalog, with their trims", listModels},
+	{"watch", "[-after SEQUENCE]", "print inventory changes as they happen", watch},
+}
+
+// usageError is a mistake on the command line, reported with exit code 2
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+/*
+run runs the carsctl command line args and returns the exit code: 0 on
+success, 1 when a call fails and 2 for a usage mistake
+*/
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("carsctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", "", "configuration file naming the targets (default $CARSCTL_CONFIG, or carsctl/config.yaml in the user configuration directory)")
+	targetName := fs.String("target", "", "target to call, from the configuration file (default its current_target)")
+	format := fs.String("o", "table", "output format: "+strings.Join(formats, ", "))
+	timeout := fs.Duration("timeout", 30*time.Second, "deadline for each call; watch runs until interrupted and export until the file is written")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: carsctl [flags] COMMAND [command flags] [args]")
+		fmt.Fprintln(stderr, "\ncommands:")
+		tw := tabwriter.NewWriter(stderr, 0, 4, 2, ' ', 0)
+		for _, cmd := range commands {
+			fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+		}
+		tw.Flush()
+		fmt.Fprintln(stderr, "\nflags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if fs.NArg() == 0 || fs.Arg(0) == "help" {
+		fs.Usage()
+		if fs.NArg() == 0 {
+			return 2
+		}
+		return 0
+	}
+	if !validFormat(*format) {
+		fmt.Fprintf(stderr, "carsctl: unknown output format %q\n", *format)
+		return 2
+	}
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == fs.Arg(0) {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "carsctl: unknown command %q; run carsctl help for the list\n", fs.Arg(0))
+		return 2
+	}
+
+	path, explicit := *configPath, *configPath != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	cfg, err := loadConfig(path, explicit)
+	if err != nil {
+		fmt.Fprintf(stderr, "carsctl: %v\n", err)
+		return 2
+	}
+	target, err := cfg.target(*targetName)
+	if err != nil {
+		fmt.Fprintf(stderr, "carsctl: %v\n", err)
+		return 2
+	}
+	conn, err := target.dial()
+	if err != nil {
+		fmt.Fprintf(stderr, "carsctl: cannot connect to %s: %v\n", target.Address, err)
+		return 1
+	}
+	defer conn.Close()
+
+	c := &cli{
+		cmd:     cmd,
+		client:  carspb.NewCarServiceClient(conn),
+		target:  target,
+		format:  *format,
+		timeout: *timeout,
+		stdin:   stdin,
+		stdout:  stdout,
+		stderr:  stderr,
+	}
+	err = cmd.run(ctx, c, fs.Args()[1:])
+	var usage usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usage):
+		fmt.Fprintf(stderr, "carsctl %s: %v\nusage: carsctl %s %s\n", cmd.name, err, cmd.name, cmd.args)
+		return 2
+	case err == errReported:
+		return 2
+	case err == flag.ErrHelp:
+		return 0
+	}
+	fmt.Fprintf(stderr, "carsctl %s: %s\n", cmd.name, describe(err))
+	return 1
+}
+
+// describe turns a call's error into a message, leaving out the rpc
+// error wrapping gRPC statuses carry
+func describe(err error) string {
+	if st, ok := status.FromError(err); ok {
+		return fmt.Sprintf("%s: %s", st.Code(), st.Message())
+	}
+	return err.Error()
+}
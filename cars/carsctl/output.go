@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+// formats are the output formats carsctl can print in
+var formats = []string{"table", "json", "yaml"}
+
+func validFormat(format string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+carOutput is a car as carsctl prints it in JSON and YAML.  Cost fields
+the caller may not see come back as zero and are left out, as are
+vehicle details that are not known.
+*/
+type carOutput struct {
+	Id                   int64  `json:"id" yaml:"id"`
+	Make                 string `json:"make" yaml:"make"`
+	Model                string `json:"model" yaml:"model"`
+	Vin                  string `json:"vin,omitempty" yaml:"vin,omitempty"`
+	Year                 int32  `json:"year,omitempty" yaml:"year,omitempty"`
+	Color                string `json:"color,omitempty" yaml:"color,omitempty"`
+	Mileage              int64  `json:"mileage,omitempty" yaml:"mileage,omitempty"`
+	AcquisitionCostCents int64  `json:"acquisition_cost_cents,omitempty" yaml:"acquisition_cost_cents,omitempty"`
+	MarginCents          int64  `json:"margin_cents,omitempty" yaml:"margin_cents,omitempty"`
+}
+
+func newCarOutput(car *carspb.Car) carOutput {
+	return carOutput{
+		Id:                   car.GetId(),
+		Make:                 car.GetMake(),
+		Model:                car.GetModel(),
+		Vin:                  car.GetVin(),
+		Year:                 car.GetYear(),
+		Color:                car.GetColor(),
+		Mileage:              car.GetMileage(),
+		AcquisitionCostCents: car.GetAcquisitionCostCents(),
+		MarginCents:          car.GetMarginCents(),
+	}
+}
+
+// eventOutput is an inventory event as carsctl prints it
+type eventOutput struct {
+	Sequence uint64     `json:"sequence" yaml:"sequence"`
+	Type     string     `json:"type" yaml:"type"`
+	Time     time.Time  `json:"time" yaml:"time"`
+	Car      *carOutput `json:"car,omitempty" yaml:"car,omitempty"`
+	HoldID   string     `json:"hold_id,omitempty" yaml:"hold_id,omitempty"`
+	HeldBy   string     `json:"held_by,omitempty" yaml:"held_by,omitempty"`
+}
+
+func newEventOutput(e *carspb.InventoryEvent) eventOutput {
+	out := eventOutput{
+		Sequence: e.Sequence,
+		Type:     eventTypeName(e.Type),
+		Time:     e.Time.AsTime().UTC(),
+		HoldID:   e.Hold.GetId(),
+		HeldBy:   e.Hold.GetHeldBy(),
+	}
+	if e.Car != nil {
+		car := newCarOutput(e.Car)
+		out.Car = &car
+	}
+	return out
+}
+
+// eventTypeName turns EVENT_TYPE_CREATED into created
+func eventTypeName(t carspb.EventType) string {
+	return strings.ToLower(strings.TrimPrefix(t.String(), "EVENT_TYPE_"))
+}
+
+/*
+printCars writes cars to w in format.  JSON and YAML are a list, even of
+one car, so scripts see the same shape from every command.
+*/
+func printCars(w io.Writer, format string, cars []*carspb.Car) error {
+	outs := make([]carOutput, 0, len(cars))
+	for _, car := range cars {
+		outs = append(outs, newCarOutput(car))
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(outs)
+	case "yaml":
+		return yaml.NewEncoder(w).Encode(outs)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tMAKE\tMODEL\tYEAR\tCOLOR\tMILEAGE\tVIN\tCOST\tMARGIN")
+	for _, c := range outs {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.Id, c.Make, c.Model,
+			number(c.Year), text(c.Color), number(c.Mileage), text(c.Vin), dollars(c.AcquisitionCostCents), dollars(c.MarginCents))
+	}
+	return tw.Flush()
+}
+
+/*
+printEvent writes one event to w in format: a line of text for table,
+a line of JSON, or a YAML document
+*/
+func printEvent(w io.Writer, format string, e *carspb.InventoryEvent) error {
+	out := newEventOutput(e)
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(out)
+	case "yaml":
+		b, err := yaml.Marshal(out)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "---\n%s", b)
+		return err
+	}
+	line := fmt.Sprintf("%d\t%s\t%s", out.Sequence, out.Time.Format(time.RFC3339), out.Type)
+	if out.Car != nil {
+		line += fmt.Sprintf("\t%d %s %s", out.Car.Id, out.Car.Make, out.Car.Model)
+	}
+	if out.HeldBy != "" {
+		line += "\tby " + out.HeldBy
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
+
+// importOutput is an import's report as carsctl prints it
+type importOutput struct {
+	DryRun     bool              `json:"dry_run" yaml:"dry_run"`
+	Created    int32             `json:"created" yaml:"created"`
+	Updated    int32             `json:"updated" yaml:"updated"`
+	Unchanged  int32             `json:"unchanged" yaml:"unchanged"`
+	Duplicates int32             `json:"duplicates" yaml:"duplicates"`
+	Invalid    int32             `json:"invalid" yaml:"invalid"`
+	Rows       []importRowOutput `json:"rows" yaml:"rows"`
+}
+
+type importRowOutput struct {
+	Row         int32      `json:"row" yaml:"row"`
+	Action      string     `json:"action" yaml:"action"`
+	Car         *carOutput `json:"car,omitempty" yaml:"car,omitempty"`
+	Error       string     `json:"error,omitempty" yaml:"error,omitempty"`
+	DuplicateOf int32      `json:"duplicate_of,omitempty" yaml:"duplicate_of,omitempty"`
+	Warnings    []string   `json:"warnings,omitempty" yaml:"warnings,omitempty"`
+}
+
+/*
+printImport writes an import's report to w in format.  The table has a
+line per row and ends with the totals.
+*/
+func printImport(w io.Writer, format string, res *carspb.ImportCarsResponse) error {
+	out := importOutput{
+		DryRun:     res.DryRun,
+		Created:    res.Created,
+		Updated:    res.Updated,
+		Unchanged:  res.Unchanged,
+		Duplicates: res.Duplicates,
+		Invalid:    res.Invalid,
+		Rows:       make([]importRowOutput, 0, len(res.Rows)),
+	}
+	for _, r := range res.Rows {
+		row := importRowOutput{
+			Row:         r.Row,
+			Action:      strings.ToLower(strings.TrimPrefix(r.Action.String(), "IMPORT_ACTION_")),
+			Error:       r.Error,
+			DuplicateOf: r.DuplicateOf,
+			Warnings:    r.Warnings,
+		}
+		if r.Car != nil {
+			car := newCarOutput(r.Car)
+			row.Car = &car
+		}
+		out.Rows = append(out.Rows, row)
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	case "yaml":
+		return yaml.NewEncoder(w).Encode(out)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROW\tACTION\tID\tMAKE\tMODEL\tNOTE")
+	for _, row := range out.Rows {
+		var car carOutput
+		if row.Car != nil {
+			car = *row.Car
+		}
+		id := "-"
+		if car.Id != 0 {
+			id = fmt.Sprint(car.Id)
+		}
+		note := row.Error
+		if note == "" {
+			note = strings.Join(row.Warnings, "; ")
+		}
+		if row.DuplicateOf != 0 {
+			note = fmt.Sprintf("repeats row %d", row.DuplicateOf)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", row.Row, row.Action, id, car.Make, car.Model, note)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	verb := "imported"
+	if out.DryRun {
+		verb = "dry run, nothing changed"
+	}
+	_, err := fmt.Fprintf(w, "\n%s: %d created, %d updated, %d unchanged, %d duplicates, %d invalid\n",
+		verb, out.Created, out.Updated, out.Unchanged, out.Duplicates, out.Invalid)
+	return err
+}
+
+// duplicateOutput is a pair of probable duplicates as carsctl prints it
+type duplicateOutput struct {
+	Kind      string    `json:"kind" yaml:"kind"`
+	Car       carOutput `json:"car" yaml:"car"`
+	Duplicate carOutput `json:"duplicate" yaml:"duplicate"`
+	Reason    string    `json:"reason" yaml:"reason"`
+}
+
+/*
+printDuplicates writes pairs of probable duplicates to w in format, a
+line per pair in the table
+*/
+func printDuplicates(w io.Writer, format string, matches []*carspb.DuplicateMatch) error {
+	outs := make([]duplicateOutput, 0, len(matches))
+	for _, m := range matches {
+		outs = append(outs, duplicateOutput{
+			Kind:      strings.ToLower(strings.TrimPrefix(m.Kind.String(), "DUPLICATE_KIND_")),
+			Car:       newCarOutput(m.Car),
+			Duplicate: newCarOutput(m.Duplicate),
+			Reason:    m.Reason,
+		})
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(outs)
+	case "yaml":
+		return yaml.NewEncoder(w).Encode(outs)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tCAR\tDUPLICATE\tREASON")
+	for _, d := range outs {
+		fmt.Fprintf(tw, "%s\t%d %s %s\t%d %s %s\t%s\n", d.Kind, d.Car.Id, d.Car.Make, d.Car.Model,
+			d.Duplicate.Id, d.Duplicate.Make, d.Duplicate.Model, d.Reason)
+	}
+	return tw.Flush()
+}
+
+// mergeOutput is a merge as carsctl prints it
+type mergeOutput struct {
+	Time     time.Time   `json:"time" yaml:"time"`
+	MergedBy string      `json:"merged_by,omitempty" yaml:"merged_by,omitempty"`
+	Before   carOutput   `json:"before" yaml:"before"`
+	After    carOutput   `json:"after" yaml:"after"`
+	Merged   []carOutput `json:"merged" yaml:"merged"`
+}
+
+/*
+printMerges writes merges to w in format, a line per merge in the table
+naming the cars merged
+*/
+func printMerges(w io.Writer, format string, merges []*carspb.CarMerge) error {
+	outs := make([]mergeOutput, 0, len(merges))
+	for _, m := range merges {
+		out := mergeOutput{
+			Time:     m.Time.AsTime().UTC(),
+			MergedBy: m.MergedBy,
+			Before:   newCarOutput(m.Before),
+			After:    newCarOutput(m.After),
+		}
+		for _, car := range m.Merged {
+			out.Merged = append(out.Merged, newCarOutput(car))
+		}
+		outs = append(outs, out)
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(outs)
+	case "yaml":
+		return yaml.NewEncoder(w).Encode(outs)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tBY\tCAR\tMERGED")
+	for _, m := range outs {
+		ids := make([]string, len(m.Merged))
+		for i, car := range m.Merged {
+			ids[i] = fmt.Sprint(car.Id)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d %s %s\t%s\n", m.Time.Format(time.RFC3339), text(m.MergedBy), m.After.Id, m.After.Make, m.After.Model, strings.Join(ids, ", "))
+	}
+	return tw.Flush()
+}
+
+// catalogOutput is a make or model in the catalog as carsctl prints it
+type catalogOutput struct {
+	Name    string   `json:"name" yaml:"name"`
+	Aliases []string `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	Body    string   `json:"body,omitempty" yaml:"body,omitempty"`
+	Trims   []string `json:"trims,omitempty" yaml:"trims,omitempty"`
+}
+
+// printMakes writes the makes in the catalog to w in format
+func printMakes(w io.Writer, format string, makes []*carspb.CatalogMake) error {
+	outs := make([]catalogOutput, 0, len(makes))
+	for _, mk := range makes {
+		outs = append(outs, catalogOutput{Name: mk.Name, Aliases: mk.Aliases})
+	}
+	return printCatalog(w, format, outs, "MAKE", false)
+}
+
+// printModels writes a make's models in the catalog to w in format
+func printModels(w io.Writer, format string, models []*carspb.CatalogModel) error {
+	outs := make([]catalogOutput, 0, len(models))
+	for _, m := range models {
+		outs = append(outs, catalogOutput{Name: m.Name, Aliases: m.Aliases, Body: m.Body, Trims: m.Trims})
+	}
+	return printCatalog(w, format, outs, "MODEL", true)
+}
+
+/*
+printCatalog writes catalog entries to w in format.  The table has a
+line per entry, headed by name, with its aliases and, for models, its
+body style and trims comma-separated.
+*/
+func printCatalog(w io.Writer, format string, outs []catalogOutput, name string, models bool) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(outs)
+	case "yaml":
+		return yaml.NewEncoder(w).Encode(outs)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	header := name + "\tALIASES"
+	if models {
+		header += "\tBODY\tTRIMS"
+	}
+	fmt.Fprintln(tw, header)
+	for _, o := range outs {
+		line := o.Name + "\t" + text(strings.Join(o.Aliases, ", "))
+		if models {
+			line += "\t" + text(o.Body) + "\t" + text(strings.Join(o.Trims, ", "))
+		}
+		fmt.Fprintln(tw, line)
+	}
+	return tw.Flush()
+}
+
+// number formats a number for the table, with - for one not known
+func number[N int32 | int64](n N) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
+
+// text returns s for the table, with - for an empty one
+func text(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// dollars formats cents for the table, with - for a value not shown
+func dollars(cents int64) string {
+	if cents == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/simrie/go-grpc-car-service/cars/apikey"
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/tlsconfig"
+)
+
+/*
+Target is a CarService endpoint carsctl can call: the microservice
+itself, or the REST service when it serves gRPC on its own port
+*/
+type Target struct {
+	Address string `yaml:"address"`
+	// Plaintext turns TLS off, for a local microservice
+	Plaintext bool      `yaml:"plaintext,omitempty"`
+	TLS       TargetTLS `yaml:"tls,omitempty"`
+	// Token is a bearer token and APIKey a partner API key, both checked
+	// by the REST service
+	Token  string `yaml:"token,omitempty"`
+	APIKey string `yaml:"api_key,omitempty"`
+	// Subject and Roles are sent as the caller's identity, which the
+	// microservice only trusts from clients presenting a certificate
+	// signed by its client CA
+	Subject string      `yaml:"subject,omitempty"`
+	Roles   []auth.Role `yaml:"roles,omitempty"`
+}
+
+/*
+TargetTLS holds the files for calling a target over TLS.  The system
+roots verify the server when CAFile is empty.
+*/
+type TargetTLS struct {
+	CAFile     string `yaml:"ca_file,omitempty"`
+	CertFile   string `yaml:"cert_file,omitempty"`
+	KeyFile    string `yaml:"key_file,omitempty"`
+	ServerName string `yaml:"server_name,omitempty"`
+}
+
+/*
+Config is the carsctl configuration file, naming the targets and the
+one used when none is chosen on the command line
+*/
+type Config struct {
+	CurrentTarget string            `yaml:"current_target"`
+	Targets       map[string]Target `yaml:"targets"`
+}
+
+// defaultTarget is called when there is no configuration file: the
+// microservice as it runs locally out of the box
+var defaultTarget = Target{Address: "localhost:50051", Plaintext: true}
+
+/*
+defaultConfigPath returns $CARSCTL_CONFIG, or config.yaml in the carsctl
+directory under the user's configuration directory
+*/
+func defaultConfigPath() string {
+	if path := os.Getenv("CARSCTL_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "carsctl", "config.yaml")
+}
+
+/*
+loadConfig reads the configuration file at path.  A missing file is only
+an error when explicit is set, that is when the path was given.
+*/
+func loadConfig(path string, explicit bool) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+/*
+target returns the target called name, or the current target when name
+is empty.  Without any targets configured the local microservice is
+used.
+*/
+func (cfg *Config) target(name string) (Target, error) {
+	if name == "" {
+		name = cfg.CurrentTarget
+	}
+	if name == "" {
+		if len(cfg.Targets) == 0 {
+			return defaultTarget, nil
+		}
+		return Target{}, fmt.Errorf("no target chosen; use -target with one of %s, or set current_target", cfg.targetNames())
+	}
+	t, ok := cfg.Targets[name]
+	if !ok {
+		return Target{}, fmt.Errorf("unknown target %q; configured targets are %s", name, cfg.targetNames())
+	}
+	if t.Address == "" {
+		return Target{}, fmt.Errorf("target %q has no address", name)
+	}
+	return t, nil
+}
+
+func (cfg *Config) targetNames() string {
+	names := make([]string, 0, len(cfg.Targets))
+	for name := range cfg.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+/*
+dial connects to t.  No connection is made until the first call.
+*/
+func (t Target) dial() (*grpc.ClientConn, error) {
+	var creds credentials.TransportCredentials
+	if t.Plaintext {
+		creds = insecure.NewCredentials()
+	} else {
+		tlsCfg, err := tlsconfig.ClientConfig(tlsconfig.Files{
+			CertFile: t.TLS.CertFile,
+			KeyFile:  t.TLS.KeyFile,
+			CAFile:   t.TLS.CAFile,
+		}, t.serverName())
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsCfg)
+	}
+	return grpc.NewClient(t.Address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(auth.UnaryClientInterceptor),
+		grpc.WithStreamInterceptor(auth.StreamClientInterceptor))
+}
+
+func (t Target) serverName() string {
+	if t.TLS.ServerName != "" {
+		return t.TLS.ServerName
+	}
+	host := t.Address
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	return strings.Trim(host, "[]")
+}
+
+/*
+callContext adds the target's credentials to calls made with ctx
+*/
+func (t Target) callContext(ctx context.Context) context.Context {
+	if t.Token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+t.Token)
+	}
+	if t.APIKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(apikey.Header), t.APIKey)
+	}
+	if t.Subject != "" {
+		ctx = auth.NewContext(ctx, auth.Identity{Subject: t.Subject, Roles: t.Roles})
+	}
+	return ctx
+}
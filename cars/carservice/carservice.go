@@ -0,0 +1,600 @@
+/*
+Package carservice implements the CarService gRPC API over the data
+repository, for the microservice and for the REST service when it runs
+the microservice in-process.
+*/
+package carservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/catalog"
+	"github.com/simrie/go-grpc-car-service/cars/data"
+	"github.com/simrie/go-grpc-car-service/cars/metrics"
+	"github.com/simrie/go-grpc-car-service/cars/models"
+	"github.com/simrie/go-grpc-car-service/cars/tracing"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+/*
+Policy is the role each RPC requires of the caller identity forwarded
+by the REST service.  Health checks are open to everyone.
+*/
+var Policy = auth.Policy{
+	"/cars.CarService/Car":              auth.Viewer,
+	"/cars.CarService/CarWithDeadline":  auth.Viewer,
+	"/cars.CarService/BatchGetCars":     auth.Viewer,
+	"/cars.CarService/BatchGetListings": auth.Viewer,
+	"/cars.CarService/Watch":            auth.Viewer,
+	"/cars.CarService/PlaceHold":        auth.Sales,
+	"/cars.CarService/ReleaseHold":      auth.Sales,
+	"/cars.CarService/CreateCar":        auth.Manager,
+	"/cars.CarService/UpdateCar":        auth.Manager,
+	"/cars.CarService/DeleteCar":        auth.Manager,
+	"/cars.CarService/ImportCars":       auth.Manager,
+	"/cars.CarService/ExportCars":       auth.Viewer,
+	"/cars.CarService/FindDuplicates":   auth.Sales,
+	"/cars.CarService/MergeCars":        auth.Manager,
+	"/cars.CarService/MergeHistory":     auth.Viewer,
+	"/cars.CarService/ListMakes":        auth.Viewer,
+	"/cars.CarService/ListModels":       auth.Viewer,
+	"/grpc.health.v1.Health/Check":      "",
+	"/grpc.health.v1.Health/Watch":      "",
+}
+
+/*
+Server implements CarService over the data repository
+*/
+type Server struct {
+	carspb.UnimplementedCarServiceServer
+	// shutdown ends Watch streams, which would otherwise hold up a
+	// graceful stop; nil never fires
+	shutdown <-chan struct{}
+	// catalog is what car makes and models are checked against on
+	// write; nil checks nothing
+	catalog       *catalog.Catalog
+	rejectUnknown bool
+}
+
+/*
+New returns a CarService whose Watch streams end with Unavailable when
+shutdown is closed.  Cars written to it get their make and model from
+names; those it does not have are refused when rejectUnknown is set,
+and otherwise stored with a warning.
+*/
+func New(shutdown <-chan struct{}, names *catalog.Catalog, rejectUnknown bool) *Server {
+	return &Server{shutdown: shutdown, catalog: names, rejectUnknown: rejectUnknown}
+}
+
+/*
+ServerOptions returns the interceptors every CarService gRPC server
+runs: tracing, metrics and, with enforceRoles, the role Policy.  Without
+it the caller's identity is still attached to each call.  Identities are
+only taken from callers trust allows; the rest are anonymous.  Each call
+reports the visibility level its cars are filtered at.
+*/
+func ServerOptions(enforceRoles bool, trust auth.Trust) []grpc.ServerOption {
+	unaryInterceptors := []grpc.UnaryServerInterceptor{tracing.UnaryServerInterceptor, metrics.UnaryServerInterceptor, auth.UnaryTrustInterceptor(trust)}
+	streamInterceptors := []grpc.StreamServerInterceptor{tracing.StreamServerInterceptor, metrics.StreamServerInterceptor, auth.StreamTrustInterceptor(trust)}
+	if enforceRoles {
+		unaryInterceptors = append(unaryInterceptors, Policy.UnaryServerInterceptor)
+		streamInterceptors = append(streamInterceptors, Policy.StreamServerInterceptor)
+	} else {
+		// The caller's identity still decides which car fields it sees
+		unaryInterceptors = append(unaryInterceptors, auth.UnaryIdentityInterceptor)
+		streamInterceptors = append(streamInterceptors, auth.StreamIdentityInterceptor)
+	}
+	unaryInterceptors = append(unaryInterceptors, visibility.UnaryServerInterceptor)
+	streamInterceptors = append(streamInterceptors, visibility.StreamServerInterceptor)
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+}
+
+/*
+Register registers cars and the health service hs on s
+*/
+func Register(s *grpc.Server, cars *Server, hs *health.Server) {
+	carspb.RegisterCarServiceServer(s, cars)
+	healthpb.RegisterHealthServer(s, hs)
+}
+
+func (*Server) Car(ctx context.Context, req *carspb.CarRequest) (*carspb.CarResponse, error) {
+	fmt.Printf("Car function was invoked with %v\n", req)
+
+	id := req.Id
+
+	rec, err := data.GetRecordByIdContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// convert result to *carspb.Car
+	var result *carspb.Car
+	result, err = ConvertCarToCarpb(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &carspb.CarResponse{
+		Result: visibility.Car(visibility.LevelFromContext(ctx), result),
+	}
+	return res, nil
+}
+
+func (*Server) CarWithDeadline(ctx context.Context, req *carspb.CarWithDeadlineRequest) (*carspb.CarWithDeadlineResponse, error) {
+	fmt.Printf("CarsWithDeadline function was invoked with %v\n", req)
+
+	// Check to see if the timeout occurred
+	for i := 0; i < 3; i++ {
+		if ctx.Err() == context.Canceled {
+			msg := "Client cancelled the request (timeout)"
+			fmt.Println(msg)
+			return nil, status.Error(codes.DeadlineExceeded, msg)
+		}
+		time.Sleep(1 * time.Second)
+	}
+
+	recs, err := data.GetAllRecordsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// convert result to *carspb.Car
+	var results []*carspb.Car
+	for _, v := range recs {
+		var result *carspb.Car
+		result, err = ConvertCarToCarpb(v)
+		if err == nil {
+			results = append(results, result)
+		}
+	}
+
+	res := &carspb.CarWithDeadlineResponse{
+		Result: visibility.Cars(visibility.LevelFromContext(ctx), results),
+	}
+	return res, nil
+}
+
+// maxBatchGet is the most ids BatchGetCars looks up in one call
+const maxBatchGet = 1000
+
+/*
+BatchGetCars returns the cars with the requested ids in the order they
+were asked for, leaving out ids that match no car
+*/
+func (*Server) BatchGetCars(ctx context.Context, req *carspb.BatchGetCarsRequest) (*carspb.BatchGetCarsResponse, error) {
+	if len(req.Ids) > maxBatchGet {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d ids may be looked up at once", maxBatchGet)
+	}
+	recs, err := data.GetAllRecordsContext(ctx)
+	if err != nil {
+		if errors.Is(err, data.ErrClosed) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, err
+	}
+	byID := make(map[int64]models.Car, len(recs))
+	for _, rec := range recs {
+		byID[rec.Id] = rec
+	}
+	var results []*carspb.Car
+	for _, id := range req.Ids {
+		rec, ok := byID[id]
+		if !ok {
+			continue
+		}
+		result, err := ConvertCarToCarpb(rec)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return &carspb.BatchGetCarsResponse{
+		Result: visibility.Cars(visibility.LevelFromContext(ctx), results),
+	}, nil
+}
+
+/*
+BatchGetListings returns the dealer, photos and price history of the cars
+with the requested ids in the order they were asked for, leaving out ids
+that match no car
+*/
+func (*Server) BatchGetListings(ctx context.Context, req *carspb.BatchGetListingsRequest) (*carspb.BatchGetListingsResponse, error) {
+	if len(req.CarIds) > maxBatchGet {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d ids may be looked up at once", maxBatchGet)
+	}
+	listings, err := data.GetListings(ctx, req.CarIds)
+	if err != nil {
+		if errors.Is(err, data.ErrClosed) {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, err
+	}
+	res := &carspb.BatchGetListingsResponse{}
+	for _, listing := range listings {
+		res.Result = append(res.Result, ConvertListingToPb(listing))
+	}
+	return res, nil
+}
+
+/*
+Watch streams inventory changes to the caller, starting after the
+requested sequence number.  When those changes are no longer kept a
+reset event is sent first, telling the caller to reload the inventory.
+*/
+func (s *Server) Watch(req *carspb.WatchRequest, stream carspb.CarService_WatchServer) error {
+	ctx := stream.Context()
+	events, err := data.Subscribe(ctx, req.AfterSequence)
+	var reset *carspb.InventoryEvent
+	if errors.Is(err, data.ErrHistoryGone) {
+		reset = &carspb.InventoryEvent{
+			Sequence: data.LastSequence(),
+			Type:     carspb.EventType_EVENT_TYPE_RESET,
+			Time:     timestamppb.Now(),
+		}
+		events, err = data.Subscribe(ctx, 0)
+	}
+	if err != nil {
+		if errors.Is(err, data.ErrClosed) {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		return err
+	}
+	// let the caller know the subscription is in place
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	if reset != nil {
+		if err := stream.Send(reset); err != nil {
+			return err
+		}
+	}
+
+	level := visibility.LevelFromContext(ctx)
+	for {
+		select {
+		case <-s.shutdown:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return status.Error(codes.Unavailable, "change stream ended, resume from the last event")
+			}
+			pb, err := ConvertEventToPb(e)
+			if err != nil {
+				return err
+			}
+			pb.Car = visibility.Car(level, pb.Car)
+			if err := stream.Send(pb); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func ConvertEventToPb(e data.Event) (*carspb.InventoryEvent, error) {
+	car, err := ConvertCarToCarpb(e.Car)
+	if err != nil {
+		return nil, err
+	}
+	var t carspb.EventType
+	switch e.Type {
+	case data.Created:
+		t = carspb.EventType_EVENT_TYPE_CREATED
+	case data.Updated:
+		t = carspb.EventType_EVENT_TYPE_UPDATED
+	case data.Deleted:
+		t = carspb.EventType_EVENT_TYPE_DELETED
+	case data.Held:
+		t = carspb.EventType_EVENT_TYPE_HELD
+	case data.Released:
+		t = carspb.EventType_EVENT_TYPE_RELEASED
+	}
+	pb := &carspb.InventoryEvent{
+		Sequence: e.Sequence,
+		Type:     t,
+		Car:      car,
+		Time:     timestamppb.New(e.Time),
+	}
+	if e.Hold != nil {
+		pb.Hold = ConvertHoldToPb(*e.Hold)
+	}
+	return pb, nil
+}
+
+func ConvertListingToPb(listing models.Listing) *carspb.Listing {
+	pb := &carspb.Listing{CarId: listing.CarId}
+	if d := listing.Dealer; d != nil {
+		pb.Dealer = &carspb.Dealer{Id: d.Id, Name: d.Name, City: d.City, Phone: d.Phone}
+	}
+	for _, photo := range listing.Photos {
+		pb.Photos = append(pb.Photos, &carspb.Photo{Url: photo.URL, Caption: photo.Caption})
+	}
+	for _, price := range listing.PriceHistory {
+		pb.PriceHistory = append(pb.PriceHistory, &carspb.PricePoint{PriceCents: price.PriceCents, Time: timestamppb.New(price.Time)})
+	}
+	return pb
+}
+
+func ConvertHoldToPb(hold data.Hold) *carspb.Hold {
+	return &carspb.Hold{
+		Id:         hold.ID,
+		CarId:      hold.CarID,
+		HeldBy:     hold.HeldBy,
+		ExpireTime: timestamppb.New(hold.Expires),
+	}
+}
+
+const (
+	// defaultHoldDuration is used when a hold request gives no duration
+	defaultHoldDuration = 30 * time.Minute
+	maxHoldDuration     = 24 * time.Hour
+)
+
+/*
+PlaceHold puts a car on hold for the caller.  Only one hold can be
+placed on a car at a time.
+*/
+func (*Server) PlaceHold(ctx context.Context, req *carspb.PlaceHoldRequest) (*carspb.PlaceHoldResponse, error) {
+	d := defaultHoldDuration
+	if req.Duration != nil {
+		if err := req.Duration.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "duration is not valid")
+		}
+		d = req.Duration.AsDuration()
+	}
+	if d <= 0 || d > maxHoldDuration {
+		return nil, status.Errorf(codes.InvalidArgument, "duration must be greater than zero and at most %v", maxHoldDuration)
+	}
+	// a hold without a holder could be released by anyone
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "caller identity is missing")
+	}
+	hold, err := data.PlaceHold(ctx, req.CarId, id.Subject, d)
+	if err != nil {
+		return nil, holdError(err)
+	}
+	return &carspb.PlaceHoldResponse{Result: ConvertHoldToPb(hold)}, nil
+}
+
+/*
+ReleaseHold releases a hold.  Managers may release anyone's hold; other
+callers only their own.
+*/
+func (*Server) ReleaseHold(ctx context.Context, req *carspb.ReleaseHoldRequest) (*carspb.ReleaseHoldResponse, error) {
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "caller identity is missing")
+	}
+	hold, err := data.ReleaseHold(ctx, req.HoldId, id.Subject, id.Has(auth.Manager))
+	if err != nil {
+		return nil, holdError(err)
+	}
+	return &carspb.ReleaseHoldResponse{Result: ConvertHoldToPb(hold)}, nil
+}
+
+// holdError converts repository errors from hold calls to gRPC statuses
+func holdError(err error) error {
+	switch {
+	case errors.Is(err, data.ErrNotFound), errors.Is(err, data.ErrHoldNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, data.ErrAlreadyHeld):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, data.ErrNotHolder):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, data.ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
+
+/*
+CreateCar adds a car to the inventory and returns it with its new id.
+Its make and model are stored as the catalog spells them.
+*/
+func (s *Server) CreateCar(ctx context.Context, req *carspb.CreateCarRequest) (*carspb.CreateCarResponse, error) {
+	if err := validateCar(req.Car); err != nil {
+		return nil, err
+	}
+	car := ConvertCarpbToCar(req.Car)
+	warnings, err := s.normalize(&car)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "car "+err.Error())
+	}
+	car, err = data.CreateRecord(ctx, car)
+	if err != nil {
+		return nil, writeError(err)
+	}
+	result, err := ConvertCarToCarpb(car)
+	if err != nil {
+		return nil, err
+	}
+	return &carspb.CreateCarResponse{Result: visibility.Car(visibility.LevelFromContext(ctx), result), Warnings: warnings}, nil
+}
+
+/*
+UpdateCar replaces a car in the inventory, checking its make and model
+as CreateCar does.  Callers who may not see a car's exact cost data
+cannot change it.
+*/
+func (s *Server) UpdateCar(ctx context.Context, req *carspb.UpdateCarRequest) (*carspb.UpdateCarResponse, error) {
+	if err := validateCar(req.Car); err != nil {
+		return nil, err
+	}
+	if req.Car.Id <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "car id is required")
+	}
+	car := ConvertCarpbToCar(req.Car)
+	warnings, err := s.normalize(&car)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "car "+err.Error())
+	}
+	if visibility.LevelFromContext(ctx) < visibility.Full {
+		// the caller is not sent the exact cost data, so it cannot
+		// have changed it; what it sent back is kept as stored
+		stored, err := data.GetRecordByIdContext(ctx, car.Id)
+		if err != nil {
+			return nil, writeError(err)
+		}
+		if stored.Id == 0 {
+			return nil, status.Error(codes.NotFound, data.ErrNotFound.Error())
+		}
+		car.AcquisitionCostCents, car.MarginCents = stored.AcquisitionCostCents, stored.MarginCents
+	}
+	car, err = data.UpdateRecord(ctx, car)
+	if err != nil {
+		return nil, writeError(err)
+	}
+	result, err := ConvertCarToCarpb(car)
+	if err != nil {
+		return nil, err
+	}
+	return &carspb.UpdateCarResponse{Result: visibility.Car(visibility.LevelFromContext(ctx), result), Warnings: warnings}, nil
+}
+
+/*
+DeleteCar removes a car from the inventory.  Cars on hold cannot be
+deleted until the hold is released.
+*/
+func (*Server) DeleteCar(ctx context.Context, req *carspb.DeleteCarRequest) (*carspb.DeleteCarResponse, error) {
+	car, err := data.DeleteRecord(ctx, req.Id)
+	if err != nil {
+		return nil, writeError(err)
+	}
+	result, err := ConvertCarToCarpb(car)
+	if err != nil {
+		return nil, err
+	}
+	return &carspb.DeleteCarResponse{Result: visibility.Car(visibility.LevelFromContext(ctx), result)}, nil
+}
+
+// validateCar checks a car sent to be stored
+func validateCar(car *carspb.Car) error {
+	switch {
+	case car == nil:
+		return status.Error(codes.InvalidArgument, "car is required")
+	case strings.TrimSpace(car.Make) == "" || strings.TrimSpace(car.Model) == "":
+		return status.Error(codes.InvalidArgument, "car make and model are required")
+	case car.AcquisitionCostCents < 0 || car.MarginCents < 0:
+		return status.Error(codes.InvalidArgument, "car costs cannot be negative")
+	}
+	if err := models.CheckVehicle(ConvertCarpbToCar(car)); err != nil {
+		return status.Error(codes.InvalidArgument, "car "+err.Error())
+	}
+	return nil
+}
+
+// writeError converts repository errors from inventory changes to gRPC
+// statuses
+func writeError(err error) error {
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, data.ErrHeld):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, data.ErrClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
+
+func ConvertCarpbToCar(carpb *carspb.Car) models.Car {
+	var car models.Car
+	car.Id = carpb.GetId()
+	car.Make = strings.TrimSpace(carpb.GetMake())
+	car.Model = strings.TrimSpace(carpb.GetModel())
+	car.Vin = models.NormalizeVIN(carpb.GetVin())
+	car.Year = carpb.GetYear()
+	car.Color = strings.TrimSpace(carpb.GetColor())
+	car.Mileage = carpb.GetMileage()
+	car.AcquisitionCostCents = carpb.GetAcquisitionCostCents()
+	car.MarginCents = carpb.GetMarginCents()
+	return car
+}
+
+func ConvertCarToCarpb(car models.Car) (*carspb.Car, error) {
+	var carpb carspb.Car
+	carpb.Id = car.Id
+	carpb.Make = car.Make
+	carpb.Model = car.Model
+	carpb.Vin = car.Vin
+	carpb.Year = car.Year
+	carpb.Color = car.Color
+	carpb.Mileage = car.Mileage
+	carpb.AcquisitionCostCents = car.AcquisitionCostCents
+	carpb.MarginCents = car.MarginCents
+	return &carpb, nil
+}
+
+/*
+WatchRepositoryHealth checks the repository every interval and reports
+the result through the gRPC health service, both for CarService and
+for the server as a whole, until ctx is done
+*/
+func WatchRepositoryHealth(ctx context.Context, hs *health.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := data.Ping(pingCtx)
+		cancel()
+
+		servingStatus := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			log.Printf("repository health check failed: %v", err)
+			servingStatus = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus("", servingStatus)
+		hs.SetServingStatus(carspb.CarService_ServiceDesc.ServiceName, servingStatus)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+GracefulStop stops s from accepting new calls and waits up to grace for
+in-flight calls, including streams, to finish before cancelling them
+*/
+func GracefulStop(s *grpc.Server, grace time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+		log.Printf("all gRPC calls drained")
+	case <-timer.C:
+		log.Printf("grace period of %v expired, cancelling remaining gRPC calls", grace)
+		s.Stop()
+		<-stopped
+	}
+}
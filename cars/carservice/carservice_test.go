@@ -0,0 +1,520 @@
+package carservice
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/catalog"
+	"github.com/simrie/go-grpc-car-service/cars/data"
+)
+
+// blockingServer holds every Car call until release is closed
+type blockingServer struct {
+	carspb.UnimplementedCarServiceServer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingServer) Car(ctx context.Context, req *carspb.CarRequest) (*carspb.CarResponse, error) {
+	close(b.started)
+	select {
+	case <-b.release:
+		return &carspb.CarResponse{}, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func startBlockingServer(t *testing.T) (*grpc.Server, *blockingServer, carspb.CarServiceClient) {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	b := &blockingServer{started: make(chan struct{}), release: make(chan struct{})}
+	carspb.RegisterCarServiceServer(s, b)
+	go s.Serve(lis)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return s, b, carspb.NewCarServiceClient(conn)
+}
+
+func TestGracefulStopDrainsInFlightCalls(t *testing.T) {
+	s, b, client := startBlockingServer(t)
+
+	callErr := make(chan error, 1)
+	go func() {
+		_, err := client.Car(context.Background(), &carspb.CarRequest{Id: 1})
+		callErr <- err
+	}()
+	<-b.started
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(b.release)
+	}()
+	GracefulStop(s, 5*time.Second)
+
+	if err := <-callErr; err != nil {
+		t.Errorf("Failed! in-flight call should complete during the grace period, got %v", err)
+	}
+}
+
+func TestGracefulStopCancelsAfterGracePeriod(t *testing.T) {
+	s, b, client := startBlockingServer(t)
+
+	callErr := make(chan error, 1)
+	go func() {
+		_, err := client.Car(context.Background(), &carspb.CarRequest{Id: 1})
+		callErr <- err
+	}()
+	<-b.started
+
+	GracefulStop(s, 100*time.Millisecond)
+
+	if err := <-callErr; status.Code(err) == codes.OK {
+		t.Errorf("Failed! call still running after the grace period should be cancelled")
+	}
+}
+
+func TestCarHidesCostFromViewers(t *testing.T) {
+	viewer := auth.NewContext(context.Background(), auth.Identity{Subject: "v", Roles: []auth.Role{auth.Viewer}})
+	res, err := (&Server{}).Car(viewer, &carspb.CarRequest{Id: 1})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if res.Result.AcquisitionCostCents != 0 || res.Result.MarginCents != 0 {
+		t.Errorf("Failed! viewer was sent cost data: %v", res.Result)
+	}
+
+	manager := auth.NewContext(context.Background(), auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}})
+	res, err = (&Server{}).Car(manager, &carspb.CarRequest{Id: 1})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if res.Result.AcquisitionCostCents == 0 || res.Result.MarginCents == 0 {
+		t.Errorf("Failed! manager was not sent cost data: %v", res.Result)
+	}
+}
+
+func TestWatchResetsWhenHistoryIsGone(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	carspb.RegisterCarServiceServer(s, &Server{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// sequence numbers from an earlier run of the service
+	stream, err := carspb.NewCarServiceClient(conn).Watch(ctx, &carspb.WatchRequest{AfterSequence: 1 << 40})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	e, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if e.Type != carspb.EventType_EVENT_TYPE_RESET || e.Car != nil {
+		t.Errorf("Failed! got %v, want a reset event", e)
+	}
+}
+
+func TestHolds(t *testing.T) {
+	sales := auth.NewContext(context.Background(), auth.Identity{Subject: "kiosk-1", Roles: []auth.Role{auth.Sales}})
+	other := auth.NewContext(context.Background(), auth.Identity{Subject: "kiosk-2", Roles: []auth.Role{auth.Sales}})
+	manager := auth.NewContext(context.Background(), auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}})
+
+	if _, err := (&Server{}).PlaceHold(sales, &carspb.PlaceHoldRequest{CarId: 2, Duration: durationpb.New(48 * time.Hour)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Failed! expected InvalidArgument for a long hold, got %v", err)
+	}
+	if _, err := (&Server{}).PlaceHold(context.Background(), &carspb.PlaceHoldRequest{CarId: 2}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Failed! expected Unauthenticated for a hold without a caller, got %v", err)
+	}
+	if _, err := (&Server{}).PlaceHold(sales, &carspb.PlaceHoldRequest{CarId: 99}); status.Code(err) != codes.NotFound {
+		t.Errorf("Failed! expected NotFound for an unknown car, got %v", err)
+	}
+
+	res, err := (&Server{}).PlaceHold(sales, &carspb.PlaceHoldRequest{CarId: 2})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	hold := res.Result
+	if hold.HeldBy != "kiosk-1" || hold.CarId != 2 {
+		t.Errorf("Failed! unexpected hold %v", hold)
+	}
+	if _, err := (&Server{}).PlaceHold(other, &carspb.PlaceHoldRequest{CarId: 2}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Failed! expected FailedPrecondition for a held car, got %v", err)
+	}
+	if _, err := (&Server{}).ReleaseHold(other, &carspb.ReleaseHoldRequest{HoldId: hold.Id}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Failed! expected PermissionDenied releasing another's hold, got %v", err)
+	}
+	if _, err := (&Server{}).ReleaseHold(context.Background(), &carspb.ReleaseHoldRequest{HoldId: hold.Id}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Failed! expected Unauthenticated releasing without a caller, got %v", err)
+	}
+	if _, err := (&Server{}).ReleaseHold(manager, &carspb.ReleaseHoldRequest{HoldId: hold.Id}); err != nil {
+		t.Errorf("Failed! manager could not release the hold: %v", err)
+	}
+}
+
+func TestBatchGetCars(t *testing.T) {
+	res, err := (&Server{}).BatchGetCars(context.Background(), &carspb.BatchGetCarsRequest{Ids: []int64{3, 99, 1}})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if len(res.Result) != 2 || res.Result[0].Id != 3 || res.Result[1].Id != 1 {
+		t.Errorf("Failed! got %v, want cars 3 and 1 in that order", res.Result)
+	}
+	if res.Result[0].AcquisitionCostCents != 0 {
+		t.Errorf("Failed! anonymous caller was sent cost data: %v", res.Result[0])
+	}
+}
+
+func TestBatchGetListings(t *testing.T) {
+	res, err := (&Server{}).BatchGetListings(context.Background(), &carspb.BatchGetListingsRequest{CarIds: []int64{1, 99}})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if len(res.Result) != 1 || res.Result[0].CarId != 1 {
+		t.Fatalf("Failed! got %v, want the listing of car 1", res.Result)
+	}
+	listing := res.Result[0]
+	if listing.Dealer.GetName() == "" || len(listing.Photos) == 0 || len(listing.PriceHistory) == 0 || listing.PriceHistory[0].Time == nil {
+		t.Errorf("Failed! incomplete listing %v", listing)
+	}
+	if _, err := (&Server{}).BatchGetListings(context.Background(), &carspb.BatchGetListingsRequest{CarIds: make([]int64, maxBatchGet+1)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Failed! expected InvalidArgument for too many ids, got %v", err)
+	}
+}
+
+func TestInventoryWrites(t *testing.T) {
+	manager := auth.NewContext(context.Background(), auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}})
+
+	if _, err := (&Server{}).CreateCar(manager, &carspb.CreateCarRequest{Car: &carspb.Car{Make: "Mazda"}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Failed! expected InvalidArgument without a model, got %v", err)
+	}
+	if _, err := (&Server{}).CreateCar(manager, &carspb.CreateCarRequest{Car: &carspb.Car{Make: "Mazda", Model: "3", MarginCents: -1}}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Failed! expected InvalidArgument for a negative margin, got %v", err)
+	}
+
+	created, err := (&Server{}).CreateCar(manager, &carspb.CreateCarRequest{Car: &carspb.Car{Id: 1, Make: " Mazda ", Model: "CX-5", AcquisitionCostCents: 2500000}})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	car := created.Result
+	if car.Id <= 6 || car.Make != "Mazda" || car.AcquisitionCostCents != 2500000 {
+		t.Errorf("Failed! unexpected created car %v", car)
+	}
+
+	car.MarginCents = 300000
+	if _, err := (&Server{}).UpdateCar(manager, &carspb.UpdateCarRequest{Car: car}); err != nil {
+		t.Errorf("Failed! %v", err)
+	}
+	// a viewer was sent the car without its costs and sends it back so
+	viewer := auth.NewContext(context.Background(), auth.Identity{Subject: "v", Roles: []auth.Role{auth.Viewer}})
+	if _, err := (&Server{}).UpdateCar(viewer, &carspb.UpdateCarRequest{Car: &carspb.Car{Id: car.Id, Make: "Mazda", Model: "CX-5"}}); err != nil {
+		t.Errorf("Failed! %v", err)
+	}
+	if _, err := (&Server{}).UpdateCar(manager, &carspb.UpdateCarRequest{Car: &carspb.Car{Id: 99, Make: "Mazda", Model: "3"}}); status.Code(err) != codes.NotFound {
+		t.Errorf("Failed! expected NotFound updating an unknown car, got %v", err)
+	}
+
+	hold, err := (&Server{}).PlaceHold(manager, &carspb.PlaceHoldRequest{CarId: car.Id})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := (&Server{}).DeleteCar(manager, &carspb.DeleteCarRequest{Id: car.Id}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Failed! expected FailedPrecondition deleting a held car, got %v", err)
+	}
+	if _, err := (&Server{}).ReleaseHold(manager, &carspb.ReleaseHoldRequest{HoldId: hold.Result.Id}); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	deleted, err := (&Server{}).DeleteCar(manager, &carspb.DeleteCarRequest{Id: car.Id})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if deleted.Result.MarginCents != 300000 {
+		t.Errorf("Failed! deleted car %v does not carry the update", deleted.Result)
+	}
+	if _, err := (&Server{}).DeleteCar(manager, &carspb.DeleteCarRequest{Id: car.Id}); status.Code(err) != codes.NotFound {
+		t.Errorf("Failed! expected NotFound deleting twice, got %v", err)
+	}
+}
+
+func TestImportCars(t *testing.T) {
+	manager := auth.NewContext(context.Background(), auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}})
+	csv := "make,model,acquisition_cost_cents\nKia,Soul,1500000\nKia,Soul,1500000\nKia,,1\nHonda,Civic,1800000\n"
+
+	dry, err := (&Server{}).ImportCars(manager, &carspb.ImportCarsRequest{Format: carspb.ImportFormat_IMPORT_FORMAT_CSV, Data: []byte(csv), DryRun: true})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if !dry.DryRun || dry.Created != 2 || dry.Duplicates != 1 || dry.Invalid != 1 || dry.Rows[0].Car.Id != 0 {
+		t.Errorf("Failed! unexpected dry run report %v", dry)
+	}
+	if dry.Rows[1].DuplicateOf != 1 || dry.Rows[2].Error != "make and model are required" {
+		t.Errorf("Failed! unexpected dry run rows %v", dry.Rows)
+	}
+	before, _ := data.GetAllRecords()
+
+	res, err := (&Server{}).ImportCars(manager, &carspb.ImportCarsRequest{Format: carspb.ImportFormat_IMPORT_FORMAT_CSV, Data: []byte(csv)})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	after, _ := data.GetAllRecords()
+	if res.Created != 2 || len(after) != len(before)+2 {
+		t.Errorf("Failed! import created %d cars, inventory went from %d to %d", res.Created, len(before), len(after))
+	}
+	soul := res.Rows[0].Car
+	if soul.Id == 0 || soul.AcquisitionCostCents != 1500000 {
+		t.Errorf("Failed! unexpected imported car %v", soul)
+	}
+
+	// updates by id, sent as the REST API writes them
+	update := fmt.Sprintf(`[{"id": "%d", "make": "Kia", "model": "Soul EV", "acquisition_cost_cents": "1500000"}, {"id": 999, "make": "Kia", "model": "Rio"}]`, soul.Id)
+	res, err = (&Server{}).ImportCars(manager, &carspb.ImportCarsRequest{Format: carspb.ImportFormat_IMPORT_FORMAT_JSON, Data: []byte(update)})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if res.Updated != 1 || res.Invalid != 1 || res.Rows[0].Car.Model != "Soul EV" || res.Rows[1].Error != "no car with id 999" {
+		t.Errorf("Failed! unexpected update report %v", res)
+	}
+
+	// columns left out of the file keep what is stored, costs included
+	update = fmt.Sprintf("id,make,model,color\n%d,Kia,Soul EV,Red\n", soul.Id)
+	res, err = (&Server{}).ImportCars(manager, &carspb.ImportCarsRequest{Format: carspb.ImportFormat_IMPORT_FORMAT_CSV, Data: []byte(update)})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if car := res.Rows[0].Car; res.Updated != 1 || car.Color != "Red" || car.AcquisitionCostCents != 1500000 {
+		t.Errorf("Failed! unexpected update report %v", res)
+	}
+
+	if _, err := (&Server{}).ImportCars(manager, &carspb.ImportCarsRequest{Format: carspb.ImportFormat_IMPORT_FORMAT_CSV, Data: []byte("make,colour\n")}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Failed! expected InvalidArgument for an unknown column, got %v", err)
+	}
+	if _, err := (&Server{}).ImportCars(manager, &carspb.ImportCarsRequest{Data: []byte(csv)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Failed! expected InvalidArgument without a format, got %v", err)
+	}
+}
+
+// exportStream collects what ExportCars sends
+type exportStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	data   []byte
+	chunks int
+}
+
+func (s *exportStream) Context() context.Context { return s.ctx }
+
+func (s *exportStream) Send(c *carspb.ExportChunk) error {
+	s.data = append(s.data, c.Data...)
+	s.chunks++
+	return nil
+}
+
+func TestExportCars(t *testing.T) {
+	viewer := auth.NewContext(context.Background(), auth.Identity{Subject: "v", Roles: []auth.Role{auth.Viewer}})
+	manager := auth.NewContext(context.Background(), auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}})
+	inventory, _ := data.GetAllRecords()
+
+	out := &exportStream{ctx: viewer}
+	if err := (&Server{}).ExportCars(&carspb.ExportCarsRequest{Format: carspb.ExportFormat_EXPORT_FORMAT_CSV}, out); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out.data)), "\n")
+	if lines[0] != "id,make,model,vin,year,color,mileage" || len(lines) != len(inventory)+1 {
+		t.Errorf("Failed! viewer export is %q", out.data)
+	}
+
+	out = &exportStream{ctx: manager}
+	req := &carspb.ExportCarsRequest{Format: carspb.ExportFormat_EXPORT_FORMAT_NDJSON, Columns: []string{"id", "acquisition_cost_cents"}}
+	if err := (&Server{}).ExportCars(req, out); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	first := fmt.Sprintf(`{"id":%d,"acquisition_cost_cents":%d}`, inventory[0].Id, inventory[0].AcquisitionCostCents)
+	if !strings.HasPrefix(string(out.data), first+"\n") {
+		t.Errorf("Failed! manager export is %q", out.data)
+	}
+
+	if err := (&Server{}).ExportCars(req, &exportStream{ctx: viewer}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Failed! expected PermissionDenied for a hidden column, got %v", err)
+	}
+	req = &carspb.ExportCarsRequest{Format: carspb.ExportFormat_EXPORT_FORMAT_PARQUET, Columns: []string{"trim"}}
+	if err := (&Server{}).ExportCars(req, &exportStream{ctx: manager}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Failed! expected InvalidArgument for an unknown column, got %v", err)
+	}
+
+	// the file is sent in pieces as it is written
+	out = &exportStream{ctx: manager}
+	cw := &chunkWriter{stream: out}
+	cw.Write(make([]byte, exportChunkSize-1))
+	cw.Write([]byte("ab"))
+	cw.Write([]byte("c"))
+	if err := cw.flush(); err != nil || out.chunks != 2 || len(out.data) != exportChunkSize+2 {
+		t.Errorf("Failed! %d bytes sent in %d chunks", len(out.data), out.chunks)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	manager := auth.NewContext(context.Background(), auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}})
+	create := func(car *carspb.Car) *carspb.Car {
+		t.Helper()
+		res, err := (&Server{}).CreateCar(manager, &carspb.CreateCarRequest{Car: car})
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		return res.Result
+	}
+	first := create(&carspb.Car{Make: "Subaru", Model: "Outback", Vin: "4s4btadc0l3000001", Year: 2020, Color: "Green", Mileage: 40000, AcquisitionCostCents: 2200000})
+	second := create(&carspb.Car{Make: "Subaru", Model: "Outback", Vin: "4S4BTADC0L3000001", Mileage: 41000})
+	likely := create(&carspb.Car{Make: "subaru", Model: "outback", Year: 2020, Color: "green", Mileage: 40500})
+	other := create(&carspb.Car{Make: "Subaru", Model: "Outback", Vin: "4S4BTADC0L3000002"})
+
+	res, err := (&Server{}).FindDuplicates(manager, &carspb.FindDuplicatesRequest{})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	found := map[[2]int64]carspb.DuplicateKind{}
+	for _, m := range res.Matches {
+		found[[2]int64{m.Car.Id, m.Duplicate.Id}] = m.Kind
+	}
+	if found[[2]int64{first.Id, second.Id}] != carspb.DuplicateKind_DUPLICATE_KIND_VIN {
+		t.Errorf("Failed! VIN duplicate not found in %v", res.Matches)
+	}
+	if found[[2]int64{first.Id, likely.Id}] != carspb.DuplicateKind_DUPLICATE_KIND_LIKELY {
+		t.Errorf("Failed! likely duplicate not found in %v", res.Matches)
+	}
+	if _, err := (&Server{}).FindDuplicates(manager, &carspb.FindDuplicatesRequest{MileageTolerance: -1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Failed! expected InvalidArgument for a negative tolerance, got %v", err)
+	}
+
+	merged, err := (&Server{}).MergeCars(manager, &carspb.MergeCarsRequest{Id: first.Id, DuplicateIds: []int64{second.Id, likely.Id}})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if r := merged.Result; r.Id != first.Id || r.Mileage != 41000 || r.AcquisitionCostCents != 2200000 || merged.Merge.MergedBy != "m" {
+		t.Errorf("Failed! unexpected merge %v", merged)
+	}
+	if got, _ := data.GetRecordById(second.Id); got.Id != 0 {
+		t.Errorf("Failed! merged car %d is still stored", second.Id)
+	}
+	history, err := (&Server{}).MergeHistory(manager, &carspb.MergeHistoryRequest{Id: first.Id})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if len(history.Merges) != 1 || len(history.Merges[0].Merged) != 2 || history.Merges[0].Merged[1].Mileage != 40500 {
+		t.Errorf("Failed! unexpected history %v", history.Merges)
+	}
+
+	tests := []struct {
+		req  *carspb.MergeCarsRequest
+		want codes.Code
+	}{
+		{&carspb.MergeCarsRequest{Id: first.Id, DuplicateIds: []int64{other.Id}}, codes.FailedPrecondition},
+		{&carspb.MergeCarsRequest{Id: first.Id, DuplicateIds: []int64{second.Id}}, codes.NotFound},
+		{&carspb.MergeCarsRequest{Id: first.Id, DuplicateIds: []int64{first.Id}}, codes.InvalidArgument},
+		{&carspb.MergeCarsRequest{Id: first.Id}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		if _, err := (&Server{}).MergeCars(manager, tt.req); status.Code(err) != tt.want {
+			t.Errorf("Failed! merging %v: expected %v, got %v", tt.req.DuplicateIds, tt.want, err)
+		}
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	manager := auth.NewContext(context.Background(), auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}})
+	flagging := New(nil, catalog.Builtin(), false)
+	rejecting := New(nil, catalog.Builtin(), true)
+
+	makes, err := flagging.ListMakes(manager, &carspb.ListMakesRequest{})
+	if err != nil || len(makes.Makes) == 0 || makes.Makes[0].Name != "Chevrolet" || makes.Makes[0].Aliases[0] != "Chevy" {
+		t.Errorf("Failed! unexpected makes %v, %v", makes, err)
+	}
+	models, err := flagging.ListModels(manager, &carspb.ListModelsRequest{Make: "HONDA"})
+	if err != nil || models.Make != "Honda" || len(models.Models) == 0 || len(models.Models[0].Trims) == 0 {
+		t.Errorf("Failed! unexpected models %v, %v", models, err)
+	}
+	if _, err := flagging.ListModels(manager, &carspb.ListModelsRequest{Make: "Hnoda"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Failed! expected NotFound for an unknown make, got %v", err)
+	}
+	if _, err := (&Server{}).ListMakes(manager, &carspb.ListMakesRequest{}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("Failed! expected Unimplemented without a catalog, got %v", err)
+	}
+
+	// known names take the catalog's spelling in either mode
+	created, err := rejecting.CreateCar(manager, &carspb.CreateCarRequest{Car: &carspb.Car{Make: "chevy", Model: "silverado"}})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if created.Result.Make != "Chevrolet" || created.Result.Model != "Silverado" || created.Warnings != nil {
+		t.Errorf("Failed! unexpected created car %v", created)
+	}
+	defer flagging.DeleteCar(manager, &carspb.DeleteCarRequest{Id: created.Result.Id})
+
+	car := created.Result
+	car.Model = "Silverodo"
+	if _, err := rejecting.UpdateCar(manager, &carspb.UpdateCarRequest{Car: car}); status.Code(err) != codes.InvalidArgument ||
+		!strings.Contains(err.Error(), `did you mean "Silverado"?`) {
+		t.Errorf("Failed! expected InvalidArgument for an unknown model, got %v", err)
+	}
+	updated, err := flagging.UpdateCar(manager, &carspb.UpdateCarRequest{Car: car})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if updated.Result.Model != "Silverodo" || len(updated.Warnings) != 1 {
+		t.Errorf("Failed! unknown model should be stored with a warning, got %v", updated)
+	}
+
+	// the seeded cars are all in the catalog, so rejecting does not
+	// stop them being updated
+	seeded, err := flagging.Car(manager, &carspb.CarRequest{Id: 1})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := rejecting.UpdateCar(manager, &carspb.UpdateCarRequest{Car: seeded.Result}); err != nil {
+		t.Errorf("Failed! seeded %s %s was rejected: %v", seeded.Result.Make, seeded.Result.Model, err)
+	}
+
+	csv := "make,model\ntoyota,camry\nToyta,Camry\n"
+	res, err := flagging.ImportCars(manager, &carspb.ImportCarsRequest{Format: carspb.ImportFormat_IMPORT_FORMAT_CSV, Data: []byte(csv), DryRun: true})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if res.Created != 2 || res.Rows[0].Car.Make != "Toyota" || res.Rows[0].Warnings != nil || len(res.Rows[1].Warnings) != 1 {
+		t.Errorf("Failed! unexpected import report %v", res)
+	}
+	res, err = rejecting.ImportCars(manager, &carspb.ImportCarsRequest{Format: carspb.ImportFormat_IMPORT_FORMAT_CSV, Data: []byte(csv), DryRun: true})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if res.Created != 1 || res.Invalid != 1 || res.Rows[1].Error != `unknown make "Toyta", did you mean "Toyota"?` {
+		t.Errorf("Failed! unexpected import report %v", res)
+	}
+}
@@ -0,0 +1,61 @@
+package carservice
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+/*
+ListMakes returns the makes in the catalog, in catalog order
+*/
+func (s *Server) ListMakes(ctx context.Context, req *carspb.ListMakesRequest) (*carspb.ListMakesResponse, error) {
+	if s.catalog == nil {
+		return nil, status.Error(codes.Unimplemented, "no catalog is loaded")
+	}
+	res := &carspb.ListMakesResponse{}
+	for _, mk := range s.catalog.Makes() {
+		res.Makes = append(res.Makes, &carspb.CatalogMake{Name: mk.Name, Aliases: mk.Aliases})
+	}
+	return res, nil
+}
+
+/*
+ListModels returns the models of a make in the catalog, with their
+body styles and trims.  The make may be given by any of its names.
+*/
+func (s *Server) ListModels(ctx context.Context, req *carspb.ListModelsRequest) (*carspb.ListModelsResponse, error) {
+	if s.catalog == nil {
+		return nil, status.Error(codes.Unimplemented, "no catalog is loaded")
+	}
+	mk, err := s.catalog.Make(req.Make)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	res := &carspb.ListModelsResponse{Make: mk.Name}
+	for _, m := range mk.Models {
+		res.Models = append(res.Models, &carspb.CatalogModel{Name: m.Name, Aliases: m.Aliases, Trims: m.Trims, Body: m.Body})
+	}
+	return res, nil
+}
+
+/*
+normalize changes car's make and model to the catalog's spelling.  A
+make or model the catalog does not have is an error when the server
+rejects unknown values, and otherwise a warning; the car is left as it
+was given.  Without a catalog nothing is checked.
+*/
+func (s *Server) normalize(car *models.Car) (warnings []string, err error) {
+	if s.catalog == nil {
+		return nil, nil
+	}
+	car.Make, car.Model, err = s.catalog.Normalize(car.Make, car.Model)
+	if err != nil && !s.rejectUnknown {
+		return []string{err.Error()}, nil
+	}
+	return nil, err
+}
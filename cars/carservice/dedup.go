@@ -0,0 +1,139 @@
+package carservice
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/data"
+	"github.com/simrie/go-grpc-car-service/cars/dedup"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+)
+
+var duplicateKinds = map[dedup.Kind]carspb.DuplicateKind{
+	dedup.VIN:    carspb.DuplicateKind_DUPLICATE_KIND_VIN,
+	dedup.Likely: carspb.DuplicateKind_DUPLICATE_KIND_LIKELY,
+}
+
+/*
+FindDuplicates reports the pairs of cars in the inventory that are
+probably the same vehicle
+*/
+func (*Server) FindDuplicates(ctx context.Context, req *carspb.FindDuplicatesRequest) (*carspb.FindDuplicatesResponse, error) {
+	tolerance := req.MileageTolerance
+	switch {
+	case tolerance < 0:
+		return nil, status.Error(codes.InvalidArgument, "mileage tolerance cannot be negative")
+	case tolerance == 0:
+		tolerance = dedup.DefaultMileageTolerance
+	}
+	inventory, err := data.GetAllRecordsContext(ctx)
+	if err != nil {
+		return nil, writeError(err)
+	}
+
+	level := visibility.LevelFromContext(ctx)
+	res := &carspb.FindDuplicatesResponse{}
+	for _, m := range dedup.Find(inventory, tolerance) {
+		car, err := ConvertCarToCarpb(m.Car)
+		if err != nil {
+			return nil, err
+		}
+		dup, err := ConvertCarToCarpb(m.Duplicate)
+		if err != nil {
+			return nil, err
+		}
+		res.Matches = append(res.Matches, &carspb.DuplicateMatch{
+			Kind:      duplicateKinds[m.Kind],
+			Car:       visibility.Car(level, car),
+			Duplicate: visibility.Car(level, dup),
+			Reason:    m.Reason,
+		})
+	}
+	return res, nil
+}
+
+/*
+MergeCars merges duplicates into the car with req.Id: it keeps that car,
+fills in what it does not know from the duplicates and deletes them.
+The records merged are kept in its MergeHistory.  Cars with different
+VINs are not merged.
+*/
+func (*Server) MergeCars(ctx context.Context, req *carspb.MergeCarsRequest) (*carspb.MergeCarsResponse, error) {
+	if len(req.DuplicateIds) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one duplicate id is required")
+	}
+	seen := map[int64]bool{req.Id: true}
+	for _, id := range req.DuplicateIds {
+		if seen[id] {
+			return nil, status.Errorf(codes.InvalidArgument, "car %d is given more than once", id)
+		}
+		seen[id] = true
+	}
+
+	id, _ := auth.FromContext(ctx)
+	merge, err := data.MergeRecords(ctx, req.Id, req.DuplicateIds, id.Subject, dedup.Combine)
+	if errors.Is(err, dedup.ErrConflict) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, writeError(err)
+	}
+	pb, err := convertMerge(visibility.LevelFromContext(ctx), merge)
+	if err != nil {
+		return nil, err
+	}
+	return &carspb.MergeCarsResponse{Result: pb.After, Merge: pb}, nil
+}
+
+/*
+MergeHistory returns the merges that made the car with req.Id what it
+is, oldest first
+*/
+func (*Server) MergeHistory(ctx context.Context, req *carspb.MergeHistoryRequest) (*carspb.MergeHistoryResponse, error) {
+	merges, err := data.MergeHistory(ctx, req.Id)
+	if err != nil {
+		return nil, writeError(err)
+	}
+	level := visibility.LevelFromContext(ctx)
+	res := &carspb.MergeHistoryResponse{}
+	for _, m := range merges {
+		pb, err := convertMerge(level, m)
+		if err != nil {
+			return nil, err
+		}
+		res.Merges = append(res.Merges, pb)
+	}
+	return res, nil
+}
+
+// convertMerge converts m with its cars as a caller at level may see them
+func convertMerge(level visibility.Level, m data.Merge) (*carspb.CarMerge, error) {
+	before, err := ConvertCarToCarpb(m.Before)
+	if err != nil {
+		return nil, err
+	}
+	after, err := ConvertCarToCarpb(m.After)
+	if err != nil {
+		return nil, err
+	}
+	pb := &carspb.CarMerge{
+		Before:   visibility.Car(level, before),
+		After:    visibility.Car(level, after),
+		MergedBy: m.MergedBy,
+		Time:     timestamppb.New(m.Time),
+	}
+	for _, car := range m.Merged {
+		merged, err := ConvertCarToCarpb(car)
+		if err != nil {
+			return nil, err
+		}
+		pb.Merged = append(pb.Merged, visibility.Car(level, merged))
+	}
+	return pb, nil
+}
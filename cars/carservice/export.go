@@ -0,0 +1,114 @@
+package carservice
+
+import (
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/data"
+	"github.com/simrie/go-grpc-car-service/cars/exporter"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// exportPageSize is how many cars ExportCars reads at a time
+	exportPageSize = 100
+	// exportChunkSize is roughly how much of the file each ExportChunk carries
+	exportChunkSize = 32 << 10
+)
+
+var exportFormats = map[carspb.ExportFormat]exporter.Format{
+	carspb.ExportFormat_EXPORT_FORMAT_CSV:     exporter.CSV,
+	carspb.ExportFormat_EXPORT_FORMAT_NDJSON:  exporter.NDJSON,
+	carspb.ExportFormat_EXPORT_FORMAT_PARQUET: exporter.Parquet,
+}
+
+// hiddenColumns are the export columns a caller at each level may not see
+var hiddenColumns = map[visibility.Level]map[string]bool{
+	visibility.Public: {"acquisition_cost_cents": true, "margin_cents": true},
+	visibility.Coarse: {"acquisition_cost_cents": true},
+}
+
+/*
+ExportCars streams the inventory as a file, a page of cars at a time, so
+neither the inventory nor the file is ever held whole.  Cost columns are
+exported as the caller may see them; asking for one the caller may not
+see at all is refused.
+*/
+func (*Server) ExportCars(req *carspb.ExportCarsRequest, stream carspb.CarService_ExportCarsServer) error {
+	ctx := stream.Context()
+	format, ok := exportFormats[req.Format]
+	if !ok {
+		return status.Error(codes.InvalidArgument, "format must be CSV, NDJSON or PARQUET")
+	}
+	level := visibility.LevelFromContext(ctx)
+	columns := req.Columns
+	if len(columns) == 0 {
+		for _, c := range exporter.Columns {
+			if !hiddenColumns[level][c] {
+				columns = append(columns, c)
+			}
+		}
+	}
+	for _, c := range columns {
+		if hiddenColumns[level][c] {
+			return status.Errorf(codes.PermissionDenied, "column %q is not visible to the caller", c)
+		}
+	}
+
+	out := &chunkWriter{stream: stream}
+	w, err := exporter.NewWriter(out, format, columns)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	for after := int64(0); ; {
+		page, err := data.GetRecordsPage(ctx, after, exportPageSize)
+		if err != nil {
+			return writeError(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, rec := range page {
+			car, err := ConvertCarToCarpb(rec)
+			if err != nil {
+				return err
+			}
+			if err := w.Write(visibility.Car(level, car)); err != nil {
+				return err
+			}
+		}
+		after = page[len(page)-1].Id
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+// chunkWriter sends what is written to it as ExportChunks of about
+// exportChunkSize
+type chunkWriter struct {
+	stream carspb.CarService_ExportCarsServer
+	buf    []byte
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= exportChunkSize {
+		if err := cw.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// flush sends whatever is buffered
+func (cw *chunkWriter) flush() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	err := cw.stream.Send(&carspb.ExportChunk{Data: cw.buf})
+	cw.buf = nil
+	return err
+}
@@ -0,0 +1,130 @@
+package carservice
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/data"
+	"github.com/simrie/go-grpc-car-service/cars/importer"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+)
+
+// maxImportRows is the most cars one ImportCars call reads, which keeps
+// the changes within what Watch subscribers can resume from
+const maxImportRows = 1000
+
+var importActions = map[importer.Action]carspb.ImportAction{
+	importer.Create:    carspb.ImportAction_IMPORT_ACTION_CREATE,
+	importer.Update:    carspb.ImportAction_IMPORT_ACTION_UPDATE,
+	importer.Unchanged: carspb.ImportAction_IMPORT_ACTION_UNCHANGED,
+	importer.Duplicate: carspb.ImportAction_IMPORT_ACTION_DUPLICATE,
+	importer.Invalid:   carspb.ImportAction_IMPORT_ACTION_INVALID,
+}
+
+/*
+ImportCars adds and updates the cars in a CSV or JSON file.  Every row
+is reported with what it does; the valid ones are applied together
+unless the request is a dry run.  The import is aborted if a car it
+updates has been changed, deleted, put on hold or released since the
+rows were checked.  Makes and models are checked against the catalog as
+by CreateCar.  As with UpdateCar, callers who may not see exact cost
+data cannot change it on existing cars.
+*/
+func (s *Server) ImportCars(ctx context.Context, req *carspb.ImportCarsRequest) (*carspb.ImportCarsResponse, error) {
+	var format importer.Format
+	switch req.Format {
+	case carspb.ImportFormat_IMPORT_FORMAT_CSV:
+		format = importer.CSV
+	case carspb.ImportFormat_IMPORT_FORMAT_JSON:
+		format = importer.JSON
+	default:
+		return nil, status.Error(codes.InvalidArgument, "format must be CSV or JSON")
+	}
+	rows, err := importer.Parse(bytes.NewReader(req.Data), format, maxImportRows)
+	if errors.Is(err, importer.ErrTooManyRows) {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d cars may be imported at once", maxImportRows)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// warnings are kept by row number, as rows are read
+	warnings := map[int][]string{}
+	for i := range rows {
+		if rows[i].Err == nil {
+			warnings[rows[i].Number], rows[i].Err = s.normalize(&rows[i].Car)
+		}
+	}
+
+	snapshot, err := data.GetSnapshot(ctx)
+	if err != nil {
+		return nil, writeError(err)
+	}
+	inventory := snapshot.Cars
+	level := visibility.LevelFromContext(ctx)
+	if level < visibility.Full {
+		stored := make(map[int64]int, len(inventory))
+		for i, car := range inventory {
+			stored[car.Id] = i
+		}
+		for i := range rows {
+			if j, ok := stored[rows[i].Car.Id]; ok {
+				rows[i].Car.AcquisitionCostCents = inventory[j].AcquisitionCostCents
+				rows[i].Car.MarginCents = inventory[j].MarginCents
+			}
+		}
+	}
+	results := importer.Plan(rows, inventory)
+
+	if !req.DryRun {
+		applied, err := data.ApplyRecords(ctx, importer.Changes(results), snapshot)
+		if errors.Is(err, data.ErrNotFound) || errors.Is(err, data.ErrChanged) {
+			return nil, status.Error(codes.Aborted, "the inventory changed during the import; try again")
+		}
+		if err != nil {
+			return nil, writeError(err)
+		}
+		// the stored cars carry the ids given to new ones
+		for i := range results {
+			if results[i].Action == importer.Create || results[i].Action == importer.Update {
+				results[i].Car, applied = applied[0], applied[1:]
+			}
+		}
+	}
+
+	res := &carspb.ImportCarsResponse{DryRun: req.DryRun}
+	for _, r := range results {
+		car, err := ConvertCarToCarpb(r.Car)
+		if err != nil {
+			return nil, err
+		}
+		row := &carspb.ImportRowResult{
+			Row:         int32(r.Number),
+			Action:      importActions[r.Action],
+			Car:         visibility.Car(level, car),
+			DuplicateOf: int32(r.DuplicateOf),
+			Warnings:    warnings[r.Number],
+		}
+		if r.Err != nil {
+			row.Error = r.Err.Error()
+		}
+		res.Rows = append(res.Rows, row)
+		switch r.Action {
+		case importer.Create:
+			res.Created++
+		case importer.Update:
+			res.Updated++
+		case importer.Unchanged:
+			res.Unchanged++
+		case importer.Duplicate:
+			res.Duplicates++
+		case importer.Invalid:
+			res.Invalid++
+		}
+	}
+	return res, nil
+}
@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.15.8
+// source: cars/carspb/cars.proto
 
 package carspb
 
@@ -11,8 +15,28 @@ import (
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the grpc package it is being compiled against.
-// Requires gRPC-Go v1.32.0 or later.
-const _ = grpc.SupportPackageIsVersion7
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	CarService_Car_FullMethodName              = "/cars.CarService/Car"
+	CarService_CarWithDeadline_FullMethodName  = "/cars.CarService/CarWithDeadline"
+	CarService_BatchGetCars_FullMethodName     = "/cars.CarService/BatchGetCars"
+	CarService_BatchGetListings_FullMethodName = "/cars.CarService/BatchGetListings"
+	CarService_Watch_FullMethodName            = "/cars.CarService/Watch"
+	CarService_PlaceHold_FullMethodName        = "/cars.CarService/PlaceHold"
+	CarService_ReleaseHold_FullMethodName      = "/cars.CarService/ReleaseHold"
+	CarService_CreateCar_FullMethodName        = "/cars.CarService/CreateCar"
+	CarService_UpdateCar_FullMethodName        = "/cars.CarService/UpdateCar"
+	CarService_DeleteCar_FullMethodName        = "/cars.CarService/DeleteCar"
+	CarService_ImportCars_FullMethodName       = "/cars.CarService/ImportCars"
+	CarService_ExportCars_FullMethodName       = "/cars.CarService/ExportCars"
+	CarService_FindDuplicates_FullMethodName   = "/cars.CarService/FindDuplicates"
+	CarService_MergeCars_FullMethodName        = "/cars.CarService/MergeCars"
+	CarService_MergeHistory_FullMethodName     = "/cars.CarService/MergeHistory"
+	CarService_ListMakes_FullMethodName        = "/cars.CarService/ListMakes"
+	CarService_ListModels_FullMethodName       = "/cars.CarService/ListModels"
+)
 
 // CarServiceClient is the client API for CarService service.
 //
@@ -22,6 +46,44 @@ type CarServiceClient interface {
 	Car(ctx context.Context, in *CarRequest, opts ...grpc.CallOption) (*CarResponse, error)
 	// Unary with Deadline
 	CarWithDeadline(ctx context.Context, in *CarWithDeadlineRequest, opts ...grpc.CallOption) (*CarWithDeadlineResponse, error)
+	// Unary lookup of several cars at once, so callers resolving many
+	// ids make one call rather than one Car call each
+	BatchGetCars(ctx context.Context, in *BatchGetCarsRequest, opts ...grpc.CallOption) (*BatchGetCarsResponse, error)
+	// Unary lookup of the dealer, photos and price history of several
+	// cars at once
+	BatchGetListings(ctx context.Context, in *BatchGetListingsRequest, opts ...grpc.CallOption) (*BatchGetListingsResponse, error)
+	// Server streaming inventory changes.  Served to browsers as
+	// Server-Sent Events at /cars/events rather than transcoded.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[InventoryEvent], error)
+	// Unary holds, used by showroom clients over the WebSocket API
+	PlaceHold(ctx context.Context, in *PlaceHoldRequest, opts ...grpc.CallOption) (*PlaceHoldResponse, error)
+	ReleaseHold(ctx context.Context, in *ReleaseHoldRequest, opts ...grpc.CallOption) (*ReleaseHoldResponse, error)
+	// Unary inventory changes, for managers.  Each is published to
+	// Watch subscribers.
+	CreateCar(ctx context.Context, in *CreateCarRequest, opts ...grpc.CallOption) (*CreateCarResponse, error)
+	UpdateCar(ctx context.Context, in *UpdateCarRequest, opts ...grpc.CallOption) (*UpdateCarResponse, error)
+	DeleteCar(ctx context.Context, in *DeleteCarRequest, opts ...grpc.CallOption) (*DeleteCarResponse, error)
+	// Unary bulk import of a CSV or JSON file, for managers.  Valid rows
+	// are applied together, or not at all when the inventory changed
+	// under them; invalid rows are reported and skipped.  Served over
+	// REST at POST /cars:import.
+	ImportCars(ctx context.Context, in *ImportCarsRequest, opts ...grpc.CallOption) (*ImportCarsResponse, error)
+	// Server streaming export of the whole inventory as a CSV, NDJSON or
+	// Parquet file, written as the cars are read.  Served over REST at
+	// GET /cars:export.
+	ExportCars(ctx context.Context, in *ExportCarsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExportChunk], error)
+	// Unary duplicate listing detection.  FindDuplicates reports cars
+	// that are probably the same vehicle; MergeCars, for managers,
+	// combines them into one, and MergeHistory returns the records a car
+	// was merged from.
+	FindDuplicates(ctx context.Context, in *FindDuplicatesRequest, opts ...grpc.CallOption) (*FindDuplicatesResponse, error)
+	MergeCars(ctx context.Context, in *MergeCarsRequest, opts ...grpc.CallOption) (*MergeCarsResponse, error)
+	MergeHistory(ctx context.Context, in *MergeHistoryRequest, opts ...grpc.CallOption) (*MergeHistoryResponse, error)
+	// Unary catalog of the makes and models cars are written with.  Car
+	// writes change makes and models to the catalog's spelling, and
+	// warn about or refuse those it does not have.
+	ListMakes(ctx context.Context, in *ListMakesRequest, opts ...grpc.CallOption) (*ListMakesResponse, error)
+	ListModels(ctx context.Context, in *ListModelsRequest, opts ...grpc.CallOption) (*ListModelsResponse, error)
 }
 
 type carServiceClient struct {
@@ -33,8 +95,9 @@ func NewCarServiceClient(cc grpc.ClientConnInterface) CarServiceClient {
 }
 
 func (c *carServiceClient) Car(ctx context.Context, in *CarRequest, opts ...grpc.CallOption) (*CarResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(CarResponse)
-	err := c.cc.Invoke(ctx, "/cars.CarService/Car", in, out, opts...)
+	err := c.cc.Invoke(ctx, CarService_Car_FullMethodName, in, out, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -42,8 +105,177 @@ func (c *carServiceClient) Car(ctx context.Context, in *CarRequest, opts ...grpc
 }
 
 func (c *carServiceClient) CarWithDeadline(ctx context.Context, in *CarWithDeadlineRequest, opts ...grpc.CallOption) (*CarWithDeadlineResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(CarWithDeadlineResponse)
-	err := c.cc.Invoke(ctx, "/cars.CarService/CarWithDeadline", in, out, opts...)
+	err := c.cc.Invoke(ctx, CarService_CarWithDeadline_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) BatchGetCars(ctx context.Context, in *BatchGetCarsRequest, opts ...grpc.CallOption) (*BatchGetCarsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchGetCarsResponse)
+	err := c.cc.Invoke(ctx, CarService_BatchGetCars_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) BatchGetListings(ctx context.Context, in *BatchGetListingsRequest, opts ...grpc.CallOption) (*BatchGetListingsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchGetListingsResponse)
+	err := c.cc.Invoke(ctx, CarService_BatchGetListings_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[InventoryEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CarService_ServiceDesc.Streams[0], CarService_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, InventoryEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CarService_WatchClient = grpc.ServerStreamingClient[InventoryEvent]
+
+func (c *carServiceClient) PlaceHold(ctx context.Context, in *PlaceHoldRequest, opts ...grpc.CallOption) (*PlaceHoldResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PlaceHoldResponse)
+	err := c.cc.Invoke(ctx, CarService_PlaceHold_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) ReleaseHold(ctx context.Context, in *ReleaseHoldRequest, opts ...grpc.CallOption) (*ReleaseHoldResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReleaseHoldResponse)
+	err := c.cc.Invoke(ctx, CarService_ReleaseHold_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) CreateCar(ctx context.Context, in *CreateCarRequest, opts ...grpc.CallOption) (*CreateCarResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateCarResponse)
+	err := c.cc.Invoke(ctx, CarService_CreateCar_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) UpdateCar(ctx context.Context, in *UpdateCarRequest, opts ...grpc.CallOption) (*UpdateCarResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpdateCarResponse)
+	err := c.cc.Invoke(ctx, CarService_UpdateCar_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) DeleteCar(ctx context.Context, in *DeleteCarRequest, opts ...grpc.CallOption) (*DeleteCarResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteCarResponse)
+	err := c.cc.Invoke(ctx, CarService_DeleteCar_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) ImportCars(ctx context.Context, in *ImportCarsRequest, opts ...grpc.CallOption) (*ImportCarsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ImportCarsResponse)
+	err := c.cc.Invoke(ctx, CarService_ImportCars_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) ExportCars(ctx context.Context, in *ExportCarsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ExportChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CarService_ServiceDesc.Streams[1], CarService_ExportCars_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExportCarsRequest, ExportChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CarService_ExportCarsClient = grpc.ServerStreamingClient[ExportChunk]
+
+func (c *carServiceClient) FindDuplicates(ctx context.Context, in *FindDuplicatesRequest, opts ...grpc.CallOption) (*FindDuplicatesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FindDuplicatesResponse)
+	err := c.cc.Invoke(ctx, CarService_FindDuplicates_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) MergeCars(ctx context.Context, in *MergeCarsRequest, opts ...grpc.CallOption) (*MergeCarsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MergeCarsResponse)
+	err := c.cc.Invoke(ctx, CarService_MergeCars_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) MergeHistory(ctx context.Context, in *MergeHistoryRequest, opts ...grpc.CallOption) (*MergeHistoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MergeHistoryResponse)
+	err := c.cc.Invoke(ctx, CarService_MergeHistory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) ListMakes(ctx context.Context, in *ListMakesRequest, opts ...grpc.CallOption) (*ListMakesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMakesResponse)
+	err := c.cc.Invoke(ctx, CarService_ListMakes_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *carServiceClient) ListModels(ctx context.Context, in *ListModelsRequest, opts ...grpc.CallOption) (*ListModelsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListModelsResponse)
+	err := c.cc.Invoke(ctx, CarService_ListModels_FullMethodName, in, out, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -52,18 +284,59 @@ func (c *carServiceClient) CarWithDeadline(ctx context.Context, in *CarWithDeadl
 
 // CarServiceServer is the server API for CarService service.
 // All implementations must embed UnimplementedCarServiceServer
-// for forward compatibility
+// for forward compatibility.
 type CarServiceServer interface {
 	// Unary
 	Car(context.Context, *CarRequest) (*CarResponse, error)
 	// Unary with Deadline
 	CarWithDeadline(context.Context, *CarWithDeadlineRequest) (*CarWithDeadlineResponse, error)
+	// Unary lookup of several cars at once, so callers resolving many
+	// ids make one call rather than one Car call each
+	BatchGetCars(context.Context, *BatchGetCarsRequest) (*BatchGetCarsResponse, error)
+	// Unary lookup of the dealer, photos and price history of several
+	// cars at once
+	BatchGetListings(context.Context, *BatchGetListingsRequest) (*BatchGetListingsResponse, error)
+	// Server streaming inventory changes.  Served to browsers as
+	// Server-Sent Events at /cars/events rather than transcoded.
+	Watch(*WatchRequest, grpc.ServerStreamingServer[InventoryEvent]) error
+	// Unary holds, used by showroom clients over the WebSocket API
+	PlaceHold(context.Context, *PlaceHoldRequest) (*PlaceHoldResponse, error)
+	ReleaseHold(context.Context, *ReleaseHoldRequest) (*ReleaseHoldResponse, error)
+	// Unary inventory changes, for managers.  Each is published to
+	// Watch subscribers.
+	CreateCar(context.Context, *CreateCarRequest) (*CreateCarResponse, error)
+	UpdateCar(context.Context, *UpdateCarRequest) (*UpdateCarResponse, error)
+	DeleteCar(context.Context, *DeleteCarRequest) (*DeleteCarResponse, error)
+	// Unary bulk import of a CSV or JSON file, for managers.  Valid rows
+	// are applied together, or not at all when the inventory changed
+	// under them; invalid rows are reported and skipped.  Served over
+	// REST at POST /cars:import.
+	ImportCars(context.Context, *ImportCarsRequest) (*ImportCarsResponse, error)
+	// Server streaming export of the whole inventory as a CSV, NDJSON or
+	// Parquet file, written as the cars are read.  Served over REST at
+	// GET /cars:export.
+	ExportCars(*ExportCarsRequest, grpc.ServerStreamingServer[ExportChunk]) error
+	// Unary duplicate listing detection.  FindDuplicates reports cars
+	// that are probably the same vehicle; MergeCars, for managers,
+	// combines them into one, and MergeHistory returns the records a car
+	// was merged from.
+	FindDuplicates(context.Context, *FindDuplicatesRequest) (*FindDuplicatesResponse, error)
+	MergeCars(context.Context, *MergeCarsRequest) (*MergeCarsResponse, error)
+	MergeHistory(context.Context, *MergeHistoryRequest) (*MergeHistoryResponse, error)
+	// Unary catalog of the makes and models cars are written with.  Car
+	// writes change makes and models to the catalog's spelling, and
+	// warn about or refuse those it does not have.
+	ListMakes(context.Context, *ListMakesRequest) (*ListMakesResponse, error)
+	ListModels(context.Context, *ListModelsRequest) (*ListModelsResponse, error)
 	mustEmbedUnimplementedCarServiceServer()
 }
 
-// UnimplementedCarServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedCarServiceServer struct {
-}
+// UnimplementedCarServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCarServiceServer struct{}
 
 func (UnimplementedCarServiceServer) Car(context.Context, *CarRequest) (*CarResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Car not implemented")
@@ -71,7 +344,53 @@ func (UnimplementedCarServiceServer) Car(context.Context, *CarRequest) (*CarResp
 func (UnimplementedCarServiceServer) CarWithDeadline(context.Context, *CarWithDeadlineRequest) (*CarWithDeadlineResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CarWithDeadline not implemented")
 }
+func (UnimplementedCarServiceServer) BatchGetCars(context.Context, *BatchGetCarsRequest) (*BatchGetCarsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchGetCars not implemented")
+}
+func (UnimplementedCarServiceServer) BatchGetListings(context.Context, *BatchGetListingsRequest) (*BatchGetListingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchGetListings not implemented")
+}
+func (UnimplementedCarServiceServer) Watch(*WatchRequest, grpc.ServerStreamingServer[InventoryEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedCarServiceServer) PlaceHold(context.Context, *PlaceHoldRequest) (*PlaceHoldResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PlaceHold not implemented")
+}
+func (UnimplementedCarServiceServer) ReleaseHold(context.Context, *ReleaseHoldRequest) (*ReleaseHoldResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseHold not implemented")
+}
+func (UnimplementedCarServiceServer) CreateCar(context.Context, *CreateCarRequest) (*CreateCarResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateCar not implemented")
+}
+func (UnimplementedCarServiceServer) UpdateCar(context.Context, *UpdateCarRequest) (*UpdateCarResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateCar not implemented")
+}
+func (UnimplementedCarServiceServer) DeleteCar(context.Context, *DeleteCarRequest) (*DeleteCarResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteCar not implemented")
+}
+func (UnimplementedCarServiceServer) ImportCars(context.Context, *ImportCarsRequest) (*ImportCarsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImportCars not implemented")
+}
+func (UnimplementedCarServiceServer) ExportCars(*ExportCarsRequest, grpc.ServerStreamingServer[ExportChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method ExportCars not implemented")
+}
+func (UnimplementedCarServiceServer) FindDuplicates(context.Context, *FindDuplicatesRequest) (*FindDuplicatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FindDuplicates not implemented")
+}
+func (UnimplementedCarServiceServer) MergeCars(context.Context, *MergeCarsRequest) (*MergeCarsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MergeCars not implemented")
+}
+func (UnimplementedCarServiceServer) MergeHistory(context.Context, *MergeHistoryRequest) (*MergeHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MergeHistory not implemented")
+}
+func (UnimplementedCarServiceServer) ListMakes(context.Context, *ListMakesRequest) (*ListMakesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMakes not implemented")
+}
+func (UnimplementedCarServiceServer) ListModels(context.Context, *ListModelsRequest) (*ListModelsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListModels not implemented")
+}
 func (UnimplementedCarServiceServer) mustEmbedUnimplementedCarServiceServer() {}
+func (UnimplementedCarServiceServer) testEmbeddedByValue()                    {}
 
 // UnsafeCarServiceServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to CarServiceServer will
@@ -81,6 +400,13 @@ type UnsafeCarServiceServer interface {
 }
 
 func RegisterCarServiceServer(s grpc.ServiceRegistrar, srv CarServiceServer) {
+	// If the following call pancis, it indicates UnimplementedCarServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
 	s.RegisterService(&CarService_ServiceDesc, srv)
 }
 
@@ -94,7 +420,7 @@ func _CarService_Car_Handler(srv interface{}, ctx context.Context, dec func(inte
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/cars.CarService/Car",
+		FullMethod: CarService_Car_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
 		return srv.(CarServiceServer).Car(ctx, req.(*CarRequest))
@@ -112,7 +438,7 @@ func _CarService_CarWithDeadline_Handler(srv interface{}, ctx context.Context, d
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/cars.CarService/CarWithDeadline",
+		FullMethod: CarService_CarWithDeadline_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
 		return srv.(CarServiceServer).CarWithDeadline(ctx, req.(*CarWithDeadlineRequest))
@@ -120,6 +446,262 @@ func _CarService_CarWithDeadline_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CarService_BatchGetCars_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetCarsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).BatchGetCars(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_BatchGetCars_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).BatchGetCars(ctx, req.(*BatchGetCarsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_BatchGetListings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetListingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).BatchGetListings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_BatchGetListings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).BatchGetListings(ctx, req.(*BatchGetListingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CarServiceServer).Watch(m, &grpc.GenericServerStream[WatchRequest, InventoryEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CarService_WatchServer = grpc.ServerStreamingServer[InventoryEvent]
+
+func _CarService_PlaceHold_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PlaceHoldRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).PlaceHold(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_PlaceHold_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).PlaceHold(ctx, req.(*PlaceHoldRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_ReleaseHold_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReleaseHoldRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).ReleaseHold(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_ReleaseHold_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).ReleaseHold(ctx, req.(*ReleaseHoldRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_CreateCar_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateCarRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).CreateCar(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_CreateCar_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).CreateCar(ctx, req.(*CreateCarRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_UpdateCar_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateCarRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).UpdateCar(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_UpdateCar_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).UpdateCar(ctx, req.(*UpdateCarRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_DeleteCar_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteCarRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).DeleteCar(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_DeleteCar_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).DeleteCar(ctx, req.(*DeleteCarRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_ImportCars_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImportCarsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).ImportCars(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_ImportCars_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).ImportCars(ctx, req.(*ImportCarsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_ExportCars_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportCarsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CarServiceServer).ExportCars(m, &grpc.GenericServerStream[ExportCarsRequest, ExportChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CarService_ExportCarsServer = grpc.ServerStreamingServer[ExportChunk]
+
+func _CarService_FindDuplicates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FindDuplicatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).FindDuplicates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_FindDuplicates_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).FindDuplicates(ctx, req.(*FindDuplicatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_MergeCars_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MergeCarsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).MergeCars(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_MergeCars_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).MergeCars(ctx, req.(*MergeCarsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_MergeHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MergeHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).MergeHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_MergeHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).MergeHistory(ctx, req.(*MergeHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_ListMakes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMakesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).ListMakes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_ListMakes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).ListMakes(ctx, req.(*ListMakesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CarService_ListModels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListModelsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CarServiceServer).ListModels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CarService_ListModels_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CarServiceServer).ListModels(ctx, req.(*ListModelsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CarService_ServiceDesc is the grpc.ServiceDesc for CarService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -135,7 +717,70 @@ var CarService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CarWithDeadline",
 			Handler:    _CarService_CarWithDeadline_Handler,
 		},
+		{
+			MethodName: "BatchGetCars",
+			Handler:    _CarService_BatchGetCars_Handler,
+		},
+		{
+			MethodName: "BatchGetListings",
+			Handler:    _CarService_BatchGetListings_Handler,
+		},
+		{
+			MethodName: "PlaceHold",
+			Handler:    _CarService_PlaceHold_Handler,
+		},
+		{
+			MethodName: "ReleaseHold",
+			Handler:    _CarService_ReleaseHold_Handler,
+		},
+		{
+			MethodName: "CreateCar",
+			Handler:    _CarService_CreateCar_Handler,
+		},
+		{
+			MethodName: "UpdateCar",
+			Handler:    _CarService_UpdateCar_Handler,
+		},
+		{
+			MethodName: "DeleteCar",
+			Handler:    _CarService_DeleteCar_Handler,
+		},
+		{
+			MethodName: "ImportCars",
+			Handler:    _CarService_ImportCars_Handler,
+		},
+		{
+			MethodName: "FindDuplicates",
+			Handler:    _CarService_FindDuplicates_Handler,
+		},
+		{
+			MethodName: "MergeCars",
+			Handler:    _CarService_MergeCars_Handler,
+		},
+		{
+			MethodName: "MergeHistory",
+			Handler:    _CarService_MergeHistory_Handler,
+		},
+		{
+			MethodName: "ListMakes",
+			Handler:    _CarService_ListMakes_Handler,
+		},
+		{
+			MethodName: "ListModels",
+			Handler:    _CarService_ListModels_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _CarService_Watch_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ExportCars",
+			Handler:       _CarService_ExportCars_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "cars/carspb/cars.proto",
 }
@@ -0,0 +1,279 @@
+/*
+Package catalog holds the makes, models and trims the service knows, and
+turns the free-text names cars are entered with into the catalog's own
+spelling
+*/
+package catalog
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed catalog.yaml
+var builtin []byte
+
+/*
+Make is a make in the catalog, with its models in catalog order
+*/
+type Make struct {
+	Name string `yaml:"name"`
+	// Aliases are other names the make is entered with
+	Aliases []string `yaml:"aliases"`
+	Models  []Model  `yaml:"models"`
+}
+
+/*
+Model is a model in the catalog, with its body style and the trims it
+is sold in
+*/
+type Model struct {
+	Name    string   `yaml:"name"`
+	Aliases []string `yaml:"aliases"`
+	// Body is the body style, such as sedan, suv or truck, in lower case
+	Body  string   `yaml:"body"`
+	Trims []string `yaml:"trims"`
+}
+
+// file is the layout of a catalog file
+type file struct {
+	Makes []Make `yaml:"makes"`
+}
+
+/*
+Catalog looks up makes and models by name or alias, ignoring case,
+spaces and punctuation
+*/
+type Catalog struct {
+	makes []Make
+	// makeKeys and modelKeys map the keys of names and aliases to
+	// positions in makes and in each make's models
+	makeKeys  map[string]int
+	modelKeys []map[string]int
+}
+
+/*
+UnknownError is returned for a make or model that is not in the
+catalog.  Suggestion is the closest name it does have, if any is close
+enough to be a misspelling.
+*/
+type UnknownError struct {
+	// Make is empty for an unknown make, and for an unknown model the
+	// make it was looked for under
+	Make       string
+	Value      string
+	Suggestion string
+}
+
+func (e *UnknownError) Error() string {
+	msg := fmt.Sprintf("unknown make %q", e.Value)
+	if e.Make != "" {
+		msg = fmt.Sprintf("unknown %s model %q", e.Make, e.Value)
+	}
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(", did you mean %q?", e.Suggestion)
+	}
+	return msg
+}
+
+/*
+Builtin returns the catalog shipped with the service
+*/
+func Builtin() *Catalog {
+	c, err := Parse(builtin)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+/*
+Load reads the catalog file at path, or returns the built-in catalog
+when path is empty
+*/
+func Load(path string) (*Catalog, error) {
+	if path == "" {
+		return Builtin(), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("catalog: reading catalog file: %v", err)
+	}
+	c, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%v in %s", err, path)
+	}
+	return c, nil
+}
+
+/*
+Parse reads a catalog from YAML.  Every make and model needs a name, and
+no two makes, nor two models of one make, may share a name or alias.
+*/
+func Parse(b []byte) (*Catalog, error) {
+	var f file
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("catalog: parsing catalog: %v", err)
+	}
+	c := &Catalog{makes: f.Makes, makeKeys: map[string]int{}}
+	for i, mk := range f.Makes {
+		if err := addKeys(c.makeKeys, i, mk.Name, mk.Aliases, "make"); err != nil {
+			return nil, err
+		}
+		models := map[string]int{}
+		for j, m := range mk.Models {
+			if err := addKeys(models, j, m.Name, m.Aliases, mk.Name+" model"); err != nil {
+				return nil, err
+			}
+		}
+		c.modelKeys = append(c.modelKeys, models)
+	}
+	if len(c.makes) == 0 {
+		return nil, errors.New("catalog: no makes")
+	}
+	return c, nil
+}
+
+// addKeys indexes the name and aliases of the what at position i
+func addKeys(keys map[string]int, i int, name string, aliases []string, what string) error {
+	if key(name) == "" {
+		return fmt.Errorf("catalog: %s %q has no name", what, name)
+	}
+	for _, n := range append([]string{name}, aliases...) {
+		k := key(n)
+		if k == "" {
+			return fmt.Errorf("catalog: %s %q has an empty alias", what, name)
+		}
+		if _, ok := keys[k]; ok {
+			return fmt.Errorf("catalog: more than one %s is called %q", what, n)
+		}
+		keys[k] = i
+	}
+	return nil
+}
+
+/*
+Makes returns every make in catalog order
+*/
+func (c *Catalog) Makes() []Make {
+	return append([]Make(nil), c.makes...)
+}
+
+/*
+Make returns the make called name, by its name or an alias.  When there
+is none the error is an *UnknownError.
+*/
+func (c *Catalog) Make(name string) (Make, error) {
+	i, ok := c.makeKeys[key(name)]
+	if !ok {
+		return Make{}, &UnknownError{Value: name, Suggestion: suggest(c.makeKeys, name, func(i int) string { return c.makes[i].Name })}
+	}
+	return c.makes[i], nil
+}
+
+/*
+Normalize returns make and model as the catalog spells them.  A name it
+does not know is returned as given, without surrounding space, with an
+*UnknownError for the first of the two that is unknown; the model is
+not looked up when the make is unknown.
+*/
+func (c *Catalog) Normalize(makeName, modelName string) (string, string, error) {
+	makeName, modelName = strings.TrimSpace(makeName), strings.TrimSpace(modelName)
+	i, ok := c.makeKeys[key(makeName)]
+	if !ok {
+		_, err := c.Make(makeName)
+		return makeName, modelName, err
+	}
+	mk := c.makes[i]
+	j, ok := c.modelKeys[i][key(modelName)]
+	if !ok {
+		return mk.Name, modelName, &UnknownError{
+			Make:       mk.Name,
+			Value:      modelName,
+			Suggestion: suggest(c.modelKeys[i], modelName, func(j int) string { return mk.Models[j].Name }),
+		}
+	}
+	return mk.Name, mk.Models[j].Name, nil
+}
+
+/*
+suggest returns the name, found with nameOf, of the entry in keys closest
+to value, when it is within a typo or two of it.  Ties go to the entry
+first in the catalog.
+*/
+func suggest(keys map[string]int, value string, nameOf func(int) string) string {
+	k := key(value)
+	if k == "" {
+		return ""
+	}
+	// one edit in three characters, and at least one, is a misspelling
+	limit := len([]rune(k)) / 3
+	if limit < 1 {
+		limit = 1
+	}
+	best, bestDist := -1, limit+1
+	for candidate, i := range keys {
+		d := distance(k, candidate)
+		if d < bestDist || d == bestDist && i < best {
+			best, bestDist = i, d
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return nameOf(best)
+}
+
+/*
+key folds a name for matching: lower case, keeping only letters and
+digits, so "CR-V", "cr v" and "CRV" are the same
+*/
+func key(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
+
+/*
+distance is the edit distance between a and b, counting a swap of two
+neighbouring letters as one edit, as it is one typo
+*/
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// d[i][j] is the distance between the first i runes of a and the
+	// first j of b
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
@@ -0,0 +1,107 @@
+package catalog
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	c := Builtin()
+	tests := []struct {
+		make, model         string
+		wantMake, wantModel string
+		wantErr             string
+	}{
+		{"Toyota", "Camry", "Toyota", "Camry", ""},
+		{"TOYOTA", "rav 4", "Toyota", "RAV4", ""},
+		{" toyota ", "Rav4", "Toyota", "RAV4", ""},
+		{"chevy", "silverado", "Chevrolet", "Silverado", ""},
+		{"VW", "golf", "Volkswagen", "Golf", ""},
+		{"Honda", "CRV", "Honda", "CR-V", ""},
+		{"Mazda", "3", "Mazda", "Mazda3", ""},
+		{"Toyta", "Camry", "Toyta", "Camry", `unknown make "Toyta", did you mean "Toyota"?`},
+		{"ford", "f 10", "Ford", "F10", ""},
+		{"Ford", "F15O", "Ford", "F15O", `unknown Ford model "F15O", did you mean "F-150"?`},
+		{"Lada", "Niva", "Lada", "Niva", `unknown make "Lada"`},
+		{"Honda", "Zephyr", "Honda", "Zephyr", `unknown Honda model "Zephyr"`},
+	}
+	for _, tt := range tests {
+		gotMake, gotModel, err := c.Normalize(tt.make, tt.model)
+		if gotMake != tt.wantMake || gotModel != tt.wantModel {
+			t.Errorf("Failed! %q %q normalized to %q %q, want %q %q", tt.make, tt.model, gotMake, gotModel, tt.wantMake, tt.wantModel)
+		}
+		var unknown *UnknownError
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Failed! %q %q: %v", tt.make, tt.model, err)
+		case tt.wantErr != "" && !errors.As(err, &unknown):
+			t.Errorf("Failed! %q %q: expected an UnknownError, got %v", tt.make, tt.model, err)
+		case tt.wantErr != "" && err.Error() != tt.wantErr:
+			t.Errorf("Failed! %q %q: error %q, want %q", tt.make, tt.model, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMake(t *testing.T) {
+	c := Builtin()
+	mk, err := c.Make("mercedes")
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if mk.Name != "Mercedes-Benz" || len(mk.Models) == 0 {
+		t.Errorf("Failed! unexpected make %+v", mk)
+	}
+	if _, err := c.Make("Hnoda"); err == nil || !strings.Contains(err.Error(), `"Honda"`) {
+		t.Errorf("Failed! expected a suggestion of Honda, got %v", err)
+	}
+	makes := c.Makes()
+	for i := 1; i < len(makes); i++ {
+		if makes[i-1].Name > makes[i].Name {
+			t.Errorf("Failed! built-in makes are not in order: %q before %q", makes[i-1].Name, makes[i].Name)
+		}
+	}
+	for _, mk := range makes {
+		for _, m := range mk.Models {
+			if m.Body == "" || m.Body != strings.ToLower(m.Body) {
+				t.Errorf("Failed! built-in %s %s has body style %q", mk.Name, m.Name, m.Body)
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, yaml, wantErr string
+	}{
+		{"no makes", "makes: []", "no makes"},
+		{"unknown field", "makes:\n  - name: Kia\n    model: Soul", "field model not found"},
+		{"unnamed make", "makes:\n  - aliases: [X]", "has no name"},
+		{"empty alias", "makes:\n  - name: Kia\n    aliases: ['-']", "empty alias"},
+		{"clashing makes", "makes:\n  - name: Volkswagen\n    aliases: [VW]\n  - name: V.W.", `more than one make is called "V.W."`},
+		{"clashing models", "makes:\n  - name: Kia\n    models:\n      - name: Soul\n      - name: SOUL", `more than one Kia model is called "SOUL"`},
+	}
+	for _, tt := range tests {
+		if _, err := Parse([]byte(tt.yaml)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Failed! %s: expected an error containing %q, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+
+	c, err := Parse([]byte("makes:\n  - name: Kia\n    models:\n      - name: Soul\n        trims: [LX, EX]"))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, model, err := c.Normalize("kia", "soul"); err != nil || model != "Soul" {
+		t.Errorf("Failed! got %q, %v", model, err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	c, err := Load("")
+	if err != nil || len(c.Makes()) != len(Builtin().Makes()) {
+		t.Errorf("Failed! no path should load the built-in catalog, got %v", err)
+	}
+	if _, err := Load("testdata/missing.yaml"); err == nil {
+		t.Errorf("Failed! expected an error for a missing file")
+	}
+}
@@ -0,0 +1,443 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Config holds the settings shared by the gRPC microservice and the
+REST service.  Each binary reads the whole configuration and uses the
+parts that apply to it.
+*/
+type Config struct {
+	Microservice Microservice `yaml:"microservice" toml:"microservice"`
+	Gateway      Gateway      `yaml:"gateway" toml:"gateway"`
+
+	// GracePeriod is the time allowed for in-flight work to finish on shutdown
+	GracePeriod time.Duration `yaml:"grace_period" toml:"grace_period"`
+	// Trace prints finished trace spans to stdout
+	Trace bool `yaml:"trace" toml:"trace"`
+
+	// PrintConfig asks the binary to print the resolved configuration and exit
+	PrintConfig bool `yaml:"-" toml:"-"`
+}
+
+/*
+Microservice holds the gRPC microservice settings
+*/
+type Microservice struct {
+	ListenAddr     string        `yaml:"listen_addr" toml:"listen_addr"`
+	MetricsAddr    string        `yaml:"metrics_addr" toml:"metrics_addr"`
+	HealthInterval time.Duration `yaml:"health_interval" toml:"health_interval"`
+	// TLS secures the gRPC listener; its CA file turns on client
+	// certificate verification (mutual TLS)
+	TLS TLS `yaml:"tls" toml:"tls"`
+	// EnforceRoles refuses calls whose forwarded identity lacks the
+	// role the method requires.  Identities are only taken from callers
+	// with a client certificate signed by the TLS CA file.
+	EnforceRoles bool `yaml:"enforce_roles" toml:"enforce_roles"`
+	// GRPCWeb serves CarService to browsers over gRPC-Web
+	GRPCWeb GRPCWeb `yaml:"grpc_web" toml:"grpc_web"`
+	// Catalog is the catalog of makes and models cars are checked against
+	Catalog Catalog `yaml:"catalog" toml:"catalog"`
+}
+
+/*
+Catalog holds the make and model catalog settings.  Without a file the
+built-in catalog is used.
+*/
+type Catalog struct {
+	File string `yaml:"file" toml:"file"`
+	// RejectUnknown refuses cars whose make or model is not in the
+	// catalog; otherwise they are stored with a warning
+	RejectUnknown bool `yaml:"reject_unknown" toml:"reject_unknown"`
+}
+
+/*
+GRPCWeb holds the gRPC-Web listener settings.  The listener is off when
+ListenAddr is empty; it shares the microservice certificate when TLS is
+on.
+*/
+type GRPCWeb struct {
+	ListenAddr string `yaml:"listen_addr" toml:"listen_addr"`
+	// AllowedOrigins is a comma separated list of the origins whose
+	// pages may call the listener, or * for any
+	AllowedOrigins string `yaml:"allowed_origins" toml:"allowed_origins"`
+	// Auth turns on bearer token authentication; without it browser
+	// calls carry no identity
+	Auth Auth `yaml:"auth" toml:"auth"`
+}
+
+/*
+Gateway holds the REST service settings
+*/
+type Gateway struct {
+	ListenAddr       string        `yaml:"listen_addr" toml:"listen_addr"`
+	MicroserviceAddr string        `yaml:"microservice_addr" toml:"microservice_addr"`
+	RequestTimeout   time.Duration `yaml:"request_timeout" toml:"request_timeout"`
+	ReadinessTimeout time.Duration `yaml:"readiness_timeout" toml:"readiness_timeout"`
+	// EventsHeartbeat is how often an idle /cars/events stream gets a
+	// comment, and /cars/socket connections a ping, so proxies keep them
+	// open
+	EventsHeartbeat time.Duration `yaml:"events_heartbeat" toml:"events_heartbeat"`
+	// TLS secures the HTTP listener
+	TLS TLS `yaml:"tls" toml:"tls"`
+	// ServeGRPC also accepts gRPC calls on the HTTP listener and passes
+	// them on to the microservice, so one port serves both
+	ServeGRPC bool `yaml:"serve_grpc" toml:"serve_grpc"`
+	// EmbedMicroservice runs the gRPC microservice inside the REST
+	// service, reached in memory instead of at MicroserviceAddr, so
+	// one binary serves everything
+	EmbedMicroservice bool `yaml:"embed_microservice" toml:"embed_microservice"`
+	// MicroserviceTLS secures the connection to the gRPC microservice;
+	// its certificate is presented to the microservice for mutual TLS
+	MicroserviceTLS TLS `yaml:"microservice_tls" toml:"microservice_tls"`
+	// Auth turns on bearer token authentication for the car routes
+	Auth Auth `yaml:"auth" toml:"auth"`
+	// APIKeys turns on API key authentication, rate limits and quotas
+	APIKeys APIKeys `yaml:"api_keys" toml:"api_keys"`
+}
+
+/*
+APIKeys holds the API key settings.  API keys are off when KeysFile is
+empty.
+*/
+type APIKeys struct {
+	KeysFile string `yaml:"keys_file" toml:"keys_file"`
+	// UsageFile is where the per-day usage counters are saved
+	UsageFile string `yaml:"usage_file" toml:"usage_file"`
+	// FlushInterval is how often the usage counters are saved
+	FlushInterval time.Duration `yaml:"flush_interval" toml:"flush_interval"`
+}
+
+/*
+Auth holds the JWT validation settings.  Authentication is off when
+JWKSFile is empty.
+*/
+type Auth struct {
+	JWKSFile string `yaml:"jwks_file" toml:"jwks_file"`
+	Issuer   string `yaml:"issuer" toml:"issuer"`
+	Audience string `yaml:"audience" toml:"audience"`
+}
+
+/*
+TLS names the PEM files for one side of a TLS connection.  TLS is off
+when no file is set.
+*/
+type TLS struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+	CAFile   string `yaml:"ca_file" toml:"ca_file"`
+	// ServerName overrides the name checked against the server
+	// certificate; only used when dialling
+	ServerName string `yaml:"server_name" toml:"server_name"`
+}
+
+/*
+Enabled reports whether any TLS file is set
+*/
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.CAFile != ""
+}
+
+/*
+Default returns the configuration used when nothing is overridden
+*/
+func Default() *Config {
+	return &Config{
+		Microservice: Microservice{
+			ListenAddr:     "0.0.0.0:50051",
+			MetricsAddr:    "0.0.0.0:9090",
+			HealthInterval: 5 * time.Second,
+		},
+		Gateway: Gateway{
+			ListenAddr:       ":8080",
+			MicroserviceAddr: "localhost:50051",
+			RequestTimeout:   4 * time.Second,
+			ReadinessTimeout: 2 * time.Second,
+			EventsHeartbeat:  15 * time.Second,
+			APIKeys: APIKeys{
+				UsageFile:     "api-key-usage.json",
+				FlushInterval: 10 * time.Second,
+			},
+		},
+		GracePeriod: 15 * time.Second,
+	}
+}
+
+// setting ties one configuration field to its flag and environment variable
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value interface{} // *string, *bool or *time.Duration inside a Config
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"grpc-listen-addr", "CARS_GRPC_LISTEN_ADDR", "address the gRPC microservice listens on", &c.Microservice.ListenAddr},
+		{"metrics-addr", "CARS_METRICS_ADDR", "address the gRPC microservice serves /metrics on", &c.Microservice.MetricsAddr},
+		{"health-interval", "CARS_HEALTH_INTERVAL", "how often the gRPC microservice checks the repository", &c.Microservice.HealthInterval},
+		{"http-listen-addr", "CARS_HTTP_LISTEN_ADDR", "address the REST service listens on", &c.Gateway.ListenAddr},
+		{"grpc-target", "CARS_GRPC_TARGET", "address the REST service dials to reach the gRPC microservice", &c.Gateway.MicroserviceAddr},
+		{"request-timeout", "CARS_REQUEST_TIMEOUT", "deadline for gRPC calls made by the REST service", &c.Gateway.RequestTimeout},
+		{"readiness-timeout", "CARS_READINESS_TIMEOUT", "deadline for the health check behind /readyz", &c.Gateway.ReadinessTimeout},
+		{"events-heartbeat", "CARS_EVENTS_HEARTBEAT", "how often idle /cars/events streams and /cars/socket connections get a heartbeat", &c.Gateway.EventsHeartbeat},
+		{"embed-microservice", "CARS_EMBED_MICROSERVICE", "run the gRPC microservice inside the REST service instead of dialling grpc-target", &c.Gateway.EmbedMicroservice},
+		{"serve-grpc", "CARS_SERVE_GRPC", "also accept gRPC calls on the REST listener and forward them to the microservice (cleartext HTTP/2 without TLS)", &c.Gateway.ServeGRPC},
+		{"grpc-tls-cert", "CARS_GRPC_TLS_CERT", "certificate file for the gRPC listener", &c.Microservice.TLS.CertFile},
+		{"grpc-tls-key", "CARS_GRPC_TLS_KEY", "key file for the gRPC listener", &c.Microservice.TLS.KeyFile},
+		{"grpc-tls-client-ca", "CARS_GRPC_TLS_CLIENT_CA", "CA file for verifying gRPC client certificates (enables mutual TLS)", &c.Microservice.TLS.CAFile},
+		{"http-tls-cert", "CARS_HTTP_TLS_CERT", "certificate file for the REST listener", &c.Gateway.TLS.CertFile},
+		{"http-tls-key", "CARS_HTTP_TLS_KEY", "key file for the REST listener", &c.Gateway.TLS.KeyFile},
+		{"grpc-target-ca", "CARS_GRPC_TARGET_CA", "CA file for verifying the gRPC microservice certificate", &c.Gateway.MicroserviceTLS.CAFile},
+		{"grpc-target-cert", "CARS_GRPC_TARGET_CERT", "client certificate file presented to the gRPC microservice", &c.Gateway.MicroserviceTLS.CertFile},
+		{"grpc-target-key", "CARS_GRPC_TARGET_KEY", "client key file presented to the gRPC microservice", &c.Gateway.MicroserviceTLS.KeyFile},
+		{"grpc-target-server-name", "CARS_GRPC_TARGET_SERVER_NAME", "name expected in the gRPC microservice certificate", &c.Gateway.MicroserviceTLS.ServerName},
+		{"grpc-web-listen-addr", "CARS_GRPC_WEB_LISTEN_ADDR", "address the gRPC microservice serves gRPC-Web on (enables gRPC-Web)", &c.Microservice.GRPCWeb.ListenAddr},
+		{"grpc-web-allowed-origins", "CARS_GRPC_WEB_ALLOWED_ORIGINS", "comma separated origins allowed to call gRPC-Web, or * for any", &c.Microservice.GRPCWeb.AllowedOrigins},
+		{"grpc-web-jwks-file", "CARS_GRPC_WEB_JWKS_FILE", "JSON Web Key Set used to verify gRPC-Web bearer tokens", &c.Microservice.GRPCWeb.Auth.JWKSFile},
+		{"grpc-web-jwt-issuer", "CARS_GRPC_WEB_JWT_ISSUER", "issuer gRPC-Web bearer tokens must carry", &c.Microservice.GRPCWeb.Auth.Issuer},
+		{"grpc-web-jwt-audience", "CARS_GRPC_WEB_JWT_AUDIENCE", "audience gRPC-Web bearer tokens must carry", &c.Microservice.GRPCWeb.Auth.Audience},
+		{"enforce-roles", "CARS_ENFORCE_ROLES", "refuse gRPC calls whose caller lacks the required role", &c.Microservice.EnforceRoles},
+		{"catalog-file", "CARS_CATALOG_FILE", "YAML catalog of makes and models to use instead of the built-in one", &c.Microservice.Catalog.File},
+		{"reject-unknown-models", "CARS_REJECT_UNKNOWN_MODELS", "refuse cars whose make or model is not in the catalog instead of warning", &c.Microservice.Catalog.RejectUnknown},
+		{"jwks-file", "CARS_JWKS_FILE", "JSON Web Key Set used to verify bearer tokens (enables authentication)", &c.Gateway.Auth.JWKSFile},
+		{"jwt-issuer", "CARS_JWT_ISSUER", "issuer bearer tokens must carry", &c.Gateway.Auth.Issuer},
+		{"jwt-audience", "CARS_JWT_AUDIENCE", "audience bearer tokens must carry", &c.Gateway.Auth.Audience},
+		{"api-keys-file", "CARS_API_KEYS_FILE", "YAML file of issued API keys (enables API keys)", &c.Gateway.APIKeys.KeysFile},
+		{"api-usage-file", "CARS_API_USAGE_FILE", "JSON file the API key usage counters are saved to", &c.Gateway.APIKeys.UsageFile},
+		{"api-usage-flush-interval", "CARS_API_USAGE_FLUSH_INTERVAL", "how often the API key usage counters are saved", &c.Gateway.APIKeys.FlushInterval},
+		{"grace-period", "CARS_GRACE_PERIOD", "time allowed for in-flight work to finish on shutdown", &c.GracePeriod},
+		{"trace", "CARS_TRACE", "print trace spans to stdout", &c.Trace},
+	}
+}
+
+/*
+Load resolves the configuration for the binary called name from its
+command line arguments.  Later sources override earlier ones:
+
+	defaults < YAML or TOML file (-config or CARS_CONFIG) < CARS_* environment variables < flags
+
+The result is validated before it is returned.
+*/
+func Load(name string, args []string) (*Config, error) {
+	c := Default()
+	settings := c.settings()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("CARS_CONFIG"), "YAML or TOML configuration file, chosen by the .toml extension (env CARS_CONFIG)")
+	printConfig := fs.Bool("print-config", false, "print the resolved configuration and exit")
+
+	// flags are parsed into their own values and only copied over the
+	// file and environment when they were given on the command line
+	flagValues := map[string]interface{}{}
+	for _, s := range settings {
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env)
+		switch v := s.value.(type) {
+		case *string:
+			flagValues[s.flag] = fs.String(s.flag, *v, usage)
+		case *bool:
+			flagValues[s.flag] = fs.Bool(s.flag, *v, usage)
+		case *time.Duration:
+			flagValues[s.flag] = fs.Duration(s.flag, *v, usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configPath != "" {
+		if err := c.readFile(*configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if raw, ok := os.LookupEnv(s.env); ok {
+			if err := set(s.value, raw); err != nil {
+				return nil, fmt.Errorf("invalid value %q for %s: %v", raw, s.env, err)
+			}
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				copyValue(s.value, flagValues[s.flag])
+			}
+		}
+	})
+	c.PrintConfig = *printConfig
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readFile reads a TOML file when path ends in .toml, and YAML otherwise
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".toml" {
+		md, err := toml.Decode(string(b), c)
+		if err != nil {
+			return fmt.Errorf("parsing config file %s: %v", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing config file %s: unknown setting %s", path, undecoded[0])
+		}
+		return nil
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("parsing config file %s: %v", path, err)
+	}
+	return nil
+}
+
+func set(dst interface{}, raw string) error {
+	switch v := dst.(type) {
+	case *string:
+		*v = raw
+	case *bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		*v = b
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		*v = d
+	}
+	return nil
+}
+
+func copyValue(dst, src interface{}) {
+	switch v := dst.(type) {
+	case *string:
+		*v = *src.(*string)
+	case *bool:
+		*v = *src.(*bool)
+	case *time.Duration:
+		*v = *src.(*time.Duration)
+	}
+}
+
+/*
+Validate reports every invalid setting in c
+*/
+func (c *Config) Validate() error {
+	var errs []error
+	checkAddr := func(field, addr string) {
+		if _, port, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %q is not a host:port address", field, addr))
+		} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %q does not have a valid port", field, addr))
+		}
+	}
+	checkPositive := func(field string, d time.Duration) {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be greater than zero, got %v", field, d))
+		}
+	}
+
+	checkAddr("microservice.listen_addr", c.Microservice.ListenAddr)
+	checkAddr("microservice.metrics_addr", c.Microservice.MetricsAddr)
+	checkPositive("microservice.health_interval", c.Microservice.HealthInterval)
+	if web := c.Microservice.GRPCWeb; web.ListenAddr != "" {
+		checkAddr("microservice.grpc_web.listen_addr", web.ListenAddr)
+	} else if web.AllowedOrigins != "" || web.Auth.JWKSFile != "" {
+		errs = append(errs, errors.New("microservice.grpc_web: allowed_origins and auth need listen_addr"))
+	}
+	checkAddr("gateway.listen_addr", c.Gateway.ListenAddr)
+	checkAddr("gateway.microservice_addr", c.Gateway.MicroserviceAddr)
+	checkPositive("gateway.request_timeout", c.Gateway.RequestTimeout)
+	checkPositive("gateway.readiness_timeout", c.Gateway.ReadinessTimeout)
+	checkPositive("gateway.events_heartbeat", c.Gateway.EventsHeartbeat)
+	checkPositive("gateway.api_keys.flush_interval", c.Gateway.APIKeys.FlushInterval)
+	checkPositive("grace_period", c.GracePeriod)
+
+	checkKeyPair := func(field string, t TLS) {
+		if (t.CertFile == "") != (t.KeyFile == "") {
+			errs = append(errs, fmt.Errorf("%s: cert_file and key_file must be set together", field))
+		}
+	}
+	checkKeyPair("microservice.tls", c.Microservice.TLS)
+	if c.Microservice.TLS.CAFile != "" && c.Microservice.TLS.CertFile == "" {
+		errs = append(errs, errors.New("microservice.tls: ca_file needs cert_file and key_file"))
+	}
+	// identities are only taken from callers with a verified client
+	// certificate, so without one every call would be refused
+	if c.Microservice.EnforceRoles && c.Microservice.TLS.CAFile == "" && !c.Gateway.EmbedMicroservice {
+		errs = append(errs, errors.New("microservice.enforce_roles: needs tls.ca_file, as only callers with a verified client certificate can forward their identity"))
+	}
+	// the same goes for the callers the REST service authenticates: with
+	// the microservice apart, their identities only reach it over mutual
+	// TLS, and without them costs are hidden and holds refused
+	if (c.Gateway.Auth.JWKSFile != "" || c.Gateway.APIKeys.KeysFile != "") && !c.Gateway.EmbedMicroservice {
+		if c.Microservice.TLS.CAFile == "" {
+			errs = append(errs, errors.New("gateway.auth, gateway.api_keys: need microservice.tls.ca_file unless embed_microservice is set, as only callers with a verified client certificate can forward their identity"))
+		}
+		if c.Gateway.MicroserviceTLS.CertFile == "" {
+			errs = append(errs, errors.New("gateway.microservice_tls: cert_file and key_file are needed to forward the identity of authenticated callers unless embed_microservice is set"))
+		}
+	}
+	checkKeyPair("gateway.tls", c.Gateway.TLS)
+	if c.Gateway.TLS.CAFile != "" {
+		errs = append(errs, errors.New("gateway.tls: ca_file is not supported on the REST listener"))
+	}
+	checkKeyPair("gateway.microservice_tls", c.Gateway.MicroserviceTLS)
+	if c.Gateway.MicroserviceTLS.Enabled() && c.Gateway.MicroserviceTLS.CAFile == "" {
+		errs = append(errs, errors.New("gateway.microservice_tls: ca_file is required to verify the microservice"))
+	}
+	if web := c.Microservice.GRPCWeb.Auth; web.JWKSFile == "" && (web.Issuer != "" || web.Audience != "") {
+		errs = append(errs, errors.New("microservice.grpc_web.auth: issuer and audience need jwks_file"))
+	}
+	if c.Gateway.EmbedMicroservice && c.Gateway.MicroserviceTLS.Enabled() {
+		errs = append(errs, errors.New("gateway.microservice_tls: not used with embed_microservice"))
+	}
+	if c.Gateway.Auth.JWKSFile == "" && (c.Gateway.Auth.Issuer != "" || c.Gateway.Auth.Audience != "") {
+		errs = append(errs, errors.New("gateway.auth: issuer and audience need jwks_file"))
+	}
+	if c.Gateway.APIKeys.KeysFile != "" && c.Gateway.APIKeys.UsageFile == "" {
+		errs = append(errs, errors.New("gateway.api_keys: usage_file is required to keep quotas across restarts"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+/*
+Write prints c as YAML, in the same shape the -config file is read in
+*/
+func (c *Config) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
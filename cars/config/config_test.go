@@ -0,0 +1,177 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "cars.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load("test", nil)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if c.Gateway.MicroserviceAddr != "localhost:50051" || c.Gateway.RequestTimeout != 4*time.Second {
+		t.Errorf("Failed! unexpected defaults %+v", c.Gateway)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+gateway:
+  listen_addr: ":8000"
+  microservice_addr: "file:50051"
+  request_timeout: 10s
+grace_period: 30s
+`)
+	t.Setenv("CARS_GRPC_TARGET", "env:50051")
+	t.Setenv("CARS_REQUEST_TIMEOUT", "6s")
+
+	c, err := Load("test", []string{"-config", path, "-request-timeout", "8s"})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if c.Gateway.ListenAddr != ":8000" {
+		t.Errorf("Failed! file should override default, got %q", c.Gateway.ListenAddr)
+	}
+	if c.Gateway.MicroserviceAddr != "env:50051" {
+		t.Errorf("Failed! env should override file, got %q", c.Gateway.MicroserviceAddr)
+	}
+	if c.Gateway.RequestTimeout != 8*time.Second {
+		t.Errorf("Failed! flag should override env, got %v", c.Gateway.RequestTimeout)
+	}
+	if c.GracePeriod != 30*time.Second {
+		t.Errorf("Failed! file should override default, got %v", c.GracePeriod)
+	}
+	if c.Microservice.ListenAddr != "0.0.0.0:50051" {
+		t.Errorf("Failed! unset values should keep their default, got %q", c.Microservice.ListenAddr)
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("CARS_CONFIG", writeFile(t, "trace: true\n"))
+	c, err := Load("test", nil)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if !c.Trace {
+		t.Errorf("Failed! CARS_CONFIG file was not read")
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cars.toml")
+	content := `
+grace_period = "30s"
+
+[gateway]
+microservice_addr = "file:50051"
+request_timeout = "10s"
+embed_microservice = true
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	c, err := Load("test", []string{"-config", path})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if c.Gateway.MicroserviceAddr != "file:50051" || c.Gateway.RequestTimeout != 10*time.Second || !c.Gateway.EmbedMicroservice {
+		t.Errorf("Failed! TOML file was not read, got %+v", c.Gateway)
+	}
+	if c.GracePeriod != 30*time.Second || c.Gateway.ListenAddr != ":8080" {
+		t.Errorf("Failed! got grace period %v and listen address %q", c.GracePeriod, c.Gateway.ListenAddr)
+	}
+
+	if err := os.WriteFile(path, []byte("[gateway]\nlisten = \":8000\"\n"), 0o600); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := Load("test", []string{"-config", path}); err == nil || !strings.Contains(err.Error(), "gateway.listen") {
+		t.Errorf("Failed! expected an unknown setting error, got %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"bad address", "", nil, []string{"-grpc-target", "localhost"}, "gateway.microservice_addr"},
+		{"bad port", "", nil, []string{"-http-listen-addr", ":99999"}, "gateway.listen_addr"},
+		{"zero timeout", "", nil, []string{"-request-timeout", "0s"}, "gateway.request_timeout"},
+		{"bad env", "", map[string]string{"CARS_GRACE_PERIOD": "soon"}, nil, "CARS_GRACE_PERIOD"},
+		{"unknown file key", "gateway:\n  port: 8080\n", nil, nil, "port"},
+		{"missing file", "", nil, []string{"-config", "/does/not/exist.yaml"}, "reading config file"},
+		{"cert without key", "", nil, []string{"-grpc-tls-cert", "server.crt"}, "microservice.tls"},
+		{"client CA without cert", "", nil, []string{"-grpc-tls-client-ca", "ca.crt"}, "microservice.tls"},
+		{"client cert without CA", "", nil, []string{"-grpc-target-cert", "c.crt", "-grpc-target-key", "c.key"}, "gateway.microservice_tls"},
+		{"roles without client CA", "", nil, []string{"-enforce-roles"}, "microservice.enforce_roles"},
+		{"JWT without client CA", "", nil, []string{"-jwks-file", "jwks.json"}, "microservice.tls.ca_file"},
+		{"API keys without client certificate", "", nil, []string{"-api-keys-file", "keys.yaml",
+			"-grpc-tls-cert", "s.crt", "-grpc-tls-key", "s.key", "-grpc-tls-client-ca", "ca.crt"}, "gateway.microservice_tls"},
+		{"gRPC-Web origins without listener", "", nil, []string{"-grpc-web-allowed-origins", "*"}, "microservice.grpc_web"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			_, err := Load("test", args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Failed! expected error mentioning %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticatedGateway(t *testing.T) {
+	tests := [][]string{
+		{"-jwks-file", "jwks.json", "-embed-microservice"},
+		{"-jwks-file", "jwks.json",
+			"-grpc-tls-cert", "s.crt", "-grpc-tls-key", "s.key", "-grpc-tls-client-ca", "ca.crt",
+			"-grpc-target-ca", "ca.crt", "-grpc-target-cert", "c.crt", "-grpc-target-key", "c.key"},
+	}
+	for _, args := range tests {
+		if _, err := Load("test", args); err != nil {
+			t.Errorf("Failed! %v: %v", args, err)
+		}
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	c := Default()
+	c.Trace = true
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if !strings.Contains(buf.String(), "request_timeout: 4s") {
+		t.Errorf("Failed! durations should print as strings:\n%s", buf.String())
+	}
+
+	loaded, err := Load("test", []string{"-config", writeFile(t, buf.String())})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if *loaded != *c {
+		t.Errorf("Failed! printed configuration does not load back:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+/*
+EventType says how a car changed
+*/
+type EventType int
+
+const (
+	Created EventType = iota + 1
+	Updated
+	Deleted
+	// Held and Released mark a car going on and off hold
+	Held
+	Released
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Created:
+		return "created"
+	case Updated:
+		return "updated"
+	case Deleted:
+		return "deleted"
+	case Held:
+		return "held"
+	case Released:
+		return "released"
+	}
+	return "unknown"
+}
+
+/*
+Event records one change to the inventory.  Sequence numbers start at 1
+and increase by one with every change.
+*/
+type Event struct {
+	Sequence uint64
+	Type     EventType
+	// Car is the car after the change, or before it for Deleted
+	Car models.Car
+	// Hold is the hold placed or released, for Held and Released
+	Hold *Hold
+	Time time.Time
+}
+
+// ErrHistoryGone is returned by Subscribe when the events after the
+// requested sequence number are no longer kept
+var ErrHistoryGone = errors.New("data: requested events are no longer available")
+
+const (
+	// historySize is the number of recent events kept for resuming
+	historySize = 1024
+	// subscriberBuffer is how far a subscriber may fall behind before it
+	// is dropped
+	subscriberBuffer = 64
+)
+
+type feed struct {
+	mu          sync.Mutex
+	history     []Event
+	sequence    uint64
+	subscribers map[chan Event]struct{}
+	now         func() time.Time
+}
+
+var changes = newFeed()
+
+func newFeed() *feed {
+	return &feed{subscribers: map[chan Event]struct{}{}, now: time.Now}
+}
+
+/*
+Subscribe returns the inventory changes made after sequence number
+after, followed by every later change as it happens.  With after 0 only
+later changes are sent.
+
+The channel is closed when ctx is done, when the repository is closed,
+or when the subscriber falls too far behind; a subscriber can then
+subscribe again after the last sequence number it saw.
+*/
+func Subscribe(ctx context.Context, after uint64) (<-chan Event, error) {
+	return changes.subscribe(ctx, after)
+}
+
+/*
+LastSequence returns the sequence number of the latest change, or 0
+when nothing has changed
+*/
+func LastSequence() uint64 {
+	changes.mu.Lock()
+	defer changes.mu.Unlock()
+	return changes.sequence
+}
+
+func (f *feed) subscribe(ctx context.Context, after uint64) (<-chan Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if closed.Load() {
+		return nil, ErrClosed
+	}
+
+	var replay []Event
+	if after > 0 {
+		if after > f.sequence {
+			// numbered by an earlier run of the service
+			return nil, ErrHistoryGone
+		}
+		oldest := f.sequence - uint64(len(f.history)) + 1
+		if after+1 < oldest {
+			return nil, ErrHistoryGone
+		}
+		replay = f.history[after+1-oldest:]
+	}
+
+	ch := make(chan Event, len(replay)+subscriberBuffer)
+	for _, e := range replay {
+		ch <- e
+	}
+	f.subscribers[ch] = struct{}{}
+	go func() {
+		<-ctx.Done()
+		f.unsubscribe(ch)
+	}()
+	return ch, nil
+}
+
+func (f *feed) unsubscribe(ch chan Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subscribers[ch]; ok {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish records a change and sends it to every subscriber
+func (f *feed) publish(t EventType, car models.Car) Event {
+	return f.record(Event{Type: t, Car: car})
+}
+
+// publishHold records a hold being placed or released
+func (f *feed) publishHold(t EventType, car models.Car, hold Hold) Event {
+	return f.record(Event{Type: t, Car: car, Hold: &hold})
+}
+
+// record numbers and timestamps e, keeps it for resuming and sends it to
+// every subscriber
+func (f *feed) record(e Event) Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sequence++
+	e.Sequence, e.Time = f.sequence, f.now()
+	f.history = append(f.history, e)
+	if len(f.history) > historySize {
+		f.history = append(f.history[:0:0], f.history[len(f.history)-historySize:]...)
+	}
+	for ch := range f.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// too far behind; it resumes from its last event
+			delete(f.subscribers, ch)
+			close(ch)
+		}
+	}
+	return e
+}
+
+// closeAll ends every subscription
+func (f *feed) closeAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subscribers {
+		delete(f.subscribers, ch)
+		close(ch)
+	}
+}
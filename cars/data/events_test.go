@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+func receive(t *testing.T, ch <-chan Event, n int) []Event {
+	t.Helper()
+	var got []Event
+	for i := 0; i < n; i++ {
+		e, ok := <-ch
+		if !ok {
+			t.Fatalf("Failed! channel closed after %d events", len(got))
+		}
+		got = append(got, e)
+	}
+	return got
+}
+
+func TestSubscribeLiveAndResume(t *testing.T) {
+	f := newFeed()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	live, err := f.subscribe(ctx, 0)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	f.publish(Created, models.Car{Id: 7})
+	f.publish(Updated, models.Car{Id: 7})
+	f.publish(Deleted, models.Car{Id: 7})
+	got := receive(t, live, 3)
+	if got[0].Sequence != 1 || got[2].Sequence != 3 || got[2].Type != Deleted {
+		t.Errorf("Failed! unexpected events %v", got)
+	}
+
+	resumed, err := f.subscribe(ctx, 1)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	got = receive(t, resumed, 2)
+	if got[0].Sequence != 2 || got[1].Sequence != 3 {
+		t.Errorf("Failed! resume after 1 replayed %v", got)
+	}
+
+	if _, err := f.subscribe(ctx, 4); !errors.Is(err, ErrHistoryGone) {
+		t.Errorf("Failed! expected ErrHistoryGone for a future sequence, got %v", err)
+	}
+}
+
+func TestSubscribeHistoryGone(t *testing.T) {
+	f := newFeed()
+	for i := 0; i < historySize+5; i++ {
+		f.publish(Updated, models.Car{Id: 1})
+	}
+	if _, err := f.subscribe(context.Background(), 3); !errors.Is(err, ErrHistoryGone) {
+		t.Errorf("Failed! expected ErrHistoryGone, got %v", err)
+	}
+	if _, err := f.subscribe(context.Background(), 5); err != nil {
+		t.Errorf("Failed! the oldest kept event should be resumable: %v", err)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	f := newFeed()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := f.subscribe(ctx, 0)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Errorf("Failed! expected the channel to close when ctx is done")
+	}
+
+	// a subscriber that stops reading is dropped rather than blocking
+	slow, err := f.subscribe(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	for i := 0; i < subscriberBuffer+1; i++ {
+		f.publish(Updated, models.Car{Id: 1})
+	}
+	n := 0
+	for range slow {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("Failed! expected %d buffered events before the drop, got %d", subscriberBuffer, n)
+	}
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+	"github.com/simrie/go-grpc-car-service/cars/tracing"
+)
+
+/*
+Hold reserves a car for a customer for a while, so it is not sold to
+someone else from another showroom
+*/
+type Hold struct {
+	ID    string
+	CarID int64
+	// HeldBy is the subject of the caller who placed the hold
+	HeldBy  string
+	Expires time.Time
+}
+
+var (
+	// ErrNotFound is returned for a car that does not exist
+	ErrNotFound = errors.New("data: car not found")
+	// ErrAlreadyHeld is returned when placing a hold on a held car
+	ErrAlreadyHeld = errors.New("data: car is already on hold")
+	// ErrHoldNotFound is returned for a hold that does not exist or has expired
+	ErrHoldNotFound = errors.New("data: hold not found")
+	// ErrNotHolder is returned when someone else tries to release a hold
+	ErrNotHolder = errors.New("data: hold was placed by someone else")
+)
+
+type holdBook struct {
+	mu    sync.Mutex
+	byID  map[string]*placedHold
+	byCar map[int64]string
+	feed  *feed
+}
+
+type placedHold struct {
+	Hold
+	car   models.Car
+	timer *time.Timer
+}
+
+var holds = newHoldBook(changes)
+
+func newHoldBook(f *feed) *holdBook {
+	return &holdBook{byID: map[string]*placedHold{}, byCar: map[int64]string{}, feed: f}
+}
+
+/*
+PlaceHold puts the car with carID on hold for d on behalf of heldBy.
+The hold is released by ReleaseHold or when it expires; either way a
+Released event follows the Held one.
+*/
+func PlaceHold(ctx context.Context, carID int64, heldBy string, d time.Duration) (hold Hold, err error) {
+	_, span := tracing.Start(ctx, "data.PlaceHold", attribute.Int64("cars.id", carID))
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return hold, ErrClosed
+	}
+	return stock.hold(carID, heldBy, d)
+}
+
+/*
+ReleaseHold releases the hold with id.  Only the caller who placed it may
+release it unless override is set.
+*/
+func ReleaseHold(ctx context.Context, id, releasedBy string, override bool) (hold Hold, err error) {
+	_, span := tracing.Start(ctx, "data.ReleaseHold")
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return hold, ErrClosed
+	}
+	return holds.release(id, func(h Hold) error {
+		if h.HeldBy != releasedBy && !override {
+			return ErrNotHolder
+		}
+		return nil
+	})
+}
+
+func (b *holdBook) place(car models.Car, heldBy string, d time.Duration) (Hold, error) {
+	id, err := newHoldID()
+	if err != nil {
+		return Hold{}, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, held := b.byCar[car.Id]; held {
+		return Hold{}, ErrAlreadyHeld
+	}
+	h := &placedHold{
+		Hold: Hold{ID: id, CarID: car.Id, HeldBy: heldBy, Expires: b.feed.now().Add(d)},
+		car:  car,
+	}
+	b.byID[id] = h
+	b.byCar[car.Id] = id
+	h.timer = time.AfterFunc(d, func() {
+		b.release(id, func(Hold) error { return nil })
+	})
+	b.feed.publishHold(Held, car, h.Hold)
+	return h.Hold, nil
+}
+
+// release removes the hold with id if allowed accepts it
+func (b *holdBook) release(id string, allowed func(Hold) error) (Hold, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.byID[id]
+	if !ok {
+		return Hold{}, ErrHoldNotFound
+	}
+	if err := allowed(h.Hold); err != nil {
+		return Hold{}, err
+	}
+	h.timer.Stop()
+	delete(b.byID, id)
+	delete(b.byCar, h.CarID)
+	b.feed.publishHold(Released, h.car, h.Hold)
+	return h.Hold, nil
+}
+
+// isHeld reports whether the car with carID is on hold
+func (b *holdBook) isHeld(carID int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, held := b.byCar[carID]
+	return held
+}
+
+// heldIDs returns the ids of the cars on hold
+func (b *holdBook) heldIDs() map[int64]bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	held := make(map[int64]bool, len(b.byCar))
+	for carID := range b.byCar {
+		held[carID] = true
+	}
+	return held
+}
+
+func newHoldID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
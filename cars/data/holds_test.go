@@ -0,0 +1,74 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+func TestHolds(t *testing.T) {
+	f := newFeed()
+	b := newHoldBook(f)
+	events, err := f.subscribe(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	car := models.Car{Id: 3}
+
+	hold, err := b.place(car, "kiosk-1", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := b.place(car, "kiosk-2", time.Hour); !errors.Is(err, ErrAlreadyHeld) {
+		t.Errorf("Failed! expected ErrAlreadyHeld, got %v", err)
+	}
+
+	notHolder := func(h Hold) error {
+		if h.HeldBy != "kiosk-2" {
+			return ErrNotHolder
+		}
+		return nil
+	}
+	if _, err := b.release(hold.ID, notHolder); !errors.Is(err, ErrNotHolder) {
+		t.Errorf("Failed! expected ErrNotHolder, got %v", err)
+	}
+	if _, err := b.release(hold.ID, func(Hold) error { return nil }); err != nil {
+		t.Errorf("Failed! %v", err)
+	}
+	if _, err := b.release(hold.ID, func(Hold) error { return nil }); !errors.Is(err, ErrHoldNotFound) {
+		t.Errorf("Failed! expected ErrHoldNotFound, got %v", err)
+	}
+
+	got := receive(t, events, 2)
+	if got[0].Type != Held || got[1].Type != Released || got[1].Hold == nil || got[1].Hold.ID != hold.ID || got[1].Car.Id != 3 {
+		t.Errorf("Failed! unexpected events %v", got)
+	}
+}
+
+func TestHoldExpires(t *testing.T) {
+	f := newFeed()
+	b := newHoldBook(f)
+	events, err := f.subscribe(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := b.place(models.Car{Id: 3}, "kiosk-1", 10*time.Millisecond); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	got := receive(t, events, 2)
+	if got[1].Type != Released {
+		t.Errorf("Failed! expected the hold to be released, got %v", got[1])
+	}
+	if _, err := b.place(models.Car{Id: 3}, "kiosk-2", time.Hour); err != nil {
+		t.Errorf("Failed! car still held after the hold expired: %v", err)
+	}
+}
+
+func TestPlaceHoldUnknownCar(t *testing.T) {
+	if _, err := PlaceHold(context.Background(), 999, "kiosk-1", time.Minute); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Failed! expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+	"github.com/simrie/go-grpc-car-service/cars/tracing"
+)
+
+// seedDealers are the dealerships the seeded cars are listed by
+const seedDealers = `[
+    {
+        "id": 1,
+        "name": "Harbor Motors",
+        "city": "Portland",
+        "phone": "+1 503 555 0142"
+    },
+    {
+        "id": 2,
+        "name": "Ridgeline Auto",
+        "city": "Boise",
+        "phone": "+1 208 555 0187"
+    }
+]`
+
+// seedListings are the listings of the seeded cars, naming their dealer
+// by id
+const seedListings = `[
+    {
+        "car_id": 1,
+        "dealer_id": 1,
+        "photos": [
+            {"url": "https://images.example.com/cars/1/front.jpg", "caption": "Front"},
+            {"url": "https://images.example.com/cars/1/interior.jpg", "caption": "Interior"}
+        ],
+        "price_history": [
+            {"price_cents": 3290000, "time": "2024-03-01T09:00:00Z"},
+            {"price_cents": 3160000, "time": "2024-04-15T09:00:00Z"}
+        ]
+    },
+    {
+        "car_id": 2,
+        "dealer_id": 1,
+        "photos": [
+            {"url": "https://images.example.com/cars/2/front.jpg", "caption": "Front"}
+        ],
+        "price_history": [
+            {"price_cents": 2165000, "time": "2024-03-10T09:00:00Z"}
+        ]
+    },
+    {
+        "car_id": 3,
+        "dealer_id": 2,
+        "photos": [
+            {"url": "https://images.example.com/cars/3/front.jpg", "caption": "Front"},
+            {"url": "https://images.example.com/cars/3/rear.jpg", "caption": "Rear"}
+        ],
+        "price_history": [
+            {"price_cents": 2860000, "time": "2024-02-20T09:00:00Z"},
+            {"price_cents": 2760000, "time": "2024-04-01T09:00:00Z"}
+        ]
+    },
+    {
+        "car_id": 4,
+        "dealer_id": 2,
+        "photos": [],
+        "price_history": [
+            {"price_cents": 3795000, "time": "2024-04-05T09:00:00Z"}
+        ]
+    },
+    {
+        "car_id": 5,
+        "dealer_id": 1,
+        "photos": [
+            {"url": "https://images.example.com/cars/5/side.jpg", "caption": "Side"}
+        ],
+        "price_history": [
+            {"price_cents": 4100000, "time": "2024-01-15T09:00:00Z"}
+        ]
+    },
+    {
+        "car_id": 6,
+        "dealer_id": 2,
+        "photos": [],
+        "price_history": []
+    }
+]`
+
+/*
+listingBook holds the listings of cars in memory, standing in for a
+database table.  It is read only and loaded from seedDealers and
+seedListings on first use.
+*/
+type listingBook struct {
+	once  sync.Once
+	byCar map[int64]models.Listing
+	err   error
+}
+
+var listings = &listingBook{}
+
+/*
+GetListings returns the listings of the cars with carIDs in the order
+they were asked for, leaving out ids that match no car.  A car with
+nothing listed yet has a listing with no dealer, photos or prices.
+*/
+func GetListings(ctx context.Context, carIDs []int64) (found []models.Listing, err error) {
+	_, span := tracing.Start(ctx, "data.GetListings", attribute.Int("cars.count", len(carIDs)))
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return nil, ErrClosed
+	}
+	cars, err := stock.all()
+	if err != nil {
+		return nil, err
+	}
+	byCar, err := listings.load()
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[int64]bool, len(cars))
+	for _, car := range cars {
+		stored[car.Id] = true
+	}
+	for _, id := range carIDs {
+		if !stored[id] {
+			continue
+		}
+		listing, ok := byCar[id]
+		if !ok {
+			listing = models.Listing{CarId: id}
+		}
+		found = append(found, listing)
+	}
+	return found, nil
+}
+
+func (b *listingBook) load() (map[int64]models.Listing, error) {
+	b.once.Do(func() {
+		var dealers []models.Dealer
+		if b.err = json.Unmarshal([]byte(seedDealers), &dealers); b.err != nil {
+			return
+		}
+		var seeded []struct {
+			models.Listing
+			DealerId int64 `json:"dealer_id"`
+		}
+		if b.err = json.Unmarshal([]byte(seedListings), &seeded); b.err != nil {
+			return
+		}
+		byID := make(map[int64]*models.Dealer, len(dealers))
+		for i := range dealers {
+			byID[dealers[i].Id] = &dealers[i]
+		}
+		b.byCar = make(map[int64]models.Listing, len(seeded))
+		for _, s := range seeded {
+			s.Listing.Dealer = byID[s.DealerId]
+			b.byCar[s.CarId] = s.Listing
+		}
+	})
+	return b.byCar, b.err
+}
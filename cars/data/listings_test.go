@@ -0,0 +1,40 @@
+package data
+
+import (
+	"context"
+	"testing"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+func TestGetListings(t *testing.T) {
+	ctx := context.Background()
+	found, err := GetListings(ctx, []int64{3, 999, 1})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if len(found) != 2 || found[0].CarId != 3 || found[1].CarId != 1 {
+		t.Fatalf("Failed! expected the listings of cars 3 and 1, got %+v", found)
+	}
+	rav4 := found[0]
+	if rav4.Dealer == nil || rav4.Dealer.Name != "Ridgeline Auto" {
+		t.Errorf("Failed! unexpected dealer %+v", rav4.Dealer)
+	}
+	if len(rav4.Photos) != 2 || len(rav4.PriceHistory) != 2 || !rav4.PriceHistory[0].Time.Before(rav4.PriceHistory[1].Time) {
+		t.Errorf("Failed! unexpected listing %+v", rav4)
+	}
+
+	// a car with nothing listed has an empty listing
+	created, err := CreateRecord(ctx, models.Car{TradeIn: models.TradeIn{Make: "Kia", Model: "Soul"}})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer DeleteRecord(ctx, created.Id)
+	found, err = GetListings(ctx, []int64{created.Id})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if len(found) != 1 || found[0].CarId != created.Id || found[0].Dealer != nil || len(found[0].Photos) != 0 {
+		t.Errorf("Failed! unexpected listing of a new car %+v", found)
+	}
+}
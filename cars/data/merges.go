@@ -0,0 +1,126 @@
+package data
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/simrie/go-grpc-car-service/cars/metrics"
+	"github.com/simrie/go-grpc-car-service/cars/models"
+	"github.com/simrie/go-grpc-car-service/cars/tracing"
+)
+
+/*
+Merge records cars merged into one, so the records a merge replaced are
+kept after the cars themselves are gone
+*/
+type Merge struct {
+	// Before is the car merged into, as it was
+	Before models.Car
+	// Merged are the cars merged into it, as they were; they are deleted
+	Merged []models.Car
+	// After is the car as the merge stored it
+	After    models.Car
+	MergedBy string
+	Time     time.Time
+}
+
+/*
+MergeRecords merges the cars with duplicateIDs into the car with id:
+combine is given the cars as they are and returns the car to store in
+its place, and the duplicates are deleted.  It fails with ErrNotFound
+when one of the cars does not exist, with ErrHeld when a duplicate is on
+hold and with combine's error, in which case nothing changes.
+*/
+func MergeRecords(ctx context.Context, id int64, duplicateIDs []int64, mergedBy string, combine func(car models.Car, duplicates []models.Car) (models.Car, error)) (merge Merge, err error) {
+	_, span := tracing.Start(ctx, "data.MergeRecords", attribute.Int64("cars.id", id), attribute.Int("cars.count", len(duplicateIDs)))
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return merge, ErrClosed
+	}
+	return stock.merge(id, duplicateIDs, mergedBy, combine)
+}
+
+/*
+MergeHistory returns the merges that made the car with id what it is,
+oldest first: those into it and, as their cars' records live on in it,
+those into the cars merged into it
+*/
+func MergeHistory(ctx context.Context, id int64) (merges []Merge, err error) {
+	_, span := tracing.Start(ctx, "data.MergeHistory", attribute.Int64("cars.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return nil, ErrClosed
+	}
+	return stock.mergeHistory(id), nil
+}
+
+func (inv *inventory) merge(id int64, duplicateIDs []int64, mergedBy string, combine func(models.Car, []models.Car) (models.Car, error)) (Merge, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err := inv.seed(); err != nil {
+		return Merge{}, err
+	}
+	i := inv.index(id)
+	if i < 0 {
+		return Merge{}, ErrNotFound
+	}
+	m := Merge{Before: inv.cars[i], MergedBy: mergedBy, Time: inv.feed.now()}
+	for _, dup := range duplicateIDs {
+		j := inv.index(dup)
+		if j < 0 {
+			return Merge{}, ErrNotFound
+		}
+		if inv.holds.isHeld(dup) {
+			return Merge{}, ErrHeld
+		}
+		m.Merged = append(m.Merged, inv.cars[j])
+	}
+	after, err := combine(m.Before, m.Merged)
+	if err != nil {
+		return Merge{}, err
+	}
+	after.Id = id
+	m.After = after
+
+	inv.cars[i] = after
+	for _, dup := range m.Merged {
+		j := inv.index(dup.Id)
+		inv.cars = append(inv.cars[:j], inv.cars[j+1:]...)
+		inv.feed.publish(Deleted, dup)
+	}
+	inv.feed.publish(Updated, after)
+	inv.merges = append(inv.merges, m)
+	metrics.SetInventorySize(len(inv.cars))
+	return m, nil
+}
+
+func (inv *inventory) mergeHistory(id int64) []Merge {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	var found []int
+	ids := []int64{id}
+	for len(ids) > 0 {
+		id, ids = ids[0], ids[1:]
+		for i, m := range inv.merges {
+			if m.After.Id == id {
+				found = append(found, i)
+				for _, dup := range m.Merged {
+					ids = append(ids, dup.Id)
+				}
+			}
+		}
+	}
+	// a car is merged into another once at most, as it is then deleted,
+	// so no merge is found twice
+	sort.Ints(found)
+	merges := make([]Merge, len(found))
+	for k, i := range found {
+		merges[k] = inv.merges[i]
+	}
+	return merges
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+// keepFirst combines cars by keeping the first as it is
+func keepFirst(car models.Car, _ []models.Car) (models.Car, error) {
+	return car, nil
+}
+
+func TestMerges(t *testing.T) {
+	f := newFeed()
+	b := newHoldBook(f)
+	inv := newInventory(f, b)
+
+	if _, err := inv.merge(1, []int64{99}, "m", keepFirst); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Failed! expected ErrNotFound, got %v", err)
+	}
+	if _, err := b.place(models.Car{Id: 3}, "kiosk-1", time.Hour); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := inv.merge(1, []int64{2, 3}, "m", keepFirst); !errors.Is(err, ErrHeld) {
+		t.Errorf("Failed! expected ErrHeld, got %v", err)
+	}
+	refused := errors.New("refused")
+	if _, err := inv.merge(1, []int64{2}, "m", func(models.Car, []models.Car) (models.Car, error) { return models.Car{}, refused }); err != refused {
+		t.Errorf("Failed! expected combine's error, got %v", err)
+	}
+	if cars, _ := inv.all(); len(cars) != 6 {
+		t.Errorf("Failed! a refused merge changed the inventory: %v", cars)
+	}
+
+	events, err := f.subscribe(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	m, err := inv.merge(2, []int64{4}, "m", func(car models.Car, dups []models.Car) (models.Car, error) {
+		car.Mileage = dups[0].Mileage + 10
+		return car, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if m.Before.Id != 2 || len(m.Merged) != 1 || m.Merged[0].Model != "Bronco" || m.After.Mileage != 10 || m.MergedBy != "m" {
+		t.Errorf("Failed! unexpected merge %+v", m)
+	}
+	got := receive(t, events, 2)
+	if got[0].Type != Deleted || got[0].Car.Id != 4 || got[1].Type != Updated || got[1].Car.Id != 2 {
+		t.Errorf("Failed! unexpected events %v", got)
+	}
+
+	// the history of a car merged into another is kept with it
+	if _, err := inv.merge(5, []int64{2}, "m", keepFirst); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	history := inv.mergeHistory(5)
+	if len(history) != 2 || history[0].Before.Id != 2 || history[1].Merged[0].Id != 2 {
+		t.Errorf("Failed! unexpected history %+v", history)
+	}
+	if len(inv.mergeHistory(1)) != 0 {
+		t.Errorf("Failed! car 1 has a merge history")
+	}
+	if cars, _ := inv.all(); len(cars) != 4 {
+		t.Errorf("Failed! %d cars after merging, want 4", len(cars))
+	}
+}
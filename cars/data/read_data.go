@@ -1,53 +1,117 @@
 package data
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/simrie/go-grpc-car-service/cars/metrics"
 	"github.com/simrie/go-grpc-car-service/cars/models"
+	"github.com/simrie/go-grpc-car-service/cars/tracing"
 )
 
-/*
-	GetAllRecords returns all the Car records
-	from the hard-coded data standing in for a database
-*/
-func GetAllRecords() ([]models.Car, error) {
-	json_string := `[
+// ErrClosed is returned by reads made after Close
+var ErrClosed = errors.New("data: repository is closed")
+
+// closed is set by Close
+var closed atomic.Bool
+
+// seedRecords are the cars the inventory starts with
+const seedRecords = `[
     {
         "id": 1,
         "make": "Ford",
-        "model": "F10"
+        "model": "F10",
+        "acquisition_cost_cents": 2850000,
+        "margin_cents": 310000
     },
     {
         "id": 2,
         "make": "Toyota",
-        "model": "Camry"
+        "model": "Camry",
+        "acquisition_cost_cents": 1920000,
+        "margin_cents": 245000
     },
     {
         "id": 3,
         "make": "Toyota",
-        "model": "Rav4"
+        "model": "Rav4",
+        "acquisition_cost_cents": 2480000,
+        "margin_cents": 280000
     },
     {
         "id": 4,
         "make": "Ford",
-        "model": "Bronco"
+        "model": "Bronco",
+        "acquisition_cost_cents": 3390000,
+        "margin_cents": 405000
     },
     {
         "id": 5,
         "make": "Toyota",
-        "model": "Tundra"
+        "model": "Tundra",
+        "acquisition_cost_cents": 3710000,
+        "margin_cents": 390000
     },
     {
     	"id": 6,
         "make": "Honda",
-		"model": "Fit"
+		"model": "Fit",
+		"acquisition_cost_cents": 1310000,
+		"margin_cents": 160000
     }
-	]`
-	var cars []models.Car
-	err := json.Unmarshal([]byte(json_string), &cars)
+]`
+
+/*
+	GetAllRecords returns all the Car records
+	from the in-memory inventory standing in for a database
+*/
+func GetAllRecords() ([]models.Car, error) {
+	return GetAllRecordsContext(context.Background())
+}
+
+/*
+	GetAllRecordsContext is GetAllRecords traced as part
+	of the request carried by ctx
+*/
+func GetAllRecordsContext(ctx context.Context) (cars []models.Car, err error) {
+	defer metrics.ObserveLookup("all", time.Now())
+	_, span := tracing.Start(ctx, "data.GetAllRecords")
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return nil, ErrClosed
+	}
+	cars, err = stock.all()
 	if err != nil {
 		return nil, err
 	}
+	metrics.SetInventorySize(len(cars))
+	span.SetAttributes(attribute.Int("cars.count", len(cars)))
+	return cars, nil
+}
+
+/*
+	GetRecordsPage returns up to limit cars with ids above afterID,
+	in id order, so callers can walk the inventory a page at a time
+	rather than copying it whole
+*/
+func GetRecordsPage(ctx context.Context, afterID int64, limit int) (cars []models.Car, err error) {
+	defer metrics.ObserveLookup("page", time.Now())
+	_, span := tracing.Start(ctx, "data.GetRecordsPage", attribute.Int64("cars.after_id", afterID))
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return nil, ErrClosed
+	}
+	cars, err = stock.page(afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("cars.count", len(cars)))
 	return cars, nil
 }
 
@@ -56,8 +120,19 @@ func GetAllRecords() ([]models.Car, error) {
 	the hard-coded data substituting for a database
 */
 func GetRecordById(searchId int64) (models.Car, error) {
-	var car models.Car
-	data, err := GetAllRecords()
+	return GetRecordByIdContext(context.Background(), searchId)
+}
+
+/*
+	GetRecordByIdContext is GetRecordById traced as part
+	of the request carried by ctx
+*/
+func GetRecordByIdContext(ctx context.Context, searchId int64) (car models.Car, err error) {
+	defer metrics.ObserveLookup("by_id", time.Now())
+	ctx, span := tracing.Start(ctx, "data.GetRecordById", attribute.Int64("cars.id", searchId))
+	defer func() { tracing.End(span, err) }()
+
+	data, err := GetAllRecordsContext(ctx)
 	if err != nil {
 		return car, err
 	}
@@ -69,3 +144,23 @@ func GetRecordById(searchId int64) (models.Car, error) {
 	}
 	return car, nil
 }
+
+/*
+	Ping reports whether the records can be read,
+	standing in for a database connection check
+*/
+func Ping(ctx context.Context) error {
+	_, err := GetAllRecordsContext(ctx)
+	return err
+}
+
+/*
+	Close releases the repository, standing in for closing
+	a database connection.  Reads made afterwards fail with ErrClosed,
+	and subscriptions to changes end.
+*/
+func Close() error {
+	closed.Store(true)
+	changes.closeAll()
+	return nil
+}
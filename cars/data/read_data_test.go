@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"testing"
 
 	"github.com/simrie/go-grpc-car-service/cars/models"
@@ -21,3 +22,19 @@ func TestGetRecordById(t *testing.T) {
 		t.Errorf("Failed! %v :", err)
 	}
 }
+
+func TestPing(t *testing.T) {
+	if err := Ping(context.Background()); err != nil {
+		t.Errorf("Failed! %v :", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	defer closed.Store(false)
+	if err := Close(); err != nil {
+		t.Fatalf("Failed! %v :", err)
+	}
+	if _, err := GetAllRecords(); err != ErrClosed {
+		t.Errorf("Failed! expected ErrClosed after Close, got %v", err)
+	}
+}
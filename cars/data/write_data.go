@@ -0,0 +1,306 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/simrie/go-grpc-car-service/cars/metrics"
+	"github.com/simrie/go-grpc-car-service/cars/models"
+	"github.com/simrie/go-grpc-car-service/cars/tracing"
+)
+
+var (
+	// ErrHeld is returned when deleting a car that is on hold
+	ErrHeld = errors.New("data: car is on hold")
+	// ErrChanged is returned when applying cars planned from a snapshot
+	// after the cars they update have changed
+	ErrChanged = errors.New("data: car changed since the snapshot")
+)
+
+/*
+Snapshot is the inventory as it was at one moment, with the cars that
+were on hold then
+*/
+type Snapshot struct {
+	Cars []models.Car
+	// Held are the ids of the cars on hold
+	Held map[int64]bool
+}
+
+/*
+inventory holds the cars in memory, standing in for a database table.
+It is seeded from seedRecords on first use and every change is published
+to feed.
+*/
+type inventory struct {
+	mu     sync.Mutex
+	cars   []models.Car
+	seeded bool
+	lastID int64
+	feed   *feed
+	holds  *holdBook
+	// merges are every merge made, oldest first
+	merges []Merge
+}
+
+var stock = newInventory(changes, holds)
+
+func newInventory(f *feed, b *holdBook) *inventory {
+	return &inventory{feed: f, holds: b}
+}
+
+/*
+CreateRecord adds car to the inventory with a new id, ignoring any id it
+has, and returns it as stored
+*/
+func CreateRecord(ctx context.Context, car models.Car) (created models.Car, err error) {
+	_, span := tracing.Start(ctx, "data.CreateRecord")
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return created, ErrClosed
+	}
+	created, err = stock.create(car)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("cars.id", created.Id))
+	}
+	return created, err
+}
+
+/*
+UpdateRecord replaces the car with car.Id by car.  It fails with
+ErrNotFound when there is no such car.
+*/
+func UpdateRecord(ctx context.Context, car models.Car) (updated models.Car, err error) {
+	_, span := tracing.Start(ctx, "data.UpdateRecord", attribute.Int64("cars.id", car.Id))
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return updated, ErrClosed
+	}
+	return stock.update(car)
+}
+
+/*
+DeleteRecord removes the car with id and returns it as it was.  It fails
+with ErrNotFound when there is no such car and with ErrHeld while the car
+is on hold.
+*/
+func DeleteRecord(ctx context.Context, id int64) (deleted models.Car, err error) {
+	_, span := tracing.Start(ctx, "data.DeleteRecord", attribute.Int64("cars.id", id))
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return deleted, ErrClosed
+	}
+	return stock.delete(id)
+}
+
+/*
+GetSnapshot returns a copy of every car, and which are on hold, as they
+are at one moment
+*/
+func GetSnapshot(ctx context.Context) (snapshot Snapshot, err error) {
+	_, span := tracing.Start(ctx, "data.GetSnapshot")
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return snapshot, ErrClosed
+	}
+	return stock.snapshot()
+}
+
+/*
+ApplyRecords writes cars, planned from base, in one step: those with no
+id are created and the others replace the car with their id.  Either
+every car is written or none is: the error is ErrNotFound when one of
+the ids is not in base, and ErrChanged when a car to replace is no
+longer as it was in base, having been changed, deleted, put on hold or
+released since.  The cars are returned as stored, in the same order.
+*/
+func ApplyRecords(ctx context.Context, cars []models.Car, base Snapshot) (applied []models.Car, err error) {
+	_, span := tracing.Start(ctx, "data.ApplyRecords", attribute.Int("cars.count", len(cars)))
+	defer func() { tracing.End(span, err) }()
+
+	if closed.Load() {
+		return nil, ErrClosed
+	}
+	return stock.apply(cars, base)
+}
+
+// all returns a copy of every car
+func (inv *inventory) all() ([]models.Car, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err := inv.seed(); err != nil {
+		return nil, err
+	}
+	return append([]models.Car(nil), inv.cars...), nil
+}
+
+// page returns a copy of up to limit cars with ids above afterID.  The
+// cars are kept in id order, as new ones always get the highest id.
+func (inv *inventory) page(afterID int64, limit int) ([]models.Car, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err := inv.seed(); err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(inv.cars), func(i int) bool { return inv.cars[i].Id > afterID })
+	j := len(inv.cars)
+	if j-i > limit {
+		j = i + limit
+	}
+	return append([]models.Car(nil), inv.cars[i:j]...), nil
+}
+
+func (inv *inventory) create(car models.Car) (models.Car, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err := inv.seed(); err != nil {
+		return car, err
+	}
+	inv.lastID++
+	car.Id = inv.lastID
+	inv.cars = append(inv.cars, car)
+	metrics.SetInventorySize(len(inv.cars))
+	inv.feed.publish(Created, car)
+	return car, nil
+}
+
+func (inv *inventory) snapshot() (Snapshot, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err := inv.seed(); err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{
+		Cars: append([]models.Car(nil), inv.cars...),
+		Held: inv.holds.heldIDs(),
+	}, nil
+}
+
+func (inv *inventory) apply(cars []models.Car, base Snapshot) ([]models.Car, error) {
+	planned := make(map[int64]models.Car, len(base.Cars))
+	for _, car := range base.Cars {
+		planned[car.Id] = car
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err := inv.seed(); err != nil {
+		return nil, err
+	}
+	for _, car := range cars {
+		if car.Id == 0 {
+			continue
+		}
+		before, ok := planned[car.Id]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		i := inv.index(car.Id)
+		if i < 0 || inv.cars[i] != before || inv.holds.isHeld(car.Id) != base.Held[car.Id] {
+			return nil, ErrChanged
+		}
+	}
+	applied := make([]models.Car, 0, len(cars))
+	for _, car := range cars {
+		if car.Id == 0 {
+			inv.lastID++
+			car.Id = inv.lastID
+			inv.cars = append(inv.cars, car)
+			inv.feed.publish(Created, car)
+		} else {
+			inv.cars[inv.index(car.Id)] = car
+			inv.feed.publish(Updated, car)
+		}
+		applied = append(applied, car)
+	}
+	metrics.SetInventorySize(len(inv.cars))
+	return applied, nil
+}
+
+func (inv *inventory) update(car models.Car) (models.Car, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err := inv.seed(); err != nil {
+		return car, err
+	}
+	i := inv.index(car.Id)
+	if i < 0 {
+		return models.Car{}, ErrNotFound
+	}
+	inv.cars[i] = car
+	inv.feed.publish(Updated, car)
+	return car, nil
+}
+
+// hold places a hold on the car with id while the inventory is locked,
+// so the car cannot be deleted between the lookup and the hold
+func (inv *inventory) hold(id int64, heldBy string, d time.Duration) (Hold, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err := inv.seed(); err != nil {
+		return Hold{}, err
+	}
+	i := inv.index(id)
+	if i < 0 {
+		return Hold{}, ErrNotFound
+	}
+	return inv.holds.place(inv.cars[i], heldBy, d)
+}
+
+func (inv *inventory) delete(id int64) (models.Car, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if err := inv.seed(); err != nil {
+		return models.Car{}, err
+	}
+	i := inv.index(id)
+	if i < 0 {
+		return models.Car{}, ErrNotFound
+	}
+	if inv.holds.isHeld(id) {
+		return models.Car{}, ErrHeld
+	}
+	car := inv.cars[i]
+	inv.cars = append(inv.cars[:i], inv.cars[i+1:]...)
+	metrics.SetInventorySize(len(inv.cars))
+	inv.feed.publish(Deleted, car)
+	return car, nil
+}
+
+// index returns the position of the car with id, or -1
+func (inv *inventory) index(id int64) int {
+	for i := range inv.cars {
+		if inv.cars[i].Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// seed loads seedRecords the first time the inventory is used.  Ids of
+// deleted cars are never handed out again.
+func (inv *inventory) seed() error {
+	if inv.seeded {
+		return nil
+	}
+	var cars []models.Car
+	if err := json.Unmarshal([]byte(seedRecords), &cars); err != nil {
+		return err
+	}
+	for _, car := range cars {
+		if car.Id > inv.lastID {
+			inv.lastID = car.Id
+		}
+	}
+	inv.cars, inv.seeded = cars, true
+	return nil
+}
@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+func TestInventoryWrites(t *testing.T) {
+	f := newFeed()
+	b := newHoldBook(f)
+	inv := newInventory(f, b)
+	events, err := f.subscribe(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+
+	created, err := inv.create(models.Car{Id: 2, TradeIn: models.TradeIn{Make: "Mazda", Model: "CX-5"}})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if created.Id != 7 {
+		t.Errorf("Failed! created car got id %d, want 7", created.Id)
+	}
+
+	created.Model = "CX-50"
+	if _, err := inv.update(created); err != nil {
+		t.Errorf("Failed! %v", err)
+	}
+	if _, err := inv.update(models.Car{Id: 99}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Failed! expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := b.place(created, "kiosk-1", time.Hour); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := inv.delete(created.Id); !errors.Is(err, ErrHeld) {
+		t.Errorf("Failed! expected ErrHeld, got %v", err)
+	}
+	if _, err := inv.delete(1); err != nil {
+		t.Errorf("Failed! %v", err)
+	}
+	if _, err := inv.delete(1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Failed! expected ErrNotFound, got %v", err)
+	}
+
+	cars, err := inv.all()
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if len(cars) != 6 || cars[0].Id != 2 || cars[5].Model != "CX-50" {
+		t.Errorf("Failed! unexpected inventory %v", cars)
+	}
+
+	// ids are not reused
+	if again, _ := inv.create(models.Car{}); again.Id != 8 {
+		t.Errorf("Failed! created car got id %d, want 8", again.Id)
+	}
+
+	got := receive(t, events, 5)
+	want := []EventType{Created, Updated, Held, Deleted, Created}
+	for i := range want {
+		if got[i].Type != want[i] {
+			t.Errorf("Failed! event %d is %v, want %v", i, got[i].Type, want[i])
+		}
+	}
+	if got[3].Car.Id != 1 {
+		t.Errorf("Failed! deleted event carries car %d, want 1", got[3].Car.Id)
+	}
+}
+
+func TestHoldDeletedCar(t *testing.T) {
+	f := newFeed()
+	inv := newInventory(f, newHoldBook(f))
+	if _, err := inv.delete(2); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := inv.hold(2, "kiosk-1", time.Hour); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Failed! expected ErrNotFound for a deleted car, got %v", err)
+	}
+	hold, err := inv.hold(3, "kiosk-1", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if hold.CarID != 3 || hold.HeldBy != "kiosk-1" {
+		t.Errorf("Failed! unexpected hold %+v", hold)
+	}
+	if _, err := inv.delete(3); !errors.Is(err, ErrHeld) {
+		t.Errorf("Failed! expected ErrHeld, got %v", err)
+	}
+}
+
+func TestApplyIsAtomic(t *testing.T) {
+	f := newFeed()
+	inv := newInventory(f, newHoldBook(f))
+
+	batch := []models.Car{
+		{TradeIn: models.TradeIn{Make: "Kia", Model: "Soul"}},
+		{Id: 2, TradeIn: models.TradeIn{Make: "Toyota", Model: "Corolla"}},
+		{Id: 99},
+	}
+	base, err := inv.snapshot()
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := inv.apply(batch, base); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Failed! expected ErrNotFound, got %v", err)
+	}
+	if cars, _ := inv.all(); len(cars) != 6 || cars[1].Model != "Camry" {
+		t.Errorf("Failed! a failed batch changed the inventory: %v", cars)
+	}
+
+	applied, err := inv.apply(batch[:2], base)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if len(applied) != 2 || applied[0].Id != 7 || applied[1].Model != "Corolla" {
+		t.Errorf("Failed! unexpected applied cars %v", applied)
+	}
+	if cars, _ := inv.all(); len(cars) != 7 || cars[1].Model != "Corolla" {
+		t.Errorf("Failed! unexpected inventory %v", cars)
+	}
+}
+
+func TestApplyChangedSinceSnapshot(t *testing.T) {
+	f := newFeed()
+	b := newHoldBook(f)
+	inv := newInventory(f, b)
+	batch := []models.Car{
+		{TradeIn: models.TradeIn{Make: "Kia", Model: "Soul"}},
+		{Id: 2, TradeIn: models.TradeIn{Make: "Toyota", Model: "Corolla"}},
+	}
+
+	// an edit between planning and applying
+	base, err := inv.snapshot()
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := inv.update(models.Car{Id: 2, TradeIn: models.TradeIn{Make: "Toyota", Model: "Camry"}, MarginCents: 1}); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := inv.apply(batch, base); !errors.Is(err, ErrChanged) {
+		t.Errorf("Failed! expected ErrChanged after an edit, got %v", err)
+	}
+	if cars, _ := inv.all(); len(cars) != 6 || cars[1].MarginCents != 1 {
+		t.Errorf("Failed! the edit was overwritten: %v", cars)
+	}
+
+	// a hold placed between planning and applying
+	base, _ = inv.snapshot()
+	hold, err := inv.hold(2, "kiosk-1", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := inv.apply(batch, base); !errors.Is(err, ErrChanged) {
+		t.Errorf("Failed! expected ErrChanged after a hold, got %v", err)
+	}
+
+	// a car on hold when planned is expected to still be on hold
+	base, _ = inv.snapshot()
+	if !base.Held[2] {
+		t.Errorf("Failed! snapshot does not show car 2 on hold: %v", base.Held)
+	}
+	if _, err := b.release(hold.ID, func(Hold) error { return nil }); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := inv.apply(batch, base); !errors.Is(err, ErrChanged) {
+		t.Errorf("Failed! expected ErrChanged after a release, got %v", err)
+	}
+
+	// a deletion between planning and applying
+	base, _ = inv.snapshot()
+	if _, err := inv.delete(2); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := inv.apply(batch, base); !errors.Is(err, ErrChanged) {
+		t.Errorf("Failed! expected ErrChanged after a deletion, got %v", err)
+	}
+	if cars, _ := inv.all(); len(cars) != 5 {
+		t.Errorf("Failed! a failed batch changed the inventory: %v", cars)
+	}
+}
+
+func TestInventoryPages(t *testing.T) {
+	f := newFeed()
+	inv := newInventory(f, newHoldBook(f))
+	if _, err := inv.delete(3); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if _, err := inv.create(models.Car{}); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+
+	var ids []int64
+	for after := int64(0); ; {
+		page, err := inv.page(after, 2)
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		if len(page) > 2 {
+			t.Errorf("Failed! page of %d cars, want at most 2", len(page))
+		}
+		for _, car := range page {
+			ids = append(ids, car.Id)
+		}
+		after = page[len(page)-1].Id
+	}
+	if fmt.Sprint(ids) != "[1 2 4 5 6 7]" {
+		t.Errorf("Failed! paged through %v", ids)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Package dedup finds cars in the inventory that are probably the same
+vehicle listed twice, as happens when several sources are imported, and
+combines such cars into one
+*/
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+// DefaultMileageTolerance is how far apart, in miles, the mileages of
+// likely duplicates may be when no tolerance is given
+const DefaultMileageTolerance = 1000
+
+/*
+Kind says why two cars are thought to be the same vehicle
+*/
+type Kind int
+
+const (
+	// VIN duplicates have the same VIN
+	VIN Kind = iota + 1
+	// Likely duplicates have no VINs that tell them apart and the same
+	// make, model, year and color, with mileages within the tolerance
+	Likely
+)
+
+func (k Kind) String() string {
+	switch k {
+	case VIN:
+		return "vin"
+	case Likely:
+		return "likely"
+	}
+	return "unknown"
+}
+
+/*
+Match is a pair of cars thought to be the same vehicle.  Car has the
+lower id, so it was usually listed first.
+*/
+type Match struct {
+	Kind      Kind
+	Car       models.Car
+	Duplicate models.Car
+	Reason    string
+}
+
+// ErrConflict is returned by Combine for cars with different VINs
+var ErrConflict = errors.New("dedup: cars have different VINs")
+
+/*
+Find returns the pairs of cars that are probably the same vehicle: VIN
+matches first, then likely duplicates, each in order of their cars' ids.
+Cars whose year or color is not known are never likely duplicates, as
+too little is known to tell.
+*/
+func Find(cars []models.Car, mileageTolerance int64) []Match {
+	sorted := append([]models.Car(nil), cars...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id < sorted[j].Id })
+
+	var matches []Match
+	byVIN := map[string][]models.Car{}
+	var vins []string
+	for _, car := range sorted {
+		vin := models.NormalizeVIN(car.Vin)
+		if vin == "" {
+			continue
+		}
+		if _, ok := byVIN[vin]; !ok {
+			vins = append(vins, vin)
+		}
+		byVIN[vin] = append(byVIN[vin], car)
+	}
+	for _, vin := range vins {
+		group := byVIN[vin]
+		for _, dup := range group[1:] {
+			matches = append(matches, Match{Kind: VIN, Car: group[0], Duplicate: dup, Reason: "same VIN " + vin})
+		}
+	}
+
+	// cars that can only be likely duplicates of each other share a key;
+	// within a key, sorted by mileage, each car is compared with those
+	// after it until the mileage is out of reach
+	type key struct {
+		make, model string
+		year        int32
+		color       string
+	}
+	byKey := map[key][]models.Car{}
+	var keys []key
+	for _, car := range sorted {
+		if car.Year == 0 || strings.TrimSpace(car.Color) == "" {
+			continue
+		}
+		k := key{fold(car.Make), fold(car.Model), car.Year, fold(car.Color)}
+		if _, ok := byKey[k]; !ok {
+			keys = append(keys, k)
+		}
+		byKey[k] = append(byKey[k], car)
+	}
+	var likely []Match
+	for _, k := range keys {
+		group := byKey[k]
+		sort.SliceStable(group, func(i, j int) bool { return group[i].Mileage < group[j].Mileage })
+		for i, a := range group {
+			for _, b := range group[i+1:] {
+				if b.Mileage-a.Mileage > mileageTolerance {
+					break
+				}
+				if a.Vin != "" && b.Vin != "" {
+					// the same VIN is already matched; different ones
+					// are different vehicles
+					continue
+				}
+				car, dup := a, b
+				if dup.Id < car.Id {
+					car, dup = dup, car
+				}
+				likely = append(likely, likelyMatch(car, dup))
+			}
+		}
+	}
+	sort.Slice(likely, func(i, j int) bool {
+		if likely[i].Car.Id != likely[j].Car.Id {
+			return likely[i].Car.Id < likely[j].Car.Id
+		}
+		return likely[i].Duplicate.Id < likely[j].Duplicate.Id
+	})
+	return append(matches, likely...)
+}
+
+func likelyMatch(car, dup models.Car) Match {
+	diff := dup.Mileage - car.Mileage
+	if diff < 0 {
+		diff = -diff
+	}
+	return Match{
+		Kind:      Likely,
+		Car:       car,
+		Duplicate: dup,
+		Reason:    fmt.Sprintf("same make, model, year and color; mileage %d miles apart", diff),
+	}
+}
+
+// fold returns s ready for comparing without regard to case or spacing
+func fold(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+/*
+Combine returns car with what it does not know filled in from
+duplicates, in order: the VIN, year, color and costs.  The mileage is
+the highest of them all, as the latest reading.  Cars with different
+VINs cannot be combined.
+*/
+func Combine(car models.Car, duplicates []models.Car) (models.Car, error) {
+	for _, dup := range duplicates {
+		if car.Vin != "" && dup.Vin != "" && car.Vin != dup.Vin {
+			return car, fmt.Errorf("%w: car %d has %s and car %d has %s", ErrConflict, car.Id, car.Vin, dup.Id, dup.Vin)
+		}
+		if car.Vin == "" {
+			car.Vin = dup.Vin
+		}
+		if car.Year == 0 {
+			car.Year = dup.Year
+		}
+		if car.Color == "" {
+			car.Color = dup.Color
+		}
+		if car.AcquisitionCostCents == 0 {
+			car.AcquisitionCostCents = dup.AcquisitionCostCents
+		}
+		if car.MarginCents == 0 {
+			car.MarginCents = dup.MarginCents
+		}
+		if dup.Mileage > car.Mileage {
+			car.Mileage = dup.Mileage
+		}
+	}
+	return car, nil
+}
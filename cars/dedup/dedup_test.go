@@ -0,0 +1,82 @@
+package dedup
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+func car(id int64, make, model, vin string, year int32, color string, mileage int64) models.Car {
+	return models.Car{
+		TradeIn: models.TradeIn{Make: make, Model: model},
+		Id:      id,
+		Vin:     vin,
+		Year:    year,
+		Color:   color,
+		Mileage: mileage,
+	}
+}
+
+func TestFind(t *testing.T) {
+	cars := []models.Car{
+		car(1, "Toyota", "Camry", "4T1B11HK5JU000001", 2018, "Silver", 40000),
+		car(2, "Ford", "Bronco", "", 2022, "Red", 12000),
+		car(3, "toyota", "CAMRY", "4T1B11HK5JU000001", 2019, "Blue", 90000),
+		car(4, "Ford", "Bronco", "", 2022, "red", 12800),
+		car(5, "Ford", "Bronco", "", 2022, "Red", 13500),
+		// different VINs are different vehicles, however alike
+		car(6, "Honda", "Fit", "JHMGD38477S000001", 2017, "White", 50000),
+		car(7, "Honda", "Fit", "JHMGD38477S000002", 2017, "White", 50000),
+		// too little is known to tell
+		car(8, "Honda", "Fit", "", 0, "", 50000),
+		car(9, "Honda", "Fit", "", 0, "", 50000),
+	}
+	matches := Find(cars, DefaultMileageTolerance)
+	want := []struct {
+		kind     Kind
+		car, dup int64
+	}{
+		{VIN, 1, 3},
+		{Likely, 2, 4},
+		{Likely, 4, 5},
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("Failed! got %d matches, want %d: %+v", len(matches), len(want), matches)
+	}
+	for i, w := range want {
+		m := matches[i]
+		if m.Kind != w.kind || m.Car.Id != w.car || m.Duplicate.Id != w.dup {
+			t.Errorf("Failed! match %d is %v %d~%d, want %v %d~%d", i, m.Kind, m.Car.Id, m.Duplicate.Id, w.kind, w.car, w.dup)
+		}
+	}
+	if matches[1].Reason != "same make, model, year and color; mileage 800 miles apart" {
+		t.Errorf("Failed! unexpected reason %q", matches[1].Reason)
+	}
+
+	if got := len(Find(cars, 2000)); got != 4 {
+		t.Errorf("Failed! got %d matches with a wider tolerance, want 4", got)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	kept := car(2, "Ford", "Bronco", "", 2022, "", 12000)
+	kept.MarginCents = 400000
+	dup := car(4, "Ford", "Bronco", "1FMDE5BH0NLA00001", 2021, "Red", 12800)
+	dup.AcquisitionCostCents, dup.MarginCents = 3300000, 350000
+
+	got, err := Combine(kept, []models.Car{dup})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	want := car(2, "Ford", "Bronco", "1FMDE5BH0NLA00001", 2022, "Red", 12800)
+	want.AcquisitionCostCents, want.MarginCents = 3300000, 400000
+	if got != want {
+		t.Errorf("Failed! combined into %+v, want %+v", got, want)
+	}
+
+	other := car(5, "Ford", "Bronco", "1FMDE5BH0NLA00002", 2022, "Red", 14000)
+	if _, err := Combine(got, []models.Car{other}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Failed! expected ErrConflict, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Package exporter writes cars to CSV, NDJSON or Parquet files one at a
+time, so an export of any size never holds the whole inventory
+*/
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/importer"
+)
+
+/*
+Format is a file format cars can be exported to
+*/
+type Format int
+
+const (
+	// CSV has a header row naming the columns
+	CSV Format = iota + 1
+	// NDJSON has a JSON object per line, with the columns in order
+	NDJSON
+	// Parquet is a columnar file for analytics tools
+	Parquet
+)
+
+/*
+Columns are the fields an exported car may have, the same as an import
+reads, so CSV and NDJSON exports import back as they are
+*/
+var Columns = importer.Columns
+
+// parquetTypes are the Parquet schemas of Columns
+var parquetTypes = map[string]string{
+	"id":                     "type=INT64",
+	"make":                   "type=BYTE_ARRAY, convertedtype=UTF8",
+	"model":                  "type=BYTE_ARRAY, convertedtype=UTF8",
+	"acquisition_cost_cents": "type=INT64",
+	"margin_cents":           "type=INT64",
+	"vin":                    "type=BYTE_ARRAY, convertedtype=UTF8",
+	"year":                   "type=INT32",
+	"color":                  "type=BYTE_ARRAY, convertedtype=UTF8",
+	"mileage":                "type=INT64",
+}
+
+// parquetRowGroupSize keeps the rows a Parquet writer buffers small
+const parquetRowGroupSize = 1 << 20
+
+/*
+Writer writes cars to a file.  Close must be called after the last car;
+it finishes the file without closing what it is written to.
+*/
+type Writer interface {
+	Write(car *carspb.Car) error
+	Close() error
+}
+
+/*
+NewWriter returns a Writer of format to w with columns, in that order.
+An unknown or repeated column is an error.
+*/
+func NewWriter(w io.Writer, format Format, columns []string) (Writer, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("exporter: no columns")
+	}
+	seen := make(map[string]bool, len(columns))
+	for _, c := range columns {
+		if _, ok := parquetTypes[c]; !ok {
+			return nil, fmt.Errorf("exporter: unknown column %q; columns are %s", c, strings.Join(Columns, ", "))
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("exporter: column %q given twice", c)
+		}
+		seen[c] = true
+	}
+
+	switch format {
+	case CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return nil, err
+		}
+		return &csvWriter{w: cw, columns: columns}, nil
+	case NDJSON:
+		return &ndjsonWriter{w: w, columns: columns}, nil
+	case Parquet:
+		md := make([]string, len(columns))
+		for i, c := range columns {
+			md[i] = "name=" + c + ", " + parquetTypes[c]
+		}
+		pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+		if err != nil {
+			return nil, err
+		}
+		pw.RowGroupSize = parquetRowGroupSize
+		return &parquetWriter{w: pw, columns: columns}, nil
+	}
+	return nil, fmt.Errorf("exporter: unknown format %d", format)
+}
+
+// value returns the column of car
+func value(car *carspb.Car, column string) interface{} {
+	switch column {
+	case "id":
+		return car.Id
+	case "make":
+		return car.Make
+	case "model":
+		return car.Model
+	case "acquisition_cost_cents":
+		return car.AcquisitionCostCents
+	case "margin_cents":
+		return car.MarginCents
+	case "vin":
+		return car.Vin
+	case "year":
+		return car.Year
+	case "color":
+		return car.Color
+	case "mileage":
+		return car.Mileage
+	}
+	return nil
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (cw *csvWriter) Write(car *carspb.Car) error {
+	record := make([]string, len(cw.columns))
+	for i, c := range cw.columns {
+		switch v := value(car, c).(type) {
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case int32:
+			record[i] = strconv.FormatInt(int64(v), 10)
+		case string:
+			record[i] = v
+		}
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type ndjsonWriter struct {
+	w       io.Writer
+	columns []string
+	buf     []byte
+}
+
+// Write builds the line itself, as encoding/json would sort a map's keys
+func (nw *ndjsonWriter) Write(car *carspb.Car) error {
+	nw.buf = append(nw.buf[:0], '{')
+	for i, c := range nw.columns {
+		if i > 0 {
+			nw.buf = append(nw.buf, ',')
+		}
+		nw.buf = strconv.AppendQuote(nw.buf, c)
+		nw.buf = append(nw.buf, ':')
+		v, err := json.Marshal(value(car, c))
+		if err != nil {
+			return err
+		}
+		nw.buf = append(nw.buf, v...)
+	}
+	nw.buf = append(nw.buf, '}', '\n')
+	_, err := nw.w.Write(nw.buf)
+	return err
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
+
+type parquetWriter struct {
+	w       *writer.CSVWriter
+	columns []string
+}
+
+func (pw *parquetWriter) Write(car *carspb.Car) error {
+	row := make([]interface{}, len(pw.columns))
+	for i, c := range pw.columns {
+		row[i] = value(car, c)
+	}
+	return pw.w.Write(row)
+}
+
+func (pw *parquetWriter) Close() error {
+	return pw.w.WriteStop()
+}
@@ -0,0 +1,102 @@
+package exporter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/importer"
+)
+
+var cars = []*carspb.Car{
+	{Id: 1, Make: "Ford", Model: "F10", AcquisitionCostCents: 1500000, MarginCents: 250000},
+	{Id: 2, Make: "Toyota", Model: `Camry "LE", 4dr`, AcquisitionCostCents: 2000000, MarginCents: 300000},
+}
+
+func export(t *testing.T, format Format, columns []string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, format, columns)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	for _, car := range cars {
+		if err := w.Write(car); err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSVAndNDJSON(t *testing.T) {
+	got := string(export(t, CSV, []string{"model", "id"}))
+	want := "model,id\nF10,1\n\"Camry \"\"LE\"\", 4dr\",2\n"
+	if got != want {
+		t.Errorf("Failed! CSV is %q, want %q", got, want)
+	}
+
+	got = string(export(t, NDJSON, []string{"id", "make", "margin_cents"}))
+	want = `{"id":1,"make":"Ford","margin_cents":250000}` + "\n" +
+		`{"id":2,"make":"Toyota","margin_cents":300000}` + "\n"
+	if got != want {
+		t.Errorf("Failed! NDJSON is %q, want %q", got, want)
+	}
+}
+
+func TestExportsImport(t *testing.T) {
+	for format, as := range map[Format]importer.Format{CSV: importer.CSV, NDJSON: importer.JSON} {
+		data := export(t, format, Columns)
+		if format == NDJSON {
+			// an import reads a JSON array
+			data = append([]byte("["), bytes.ReplaceAll(bytes.TrimSpace(data), []byte("\n"), []byte(","))...)
+			data = append(data, ']')
+		}
+		rows, err := importer.Parse(bytes.NewReader(data), as, 10)
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		if len(rows) != 2 || rows[1].Err != nil || rows[1].Car.Model != cars[1].Model || rows[1].Car.MarginCents != cars[1].MarginCents {
+			t.Errorf("Failed! format %d imports as %+v", format, rows)
+		}
+	}
+}
+
+func TestParquet(t *testing.T) {
+	data := export(t, Parquet, []string{"id", "make", "margin_cents"})
+
+	type row struct {
+		Id          int64  `parquet:"name=id, type=INT64"`
+		Make        string `parquet:"name=make, type=BYTE_ARRAY, convertedtype=UTF8"`
+		MarginCents int64  `parquet:"name=margin_cents, type=INT64"`
+	}
+	file, err := buffer.NewBufferFile(data)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	pr, err := reader.NewParquetReader(file, new(row), 1)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer pr.ReadStop()
+	rows := make([]row, pr.GetNumRows())
+	if err := pr.Read(&rows); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if len(rows) != 2 || rows[0] != (row{1, "Ford", 250000}) || rows[1].Make != "Toyota" {
+		t.Errorf("Failed! read back %+v", rows)
+	}
+}
+
+func TestColumnErrors(t *testing.T) {
+	for _, columns := range [][]string{nil, {"id", "trim"}, {"id", "id"}} {
+		if _, err := NewWriter(&bytes.Buffer{}, CSV, columns); err == nil {
+			t.Errorf("Failed! columns %q were accepted", columns)
+		}
+	}
+}
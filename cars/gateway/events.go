@@ -0,0 +1,150 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/rest"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+)
+
+// reconnectDelay is the retry interval suggested to EventSource clients
+const reconnectDelay = 3 * time.Second
+
+/*
+EventsHandler streams inventory changes from CarService.Watch as
+Server-Sent Events.  Each event carries its sequence number as the SSE
+id, so a reconnecting browser resumes after the last event it saw by
+sending Last-Event-ID; a last_event_id query parameter does the same for
+the first connection.
+
+A comment is sent every heartbeat to keep proxies from closing an idle
+stream.  The stream ends, and the Watch call with it, when the client
+disconnects or ctx is done.
+*/
+func EventsHandler(ctx context.Context, client carspb.CarServiceClient, heartbeat time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastID := r.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = r.URL.Query().Get("last_event_id")
+		}
+		var after uint64
+		if lastID != "" {
+			var err error
+			if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+				writeError(w, JSON, http.StatusBadRequest, "Last-Event-ID must be an event sequence number")
+				return
+			}
+		}
+
+		streamCtx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-ctx.Done():
+				cancel()
+			case <-streamCtx.Done():
+			}
+		}()
+
+		stream, err := watch(streamCtx, client, after)
+		if err != nil {
+			writeRPCError(streamCtx, nil, nil, w, r, err)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("content-type", "text/event-stream")
+		w.Header().Set("cache-control", "no-cache")
+		// stop nginx and similar proxies from buffering the stream
+		w.Header().Set("x-accel-buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay.Milliseconds())
+		if err := rc.Flush(); err != nil {
+			log.Printf("events: streaming is not supported: %v", err)
+			return
+		}
+
+		type received struct {
+			event *carspb.InventoryEvent
+			err   error
+		}
+		events := make(chan received)
+		go func() {
+			for {
+				e, err := stream.Recv()
+				select {
+				case events <- received{e, err}:
+				case <-streamCtx.Done():
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-streamCtx.Done():
+				return
+			case <-ticker.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			case got := <-events:
+				if got.err != nil {
+					if status.Code(got.err) != codes.Canceled {
+						log.Printf("events: watch ended: %v", got.err)
+					}
+					return
+				}
+				event := rest.NewEvent(got.event, visibility.LevelFromContext(streamCtx))
+				data, err := json.Marshal(event)
+				if err != nil {
+					log.Printf("events: encoding event: %v", err)
+					return
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Sequence, event.Type, data)
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+/*
+watch starts a Watch call and waits until the microservice has
+subscribed, so a refusal is returned here rather than in the middle of
+the stream
+*/
+func watch(ctx context.Context, client carspb.CarServiceClient, after uint64) (grpc.ServerStreamingClient[carspb.InventoryEvent], error) {
+	stream, err := client.Watch(ctx, &carspb.WatchRequest{AfterSequence: after})
+	if err != nil {
+		return nil, err
+	}
+	// the server sends headers once it is subscribed; a stream that ends
+	// without them was refused, and Recv returns why
+	md, err := stream.Header()
+	if err == nil && md == nil {
+		if _, err = stream.Recv(); err == io.EOF {
+			err = status.Error(codes.Unavailable, "watch ended before it started")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
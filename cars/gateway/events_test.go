@@ -0,0 +1,215 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+// watchClient answers Watch with the events after the requested sequence
+// number, then blocks until the call is cancelled
+type watchClient struct {
+	carspb.CarServiceClient
+	events []*carspb.InventoryEvent
+	err    error
+	after  chan uint64
+	done   chan struct{}
+}
+
+func newWatchClient(events ...*carspb.InventoryEvent) *watchClient {
+	return &watchClient{events: events, after: make(chan uint64, 1), done: make(chan struct{})}
+}
+
+func (c *watchClient) Watch(ctx context.Context, in *carspb.WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[carspb.InventoryEvent], error) {
+	if c.err != nil {
+		// like a real stream, refusals surface from Recv
+		return &watchStream{ctx: ctx, err: c.err}, nil
+	}
+	c.after <- in.GetAfterSequence()
+	var pending []*carspb.InventoryEvent
+	for _, e := range c.events {
+		if e.GetSequence() > in.GetAfterSequence() {
+			pending = append(pending, e)
+		}
+	}
+	return &watchStream{ctx: ctx, pending: pending, done: c.done}, nil
+}
+
+type watchStream struct {
+	grpc.ClientStream
+	ctx     context.Context
+	pending []*carspb.InventoryEvent
+	done    chan struct{}
+	err     error
+}
+
+func (s *watchStream) Header() (metadata.MD, error) {
+	if s.err != nil {
+		return nil, nil
+	}
+	return metadata.MD{}, nil
+}
+
+func (s *watchStream) Recv() (*carspb.InventoryEvent, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if len(s.pending) > 0 {
+		e := s.pending[0]
+		s.pending = s.pending[1:]
+		return e, nil
+	}
+	<-s.ctx.Done()
+	close(s.done)
+	return nil, status.FromContextError(s.ctx.Err()).Err()
+}
+
+func testEvent(sequence uint64, t carspb.EventType, id int64) *carspb.InventoryEvent {
+	return &carspb.InventoryEvent{
+		Sequence: sequence,
+		Type:     t,
+		Car:      &carspb.Car{Id: id, Make: "Toyota", Model: "Rav4", AcquisitionCostCents: 2480000, MarginCents: 280000},
+		Time:     timestamppb.New(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)),
+	}
+}
+
+// readEvents reads n messages from an event stream, comments included
+func readEvents(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+	var messages []string
+	var current strings.Builder
+	for len(messages) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed! reading stream: %v", err)
+		}
+		if line == "\n" {
+			messages = append(messages, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteString(line)
+	}
+	return messages
+}
+
+func TestEventsStream(t *testing.T) {
+	client := newWatchClient(
+		testEvent(1, carspb.EventType_EVENT_TYPE_CREATED, 7),
+		testEvent(2, carspb.EventType_EVENT_TYPE_UPDATED, 7),
+		testEvent(3, carspb.EventType_EVENT_TYPE_DELETED, 7),
+	)
+	srv := httptest.NewServer(EventsHandler(context.Background(), client, time.Hour))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Failed! got content type %q", got)
+	}
+	if got := <-client.after; got != 1 {
+		t.Errorf("Failed! watch started after %d, want 1", got)
+	}
+
+	got := readEvents(t, bufio.NewReader(resp.Body), 3)
+	want := []string{
+		"retry: 3000\n",
+		"id: 2\nevent: updated\n" + `data: {"sequence":"2","type":"updated","car":{"id":"7","make":"Toyota","model":"Rav4"},"time":"2024-05-01T12:00:00Z"}` + "\n",
+		"id: 3\nevent: deleted\n" + `data: {"sequence":"3","type":"deleted","car":{"id":"7","make":"Toyota","model":"Rav4"},"time":"2024-05-01T12:00:00Z"}` + "\n",
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Failed! message %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	// disconnecting ends the Watch call
+	cancel()
+	select {
+	case <-client.done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Failed! Watch was not cancelled when the client went away")
+	}
+}
+
+func TestEventsHeartbeat(t *testing.T) {
+	client := newWatchClient()
+	serverCtx, stop := context.WithCancel(context.Background())
+	srv := httptest.NewServer(EventsHandler(serverCtx, client, 10*time.Millisecond))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?last_event_id=5")
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer resp.Body.Close()
+	if got := <-client.after; got != 5 {
+		t.Errorf("Failed! watch started after %d, want 5", got)
+	}
+	got := readEvents(t, bufio.NewReader(resp.Body), 2)
+	if got[1] != ": heartbeat\n" {
+		t.Errorf("Failed! got %q, want a heartbeat", got[1])
+	}
+
+	// shutting the server down ends open streams
+	stop()
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, resp.Body)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Failed! stream stayed open after shutdown")
+	}
+}
+
+func TestEventsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		lastID   string
+		watchErr error
+		code     int
+	}{
+		{"bad last event id", "abc", nil, http.StatusBadRequest},
+		{"unauthenticated", "", status.Error(codes.Unauthenticated, "no token"), http.StatusUnauthorized},
+		{"forbidden", "", status.Error(codes.PermissionDenied, "viewer required"), http.StatusForbidden},
+		{"unavailable", "", status.Error(codes.Unavailable, "down"), http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		client := newWatchClient()
+		client.err = tt.watchErr
+		req := httptest.NewRequest("GET", "/cars/events", nil)
+		if tt.lastID != "" {
+			req.Header.Set("Last-Event-ID", tt.lastID)
+		}
+		rec := httptest.NewRecorder()
+		EventsHandler(context.Background(), client, time.Hour).ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("Failed! %s: got status %d, want %d", tt.name, rec.Code, tt.code)
+		}
+		if got := rec.Header().Get("Content-Type"); got != JSON.MediaType {
+			t.Errorf("Failed! %s: got content type %q", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+// exportFile is a format /cars:export can write
+type exportFile struct {
+	format    carspb.ExportFormat
+	mediaType string
+}
+
+var exportFiles = map[string]exportFile{
+	"csv":     {carspb.ExportFormat_EXPORT_FORMAT_CSV, "text/csv"},
+	"ndjson":  {carspb.ExportFormat_EXPORT_FORMAT_NDJSON, "application/x-ndjson"},
+	"parquet": {carspb.ExportFormat_EXPORT_FORMAT_PARQUET, "application/vnd.apache.parquet"},
+}
+
+/*
+ExportHandler downloads the inventory as a file written by
+CarService.ExportCars: ?format=csv (the default), ndjson or parquet, and
+?columns=id,make,... for the columns wanted, in order.
+
+The file is passed on a chunk at a time as the microservice writes it,
+so it is never held here.  Errors found before the first chunk are
+answered as usual; one in the middle of the file drops the connection,
+so the client sees the download fail rather than a short file.
+*/
+func ExportHandler(client carspb.CarServiceClient) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := strings.ToLower(query.Get("format"))
+		if name == "" {
+			name = "csv"
+		}
+		file, ok := exportFiles[name]
+		if !ok {
+			writeError(w, JSON, http.StatusBadRequest, "format must be csv, ndjson or parquet")
+			return
+		}
+		req := &carspb.ExportCarsRequest{Format: file.format}
+		for _, list := range query["columns"] {
+			for _, c := range strings.Split(list, ",") {
+				if c = strings.TrimSpace(c); c != "" {
+					req.Columns = append(req.Columns, c)
+				}
+			}
+		}
+
+		stream, err := client.ExportCars(r.Context(), req)
+		var chunk *carspb.ExportChunk
+		if err == nil {
+			chunk, err = stream.Recv()
+		}
+		if err != nil && err != io.EOF {
+			writeRPCError(r.Context(), nil, nil, w, r, err)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("content-type", file.mediaType)
+		w.Header().Set("content-disposition", `attachment; filename="cars.`+name+`"`)
+		w.WriteHeader(http.StatusOK)
+		for err == nil {
+			if _, err := w.Write(chunk.Data); err != nil {
+				return
+			}
+			rc.Flush()
+			chunk, err = stream.Recv()
+		}
+		if err != io.EOF {
+			log.Printf("export: stream ended: %v", err)
+			panic(http.ErrAbortHandler)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+// exportClient records the export it is asked for and streams chunks,
+// then err or the end of the file
+type exportClient struct {
+	carspb.CarServiceClient
+	got    *carspb.ExportCarsRequest
+	chunks []string
+	err    error
+}
+
+func (c *exportClient) ExportCars(ctx context.Context, in *carspb.ExportCarsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[carspb.ExportChunk], error) {
+	c.got = in
+	return &exportStream{chunks: c.chunks, err: c.err}, nil
+}
+
+type exportStream struct {
+	grpc.ClientStream
+	chunks []string
+	err    error
+}
+
+func (s *exportStream) Recv() (*carspb.ExportChunk, error) {
+	if len(s.chunks) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	chunk := &carspb.ExportChunk{Data: []byte(s.chunks[0])}
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func TestExportHandler(t *testing.T) {
+	client := &exportClient{chunks: []string{`{"id":1,"make":"Ford"}` + "\n", `{"id":2,"make":"Toyota"}` + "\n"}}
+	h := ExportHandler(client)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/cars:export?format=ndjson&columns=id,make", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Failed! export answered %d: %s", rec.Code, rec.Body)
+	}
+	if client.got.Format != carspb.ExportFormat_EXPORT_FORMAT_NDJSON || len(client.got.Columns) != 2 || client.got.Columns[1] != "make" {
+		t.Errorf("Failed! unexpected ExportCars request %v", client.got)
+	}
+	if rec.Header().Get("content-type") != "application/x-ndjson" || rec.Header().Get("content-disposition") != `attachment; filename="cars.ndjson"` {
+		t.Errorf("Failed! unexpected headers %v", rec.Header())
+	}
+	if rec.Body.String() != client.chunks[0]+client.chunks[1] {
+		t.Errorf("Failed! unexpected file %q", rec.Body)
+	}
+
+	// csv is the default
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/cars:export", nil))
+	if client.got.Format != carspb.ExportFormat_EXPORT_FORMAT_CSV || len(client.got.Columns) != 0 {
+		t.Errorf("Failed! unexpected ExportCars request %v", client.got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/cars:export?format=xlsx", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Failed! unknown format answered %d", rec.Code)
+	}
+
+	client = &exportClient{err: status.Error(codes.PermissionDenied, `column "margin_cents" is not visible to the caller`)}
+	rec = httptest.NewRecorder()
+	ExportHandler(client).ServeHTTP(rec, httptest.NewRequest("GET", "/cars:export?columns=margin_cents", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Failed! refused export answered %d", rec.Code)
+	}
+}
+
+func TestExportHandlerAbortsMidFile(t *testing.T) {
+	client := &exportClient{chunks: []string{"id,make\n"}, err: status.Error(codes.Unavailable, "server is shutting down")}
+	rec := httptest.NewRecorder()
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("Failed! expected the handler to abort, got %v", p)
+		}
+		if rec.Body.String() != "id,make\n" {
+			t.Errorf("Failed! unexpected partial file %q", rec.Body)
+		}
+	}()
+	ExportHandler(client).ServeHTTP(rec, httptest.NewRequest("GET", "/cars:export", nil))
+}
@@ -0,0 +1,317 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/simrie/go-grpc-car-service/cars/rest"
+)
+
+/*
+Format is a representation the car routes can respond with
+*/
+type Format struct {
+	// Name is the value of the ?format= query parameter choosing it
+	Name string
+	// MediaType is the Content-Type of responses in the format
+	MediaType string
+	// aliases are other media types accepted for the format
+	aliases []string
+}
+
+// Formats in order of preference when a client accepts several equally
+var (
+	JSON     = Format{Name: "json", MediaType: "application/json"}
+	CSV      = Format{Name: "csv", MediaType: "text/csv"}
+	XML      = Format{Name: "xml", MediaType: "application/xml", aliases: []string{"text/xml"}}
+	Protobuf = Format{Name: "protobuf", MediaType: "application/x-protobuf", aliases: []string{"application/protobuf", "application/vnd.google.protobuf"}}
+
+	formats = []Format{JSON, CSV, XML, Protobuf}
+)
+
+// ErrNotAcceptable is returned by Negotiate when no supported format is acceptable
+var ErrNotAcceptable = errors.New("none of the supported formats is acceptable")
+
+/*
+Negotiate picks the response format for r.  A ?format= parameter wins
+over the Accept header; without either the response is JSON.  Accept
+quality values are honoured, and wildcards choose the most preferred
+matching format.
+*/
+func Negotiate(r *http.Request) (Format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, f := range formats {
+			if f.Name == name {
+				return f, nil
+			}
+		}
+		return Format{}, fmt.Errorf("%w: unknown format %q", ErrNotAcceptable, name)
+	}
+
+	accept := strings.Join(r.Header.Values("Accept"), ",")
+	if strings.TrimSpace(accept) == "" {
+		return JSON, nil
+	}
+	type candidate struct {
+		format Format
+		q      float64
+		order  int
+	}
+	best := map[string]candidate{}
+	for i, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		for pref, f := range formats {
+			if !f.matches(mediaType) {
+				continue
+			}
+			// The most specific range naming a format sets its quality
+			c, seen := best[f.Name]
+			if !seen || specificity(mediaType) > specificity(c.format.MediaType) {
+				best[f.Name] = candidate{format: Format{Name: f.Name, MediaType: mediaType}, q: q, order: i*len(formats) + pref}
+			}
+		}
+	}
+	var acceptable []candidate
+	for _, f := range formats {
+		if c, ok := best[f.Name]; ok && c.q > 0 {
+			c.format = f
+			acceptable = append(acceptable, c)
+		}
+	}
+	if len(acceptable) == 0 {
+		return Format{}, fmt.Errorf("%w: %s", ErrNotAcceptable, accept)
+	}
+	sort.SliceStable(acceptable, func(i, j int) bool {
+		if acceptable[i].q != acceptable[j].q {
+			return acceptable[i].q > acceptable[j].q
+		}
+		return acceptable[i].order < acceptable[j].order
+	})
+	return acceptable[0].format, nil
+}
+
+// matches reports whether mediaType, which may be a range such as text/*,
+// includes the format
+func (f Format) matches(mediaType string) bool {
+	if mediaType == "*/*" {
+		return true
+	}
+	for _, t := range append([]string{f.MediaType}, f.aliases...) {
+		if t == mediaType {
+			return true
+		}
+		if major, _, _ := strings.Cut(t, "/"); mediaType == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+func specificity(mediaType string) int {
+	switch {
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		return 1
+	}
+	return 2
+}
+
+/*
+Supported lists the media types and format names the car routes offer,
+for error messages
+*/
+func Supported() string {
+	var parts []string
+	for _, f := range formats {
+		parts = append(parts, fmt.Sprintf("%s (format=%s)", f.MediaType, f.Name))
+	}
+	return strings.Join(parts, ", ")
+}
+
+type formatKey struct{}
+
+func withFormat(ctx context.Context, f Format) context.Context {
+	return context.WithValue(ctx, formatKey{}, f)
+}
+
+func formatFrom(ctx context.Context) Format {
+	if f, ok := ctx.Value(formatKey{}).(Format); ok {
+		return f
+	}
+	return JSON
+}
+
+// encodeOnly provides the parts of runtime.Marshaler that read request
+// bodies, which the response-only formats do not support
+type encodeOnly struct {
+	name string
+}
+
+func (m encodeOnly) Unmarshal(data []byte, v interface{}) error {
+	return fmt.Errorf("request bodies cannot be %s", m.name)
+}
+
+func (m encodeOnly) NewDecoder(r io.Reader) runtime.Decoder {
+	return runtime.DecoderFunc(func(v interface{}) error { return m.Unmarshal(nil, v) })
+}
+
+// csvMarshaler writes cars as CSV with a header row.  The cost columns
+// are always present, left empty when the caller may not see them; the
+// vehicle detail columns are only present when some car has details, and
+// left empty for those it does not have.
+type csvMarshaler struct {
+	encodeOnly
+}
+
+var (
+	csvHeader        = []string{"id", "make", "model", "acquisition_cost_cents", "margin_cents"}
+	csvDetailsHeader = []string{"vin", "year", "color", "mileage"}
+)
+
+func (csvMarshaler) ContentType(interface{}) string {
+	return CSV.MediaType
+}
+
+func (m csvMarshaler) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	switch v := v.(type) {
+	case rest.Car:
+		details := hasDetails(v)
+		w.Write(csvColumns(details))
+		w.Write(csvRecord(v, details))
+	case rest.CarList:
+		details := false
+		for _, car := range v.Cars {
+			details = details || hasDetails(car)
+		}
+		w.Write(csvColumns(details))
+		for _, car := range v.Cars {
+			w.Write(csvRecord(car, details))
+		}
+	case rest.Error:
+		w.Write([]string{"message"})
+		w.Write([]string{v.Message})
+	default:
+		return nil, fmt.Errorf("%T cannot be written as CSV", v)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func (m csvMarshaler) NewEncoder(w io.Writer) runtime.Encoder {
+	return runtime.EncoderFunc(func(v interface{}) error {
+		b, err := m.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	})
+}
+
+// hasDetails reports whether car has any vehicle details
+func hasDetails(car rest.Car) bool {
+	return car.Vin != "" || car.Year != 0 || car.Color != "" || car.Mileage != 0
+}
+
+func csvColumns(details bool) []string {
+	if !details {
+		return csvHeader
+	}
+	return append(append([]string(nil), csvHeader...), csvDetailsHeader...)
+}
+
+func csvRecord(car rest.Car, details bool) []string {
+	optional := func(v *int64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatInt(*v, 10)
+	}
+	// details the car does not have are empty, not 0
+	nonZero := func(v int64) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.FormatInt(v, 10)
+	}
+	record := []string{
+		strconv.FormatInt(car.Id, 10),
+		car.Make,
+		car.Model,
+		optional(car.AcquisitionCostCents),
+		optional(car.MarginCents),
+	}
+	if details {
+		record = append(record, car.Vin, nonZero(int64(car.Year)), car.Color, nonZero(car.Mileage))
+	}
+	return record
+}
+
+// xmlMarshaler writes the rest resources as XML
+type xmlMarshaler struct {
+	encodeOnly
+}
+
+func (xmlMarshaler) ContentType(interface{}) string {
+	return XML.MediaType
+}
+
+func (xmlMarshaler) Marshal(v interface{}) ([]byte, error) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+func (m xmlMarshaler) NewEncoder(w io.Writer) runtime.Encoder {
+	return runtime.EncoderFunc(func(v interface{}) error {
+		b, err := m.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	})
+}
+
+// protoMarshaler writes response messages in the protobuf binary format
+type protoMarshaler struct {
+	*runtime.ProtoMarshaller
+}
+
+func (protoMarshaler) ContentType(interface{}) string {
+	return Protobuf.MediaType
+}
+
+func (m protoMarshaler) Marshal(v interface{}) ([]byte, error) {
+	if _, ok := v.(proto.Message); !ok {
+		return nil, fmt.Errorf("%T cannot be written as protobuf", v)
+	}
+	return m.ProtoMarshaller.Marshal(v)
+}
@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/simrie/go-grpc-car-service/cars/rest"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		query  string
+		want   string
+	}{
+		{"", "", "json"},
+		{"*/*", "", "json"},
+		{"application/json", "", "json"},
+		{"text/csv", "", "csv"},
+		{"text/*", "", "csv"},
+		{"text/xml", "", "xml"},
+		{"application/xml;q=0.9, text/csv;q=0.5", "", "xml"},
+		{"text/csv;q=0.5, application/xml;q=0.9", "", "xml"},
+		{"application/x-protobuf", "", "protobuf"},
+		{"application/protobuf", "", "protobuf"},
+		{"text/html, */*;q=0.1", "", "json"},
+		{"application/json;q=0, */*", "", "csv"},
+		{"text/csv", "format=xml", "xml"},
+		{"image/png", "format=json", "json"},
+		{"image/png", "", ""},
+		{"application/json;q=0", "", ""},
+		{"", "format=yaml", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/cars?"+tt.query, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		got, err := Negotiate(r)
+		if tt.want == "" {
+			if !errors.Is(err, ErrNotAcceptable) {
+				t.Errorf("Failed! Accept %q ?%s: expected ErrNotAcceptable, got %v, %v", tt.accept, tt.query, got.Name, err)
+			}
+			continue
+		}
+		if err != nil || got.Name != tt.want {
+			t.Errorf("Failed! Accept %q ?%s: got %q, %v, want %q", tt.accept, tt.query, got.Name, err, tt.want)
+		}
+	}
+}
+
+func TestCSVVehicleDetails(t *testing.T) {
+	// details columns only appear when some car has details, and are
+	// empty for the cars without
+	list := rest.CarList{Cars: []rest.Car{
+		{Id: 1, Make: "Ford", Model: "F-150", Vin: "1FTEW1EP5JFA12345", Year: 2018, Mileage: 64000},
+		{Id: 2, Make: "Toyota", Model: "Camry"},
+	}}
+	want := "id,make,model,acquisition_cost_cents,margin_cents,vin,year,color,mileage\n" +
+		"1,Ford,F-150,,,1FTEW1EP5JFA12345,2018,,64000\n" +
+		"2,Toyota,Camry,,,,,,\n"
+	if got, err := (csvMarshaler{}).Marshal(list); err != nil || string(got) != want {
+		t.Errorf("Failed! got %q, %v, want %q", got, err, want)
+	}
+
+	want = "id,make,model,acquisition_cost_cents,margin_cents\n2,Toyota,Camry,,\n"
+	if got, err := (csvMarshaler{}).Marshal(list.Cars[1]); err != nil || string(got) != want {
+		t.Errorf("Failed! got %q, %v, want %q", got, err, want)
+	}
+}
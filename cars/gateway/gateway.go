@@ -0,0 +1,264 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/rest"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+)
+
+/*
+Route is one REST route generated from a google.api.http annotation
+in cars.proto
+*/
+type Route struct {
+	Method string
+	// Path is a gorilla/mux path template
+	Path string
+	// RPC is the full name of the gRPC method the route calls
+	RPC string
+}
+
+/*
+Routes returns the REST routes annotated on CarService, in the order
+the methods are declared
+*/
+func Routes() ([]Route, error) {
+	service := carspb.File_cars_carspb_cars_proto.Services().ByName("CarService")
+	var routes []Route
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		rule, ok := proto.GetExtension(method.Options(), annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			continue
+		}
+		for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+			route, err := routeFor(method, r)
+			if err != nil {
+				return nil, err
+			}
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
+
+func routeFor(method protoreflect.MethodDescriptor, rule *annotations.HttpRule) (Route, error) {
+	route := Route{RPC: string(method.FullName())}
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		route.Method, route.Path = http.MethodGet, pattern.Get
+	case *annotations.HttpRule_Put:
+		route.Method, route.Path = http.MethodPut, pattern.Put
+	case *annotations.HttpRule_Post:
+		route.Method, route.Path = http.MethodPost, pattern.Post
+	case *annotations.HttpRule_Delete:
+		route.Method, route.Path = http.MethodDelete, pattern.Delete
+	case *annotations.HttpRule_Patch:
+		route.Method, route.Path = http.MethodPatch, pattern.Patch
+	case *annotations.HttpRule_Custom:
+		route.Method, route.Path = pattern.Custom.GetKind(), pattern.Custom.GetPath()
+	default:
+		return Route{}, fmt.Errorf("gateway: %s has an http annotation without a pattern", route.RPC)
+	}
+	path, err := muxTemplate(route.Path)
+	if err != nil {
+		return Route{}, fmt.Errorf("gateway: %s: %v", route.RPC, err)
+	}
+	route.Path = path
+	return route, nil
+}
+
+/*
+muxTemplate converts an http annotation path template to a gorilla/mux
+one.  Only whole-segment variables are supported; {name=*} is the same
+as {name}.
+*/
+func muxTemplate(template string) (string, error) {
+	var b strings.Builder
+	for {
+		open := strings.IndexByte(template, '{')
+		if open < 0 {
+			b.WriteString(template)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(template[open:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable in %q", template)
+		}
+		variable := template[open+1 : open+end]
+		if name, pattern, ok := strings.Cut(variable, "="); ok {
+			if pattern != "*" {
+				return "", fmt.Errorf("unsupported variable pattern %q", variable)
+			}
+			variable = name
+		}
+		b.WriteString(template[:open])
+		b.WriteString("{" + variable + "}")
+		template = template[open+end+1:]
+	}
+}
+
+/*
+NewHandler returns a handler that transcodes the annotated REST routes
+into CarService calls on client.  Each call gets timeout as its deadline.
+
+Responses are encoded as the resources in the rest package, filtered by
+the caller's role, and errors as rest.Error.  The format is negotiated
+from the Accept header or ?format= parameter: JSON, CSV, XML or binary
+protobuf, which carries the filtered response message itself.  Requests
+accepting none of them get a 406.
+
+The caller's identity is forwarded by the client's interceptors; request
+headers are not passed on as metadata, so callers cannot forge it.
+*/
+func NewHandler(client carspb.CarServiceClient, timeout time.Duration) (http.Handler, error) {
+	jsonMarshaler := lineMarshaler{&runtime.JSONPb{
+		MarshalOptions:   protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true},
+		UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}}
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, jsonMarshaler),
+		runtime.WithMarshalerOption(JSON.MediaType, jsonMarshaler),
+		runtime.WithMarshalerOption(CSV.MediaType, csvMarshaler{encodeOnly{"CSV"}}),
+		runtime.WithMarshalerOption(XML.MediaType, xmlMarshaler{encodeOnly{"XML"}}),
+		runtime.WithMarshalerOption(Protobuf.MediaType, protoMarshaler{&runtime.ProtoMarshaller{}}),
+		runtime.WithIncomingHeaderMatcher(func(string) (string, bool) { return "", false }),
+		runtime.WithOutgoingHeaderMatcher(func(string) (string, bool) { return "", false }),
+		runtime.WithForwardResponseRewriter(toResource),
+		runtime.WithErrorHandler(writeRPCError),
+	)
+	if err := carspb.RegisterCarServiceHandlerClient(context.Background(), mux, client); err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		format, err := Negotiate(r)
+		if err != nil {
+			writeError(w, JSON, http.StatusNotAcceptable, "supported formats are "+Supported())
+			return
+		}
+		ctx, cancel := context.WithTimeout(withFormat(r.Context(), format), timeout)
+		defer cancel()
+		// grpc-gateway picks the marshaler registered for the Accept value
+		r = r.WithContext(ctx)
+		r.Header = r.Header.Clone()
+		r.Header.Set("Accept", format.MediaType)
+		mux.ServeHTTP(w, r)
+	}), nil
+}
+
+/*
+toResource replaces CarService responses with their REST resources, or
+for protobuf with a copy filtered by the caller's role
+*/
+func toResource(ctx context.Context, response proto.Message) (any, error) {
+	level := visibility.LevelFromContext(ctx)
+	asProto := formatFrom(ctx).Name == Protobuf.Name
+	switch response := response.(type) {
+	case *carspb.CarResponse:
+		if asProto {
+			return &carspb.CarResponse{Result: visibility.Car(level, response.GetResult())}, nil
+		}
+		return rest.NewCar(response.GetResult(), level), nil
+	case *carspb.CarWithDeadlineResponse:
+		if asProto {
+			return &carspb.CarWithDeadlineResponse{Result: visibility.Cars(level, response.GetResult())}, nil
+		}
+		return rest.NewCarList(response.GetResult(), level), nil
+	}
+	return response, nil
+}
+
+/*
+writeRPCError answers with the HTTP status and message matching a failed
+call.  Failures the caller cannot act on are reported as a bad request,
+as they always have been.
+*/
+func writeRPCError(ctx context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	st := status.Convert(err)
+	code, msg := http.StatusBadRequest, "error retrieving Cars information"
+	switch st.Code() {
+	case codes.Unavailable:
+		w.Header().Set("Retry-After", "1")
+		code, msg = http.StatusServiceUnavailable, "car microservice is unavailable, try again shortly"
+	case codes.Unauthenticated:
+		code, msg = http.StatusUnauthorized, "a valid bearer token is required"
+	case codes.PermissionDenied:
+		code, msg = http.StatusForbidden, "your role does not allow this request"
+	case codes.DeadlineExceeded:
+		msg = "Timeout was hit.  Deadline exceeded."
+	case codes.InvalidArgument:
+		msg = st.Message()
+		// path and query parameters that do not fit the request message,
+		// worded as the hand-written routes always did for integers
+		if param, ok := strings.CutPrefix(msg, "type mismatch, parameter: "); ok {
+			name, detail, _ := strings.Cut(param, ",")
+			msg = name + " parameter is not valid"
+			if strings.Contains(detail, "strconv.ParseInt") || strings.Contains(detail, "strconv.ParseUint") {
+				msg = name + " parameter is not an integer"
+			}
+		}
+	case codes.Aborted:
+		code, msg = http.StatusConflict, st.Message()
+	case codes.Unimplemented:
+		code, msg = http.StatusNotImplemented, "this route is not implemented"
+	}
+	log.Printf("error while calling %s: %v", r.URL.Path, err)
+	writeError(w, formatFrom(ctx), code, msg)
+}
+
+/*
+writeError answers with code and a rest.Error carrying msg, as CSV or XML
+when that is the format asked for, and otherwise as JSON
+*/
+func writeError(w http.ResponseWriter, format Format, code int, msg string) {
+	var body []byte
+	var err error
+	switch format.Name {
+	case CSV.Name:
+		body, err = csvMarshaler{}.Marshal(rest.Error{Message: msg})
+	case XML.Name:
+		body, err = xmlMarshaler{}.Marshal(rest.Error{Message: msg})
+	default:
+		format = JSON
+		body, err = json.Marshal(rest.Error{Message: msg})
+		body = append(body, '\n')
+	}
+	if err != nil {
+		log.Printf("encoding error response: %v", err)
+	}
+	w.Header().Set("content-type", format.MediaType)
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// lineMarshaler ends every body with a newline, as json.Encoder does, so
+// transcoded responses match the ones written by hand
+type lineMarshaler struct {
+	*runtime.JSONPb
+}
+
+func (m lineMarshaler) Marshal(v interface{}) ([]byte, error) {
+	b, err := m.JSONPb.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
@@ -0,0 +1,80 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+func TestRoutes(t *testing.T) {
+	routes, err := Routes()
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	want := []Route{
+		{Method: "GET", Path: "/car/{id}", RPC: "cars.CarService.Car"},
+		{Method: "GET", Path: "/cars", RPC: "cars.CarService.CarWithDeadline"},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("Failed! got %v, want %v", routes, want)
+	}
+}
+
+func TestMuxTemplate(t *testing.T) {
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{"/cars", "/cars", false},
+		{"/car/{id}", "/car/{id}", false},
+		{"/car/{id=*}/owner", "/car/{id}/owner", false},
+		{"/car/{id=cars/*}", "", true},
+		{"/car/{id", "", true},
+	}
+	for _, tt := range tests {
+		got, err := muxTemplate(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Failed! %s: got %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+// metadataClient records the outgoing metadata of each call
+type metadataClient struct {
+	carspb.CarServiceClient
+	md metadata.MD
+}
+
+func (c *metadataClient) CarWithDeadline(ctx context.Context, in *carspb.CarWithDeadlineRequest, opts ...grpc.CallOption) (*carspb.CarWithDeadlineResponse, error) {
+	c.md, _ = metadata.FromOutgoingContext(ctx)
+	return &carspb.CarWithDeadlineResponse{}, nil
+}
+
+func TestHeadersCannotForgeIdentity(t *testing.T) {
+	client := &metadataClient{}
+	handler, err := NewHandler(client, time.Second)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	req := httptest.NewRequest("GET", "/cars", nil)
+	req.Header.Set("Grpc-Metadata-X-Cars-Subject", "mallory")
+	req.Header.Set("Grpc-Metadata-X-Cars-Roles", "admin")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Failed! got status %d", rec.Code)
+	}
+	for _, key := range []string{"x-cars-subject", "x-cars-roles"} {
+		if v := client.md.Get(key); len(v) > 0 {
+			t.Errorf("Failed! request header was forwarded as %s: %v", key, v)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/rest"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+)
+
+// maxImportBytes is the largest file ImportHandler accepts, leaving room
+// under the microservice's message size limit
+const maxImportBytes = 3 << 20
+
+/*
+ImportHandler imports the CSV or JSON file in the request body with
+CarService.ImportCars, the format chosen by the Content-Type, and
+answers with a rest.ImportReport.  ?dry_run=true reports what the import
+would change without changing anything.
+*/
+func ImportHandler(client carspb.CarServiceClient, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &carspb.ImportCarsRequest{}
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("content-type"))
+		switch mediaType {
+		case CSV.MediaType:
+			req.Format = carspb.ImportFormat_IMPORT_FORMAT_CSV
+		case JSON.MediaType:
+			req.Format = carspb.ImportFormat_IMPORT_FORMAT_JSON
+		default:
+			writeError(w, JSON, http.StatusUnsupportedMediaType, "the file must be sent as text/csv or application/json")
+			return
+		}
+		if s := r.URL.Query().Get("dry_run"); s != "" {
+			var err error
+			if req.DryRun, err = strconv.ParseBool(s); err != nil {
+				writeError(w, JSON, http.StatusBadRequest, "dry_run parameter is not valid")
+				return
+			}
+		}
+		var err error
+		req.Data, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, JSON, http.StatusRequestEntityTooLarge, "the file must be at most "+strconv.Itoa(maxImportBytes>>20)+" MiB")
+			return
+		}
+		if err != nil {
+			writeError(w, JSON, http.StatusBadRequest, "the file could not be read")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		res, err := client.ImportCars(ctx, req)
+		if err != nil {
+			writeRPCError(ctx, nil, nil, w, r, err)
+			return
+		}
+		w.Header().Set("content-type", JSON.MediaType)
+		json.NewEncoder(w).Encode(rest.NewImportReport(res, visibility.LevelFromContext(r.Context())))
+	})
+}
@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/rest"
+)
+
+// importClient records the import it is asked for and answers with res
+// or err
+type importClient struct {
+	carspb.CarServiceClient
+	got *carspb.ImportCarsRequest
+	res *carspb.ImportCarsResponse
+	err error
+}
+
+func (c *importClient) ImportCars(ctx context.Context, in *carspb.ImportCarsRequest, opts ...grpc.CallOption) (*carspb.ImportCarsResponse, error) {
+	c.got = in
+	return c.res, c.err
+}
+
+func TestImportHandler(t *testing.T) {
+	client := &importClient{res: &carspb.ImportCarsResponse{
+		DryRun:  true,
+		Created: 1,
+		Invalid: 1,
+		Rows: []*carspb.ImportRowResult{
+			{Row: 1, Action: carspb.ImportAction_IMPORT_ACTION_CREATE, Car: &carspb.Car{Make: "Kia", Model: "Sole", AcquisitionCostCents: 1500000},
+				Warnings: []string{`unknown Kia model "Sole", did you mean "Soul"?`}},
+			{Row: 2, Action: carspb.ImportAction_IMPORT_ACTION_INVALID, Error: "make and model are required"},
+		},
+	}}
+	h := ImportHandler(client, time.Second)
+
+	req := httptest.NewRequest("POST", "/cars:import?dry_run=true", strings.NewReader("make,model\nKia,Soul\n,\n"))
+	req.Header.Set("content-type", "text/csv; charset=utf-8")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Failed! import answered %d: %s", rec.Code, rec.Body)
+	}
+	if client.got.Format != carspb.ImportFormat_IMPORT_FORMAT_CSV || !client.got.DryRun || !strings.HasPrefix(string(client.got.Data), "make,model") {
+		t.Errorf("Failed! unexpected ImportCars request %v", client.got)
+	}
+	var report rest.ImportReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if !report.DryRun || len(report.Rows) != 2 || report.Rows[0].Action != "create" || report.Rows[1].Error == "" ||
+		len(report.Rows[0].Warnings) != 1 || report.Rows[1].Warnings != nil {
+		t.Errorf("Failed! unexpected report %s", rec.Body)
+	}
+	if report.Rows[0].Car.AcquisitionCostCents != nil {
+		t.Errorf("Failed! anonymous caller was sent cost data: %s", rec.Body)
+	}
+
+	client.err = status.Error(codes.Aborted, "the inventory changed during the import; try again")
+	tests := []struct {
+		contentType string
+		query       string
+		body        string
+		want        int
+	}{
+		{"application/json", "", "[]", http.StatusConflict},
+		{"application/xml", "", "<cars/>", http.StatusUnsupportedMediaType},
+		{"text/csv", "?dry_run=maybe", "make,model\n", http.StatusBadRequest},
+		{"text/csv", "", strings.Repeat("x", maxImportBytes+1), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/cars:import"+tt.query, strings.NewReader(tt.body))
+		req.Header.Set("content-type", tt.contentType)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("Failed! %s%s answered %d, want %d", tt.contentType, tt.query, rec.Code, tt.want)
+		}
+	}
+}
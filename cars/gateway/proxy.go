@@ -0,0 +1,167 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+)
+
+/*
+Multiplex serves gRPC calls, HTTP/2 requests with an application/grpc
+content type, with grpcHandler and every other request with handler, so
+gRPC and the REST API can share one listener
+*/
+func Multiplex(grpcHandler, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("content-type"), "application/grpc") {
+			grpcHandler.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+/*
+NewProxy returns a gRPC server that passes every call it receives on to
+conn unchanged, streams included, so gRPC clients can reach the
+microservice through the REST service.  The proxy does not know the
+methods it forwards; messages are copied as they are.
+
+Callers cannot claim an identity in their metadata; the identity in the
+call's context, set by HTTP middleware in front of the proxy, is
+forwarded instead.  Calls still running when stop is closed end with
+Unavailable.  The proxy only serves through ServeHTTP and cannot be
+drained, so it should be stopped once its listener has shut down.
+*/
+func NewProxy(conn grpc.ClientConnInterface, stop <-chan struct{}) *grpc.Server {
+	p := &proxy{conn: conn, stop: stop}
+	return grpc.NewServer(
+		grpc.UnknownServiceHandler(p.handle),
+		grpc.ForceServerCodec(frameCodec{}),
+	)
+}
+
+type proxy struct {
+	conn grpc.ClientConnInterface
+	stop <-chan struct{}
+}
+
+// proxyDesc lets any call, whatever its kind, be forwarded as a stream
+var proxyDesc = &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+
+func (p *proxy) handle(_ interface{}, in grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(in)
+	if !ok {
+		return status.Error(codes.Internal, "method name is missing")
+	}
+	ctx, cancel := context.WithCancel(auth.ForwardIncoming(in.Context()))
+	defer cancel()
+	out, err := p.conn.NewStream(ctx, proxyDesc, method, grpc.ForceCodec(frameCodec{}))
+	if err != nil {
+		return err
+	}
+
+	// requests go on to the microservice while its replies come back;
+	// a failure reading the caller's requests cancels the call upstream
+	failed := make(chan error, 1)
+	go func() {
+		if err := forwardRequests(in, out); err != nil {
+			failed <- err
+			cancel()
+		}
+	}()
+
+	go func() {
+		select {
+		case <-p.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	headerSent := false
+	for {
+		var f frame
+		if err := out.RecvMsg(&f); err != nil {
+			select {
+			case err := <-failed:
+				return err
+			case <-p.stop:
+				return status.Error(codes.Unavailable, "REST service is shutting down")
+			default:
+			}
+			if !headerSent {
+				if md, err := out.Header(); err == nil {
+					in.SetHeader(md)
+				}
+			}
+			in.SetTrailer(out.Trailer())
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if !headerSent {
+			md, err := out.Header()
+			if err != nil {
+				return err
+			}
+			if err := in.SendHeader(md); err != nil {
+				return err
+			}
+			headerSent = true
+		}
+		if err := in.SendMsg(&f); err != nil {
+			return err
+		}
+	}
+}
+
+// forwardRequests copies the caller's messages to out until the caller
+// half-closes, which is passed on too
+func forwardRequests(in grpc.ServerStream, out grpc.ClientStream) error {
+	for {
+		var f frame
+		if err := in.RecvMsg(&f); err != nil {
+			if err == io.EOF {
+				return out.CloseSend()
+			}
+			return err
+		}
+		if err := out.SendMsg(&f); err != nil {
+			// the microservice ended the call; its status is read by
+			// the other direction
+			return nil
+		}
+	}
+}
+
+// frame is one encoded message, passed through without decoding
+type frame struct {
+	payload []byte
+}
+
+// frameCodec copies frames as they are.  It is named proto so calls are
+// sent with the content type the microservice expects.
+type frameCodec struct{}
+
+func (frameCodec) Marshal(v interface{}) ([]byte, error) {
+	return v.(*frame).payload, nil
+}
+
+func (frameCodec) Unmarshal(data []byte, v interface{}) error {
+	f := v.(*frame)
+	f.payload = append(f.payload[:0], data...)
+	return nil
+}
+
+func (frameCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+// backend is the microservice behind the proxy.  Car answers with the
+// identity the call arrived with as the car's make.
+type backend struct {
+	carspb.UnimplementedCarServiceServer
+}
+
+func (backend) Car(ctx context.Context, req *carspb.CarRequest) (*carspb.CarResponse, error) {
+	if req.Id != 1 {
+		return nil, status.Error(codes.NotFound, "car not found")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	return &carspb.CarResponse{Result: &carspb.Car{
+		Id:    1,
+		Make:  strings.Join(md.Get("x-cars-subject"), ","),
+		Model: strings.Join(md.Get("x-cars-roles"), ","),
+	}}, nil
+}
+
+func (backend) Watch(req *carspb.WatchRequest, stream carspb.CarService_WatchServer) error {
+	if req.AfterSequence == 0 {
+		// a quiet inventory
+		<-stream.Context().Done()
+		return nil
+	}
+	for seq := req.AfterSequence + 1; seq <= req.AfterSequence+2; seq++ {
+		if err := stream.Send(&carspb.InventoryEvent{Sequence: seq}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startProxy serves a proxy to backend, with REST requests answered by
+// rest, and returns a client calling through it.  Calls are made as
+// caller when it is set.
+func startProxy(t *testing.T, caller *auth.Identity, rest http.Handler, stop <-chan struct{}) (carspb.CarServiceClient, string) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	carspb.RegisterCarServiceServer(s, backend{})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	upstream, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(auth.UnaryClientInterceptor),
+		grpc.WithStreamInterceptor(auth.StreamClientInterceptor))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	t.Cleanup(func() { upstream.Close() })
+
+	p := NewProxy(upstream, stop)
+	t.Cleanup(p.Stop)
+	var grpcHandler http.Handler = p
+	if caller != nil {
+		// stands in for the authentication middleware
+		grpcHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), *caller)))
+		})
+	}
+	srv := httptest.NewServer(h2c.NewHandler(Multiplex(grpcHandler, rest), &http2.Server{}))
+	t.Cleanup(srv.Close)
+
+	conn, err := grpc.NewClient(strings.TrimPrefix(srv.URL, "http://"),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return carspb.NewCarServiceClient(conn), srv.URL
+}
+
+func TestProxyUnary(t *testing.T) {
+	client, _ := startProxy(t, &auth.Identity{Subject: "alice", Roles: []auth.Role{auth.Sales}}, http.NotFoundHandler(), nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// an identity claimed in metadata is replaced by the authenticated one
+	forged := metadata.AppendToOutgoingContext(ctx, "x-cars-subject", "mallory", "x-cars-roles", "admin")
+	res, err := client.Car(forged, &carspb.CarRequest{Id: 1})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if res.Result.Make != "alice" || res.Result.Model != "sales" {
+		t.Errorf("Failed! backend saw %s with roles %q, want alice with sales", res.Result.Make, res.Result.Model)
+	}
+
+	_, err = client.Car(ctx, &carspb.CarRequest{Id: 9})
+	if st := status.Convert(err); st.Code() != codes.NotFound || st.Message() != "car not found" {
+		t.Errorf("Failed! expected NotFound passed through, got %v", err)
+	}
+}
+
+func TestProxyAnonymous(t *testing.T) {
+	client, _ := startProxy(t, nil, http.NotFoundHandler(), nil)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-cars-subject", "mallory", "x-cars-roles", "admin")
+	res, err := client.Car(ctx, &carspb.CarRequest{Id: 1})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if res.Result.Make != "" || res.Result.Model != "" {
+		t.Errorf("Failed! claimed identity %s/%s reached the backend", res.Result.Make, res.Result.Model)
+	}
+}
+
+func TestProxyServerStreaming(t *testing.T) {
+	client, _ := startProxy(t, nil, http.NotFoundHandler(), nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &carspb.WatchRequest{AfterSequence: 4})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	var got []uint64
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		got = append(got, e.Sequence)
+	}
+	if len(got) != 2 || got[0] != 5 || got[1] != 6 {
+		t.Errorf("Failed! got events %v, want 5 and 6", got)
+	}
+}
+
+func TestProxyStop(t *testing.T) {
+	stop := make(chan struct{})
+	client, _ := startProxy(t, nil, http.NotFoundHandler(), stop)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &carspb.WatchRequest{})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	close(stop)
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Failed! expected Unavailable once stopped, got %v", err)
+	}
+}
+
+func TestMultiplexServesREST(t *testing.T) {
+	rest := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("rest"))
+	})
+	_, url := startProxy(t, nil, rest, nil)
+
+	res, err := http.Get(url + "/cars")
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != "rest" {
+		t.Errorf("Failed! REST request answered with %q", body)
+	}
+}
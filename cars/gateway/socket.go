@@ -0,0 +1,407 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/rest"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+)
+
+const (
+	// maxSocketMessage is the largest request a client may send
+	maxSocketMessage = 8 << 10
+	// maxSubscriptions is how many subscriptions a connection may hold
+	maxSubscriptions = 16
+	// maxSocketCalls is how many requests a connection may have running;
+	// further requests wait until one finishes
+	maxSocketCalls = 8
+	// socketWriteTimeout bounds each write to a slow client
+	socketWriteTimeout = 10 * time.Second
+)
+
+// socketRequest is a message from the client.  Type selects the call
+// and which of the other fields it reads.
+type socketRequest struct {
+	// ID is echoed in the answer so the client can match them up
+	ID   string `json:"id"`
+	Type string `json:"type"`
+
+	CarID           int64         `json:"car_id,string,omitempty"`
+	Filter          *socketFilter `json:"filter,omitempty"`
+	Subscription    string        `json:"subscription,omitempty"`
+	AfterSequence   uint64        `json:"after_sequence,string,omitempty"`
+	HoldID          string        `json:"hold_id,omitempty"`
+	DurationSeconds int64         `json:"duration_seconds,omitempty"`
+}
+
+// socketFilter selects cars by make, model and body style, ignoring
+// case.  An empty list matches everything.  Body styles come from the
+// catalog, so a car whose model it does not have matches none.
+type socketFilter struct {
+	Makes  []string `json:"makes,omitempty"`
+	Models []string `json:"models,omitempty"`
+	Bodies []string `json:"bodies,omitempty"`
+}
+
+// matches reports whether car passes the filter, finding its body style
+// with bodyOf only when the filter needs it
+func (f *socketFilter) matches(car *carspb.Car, bodyOf func(carMake, carModel string) string) bool {
+	if f == nil {
+		return true
+	}
+	if !anyEqualFold(f.Makes, car.GetMake()) || !anyEqualFold(f.Models, car.GetModel()) {
+		return false
+	}
+	return len(f.Bodies) == 0 || anyEqualFold(f.Bodies, bodyOf(car.GetMake(), car.GetModel()))
+}
+
+func anyEqualFold(list []string, s string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// socketMessage is a message to the client: an ack or error answering
+// the request with ID, or an event for a subscription
+type socketMessage struct {
+	Type         string `json:"type"`
+	ID           string `json:"id,omitempty"`
+	Subscription string `json:"subscription,omitempty"`
+
+	Car   *rest.Car   `json:"car,omitempty"`
+	Cars  []rest.Car  `json:"cars,omitempty"`
+	Hold  *rest.Hold  `json:"hold,omitempty"`
+	Event *rest.Event `json:"event,omitempty"`
+
+	// Code is the name of a gRPC status code, such as NotFound
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+/*
+SocketHandler serves the WebSocket API for showroom clients.  Each
+request message is answered by an ack or an error carrying the same id:
+
+	get          the car with car_id
+	list         the cars matching filter
+	subscribe    inventory events matching filter, sent as event messages
+	             tagged with the subscription name, after_sequence resuming
+	unsubscribe  ends the named subscription
+	hold         puts car_id on hold for duration_seconds
+	release      releases hold_id
+
+Calls go to the microservice as the caller who opened the connection,
+each bounded by timeout.  The connection is pinged every heartbeat and
+closed when ctx is done.
+*/
+func SocketHandler(ctx context.Context, client carspb.CarServiceClient, timeout, heartbeat time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader has already answered
+			return
+		}
+		s := &socket{
+			conn:          conn,
+			client:        client,
+			timeout:       timeout,
+			subscriptions: map[string]*subscription{},
+			bodies:        map[string]map[string]string{},
+		}
+		connCtx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.close(websocket.CloseGoingAway, "server is shutting down")
+			case <-connCtx.Done():
+			}
+		}()
+		go s.ping(connCtx, heartbeat)
+		s.serve(connCtx, heartbeat)
+		// stop the calls still running and their subscriptions
+		cancel()
+		s.running.Wait()
+		conn.Close()
+	})
+}
+
+type socket struct {
+	conn    *websocket.Conn
+	client  carspb.CarServiceClient
+	timeout time.Duration
+
+	writeMu sync.Mutex
+	running sync.WaitGroup
+
+	mu            sync.Mutex
+	subscriptions map[string]*subscription
+
+	bodiesMu sync.Mutex
+	// bodies are the body styles of the catalog models of each make
+	// looked up, by make and then model name or alias, in lower case
+	bodies map[string]map[string]string
+}
+
+type subscription struct {
+	cancel context.CancelFunc
+}
+
+// serve reads requests until the connection fails or is closed
+func (s *socket) serve(ctx context.Context, heartbeat time.Duration) {
+	s.conn.SetReadLimit(maxSocketMessage)
+	s.conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	})
+
+	calls := make(chan struct{}, maxSocketCalls)
+	for {
+		_, b, err := s.conn.ReadMessage()
+		if err != nil {
+			// closed by either side, or gone
+			return
+		}
+		var req socketRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			s.send(socketError("", "", status.Errorf(codes.InvalidArgument, "request is not valid: %v", err)))
+			continue
+		}
+		calls <- struct{}{}
+		s.running.Add(1)
+		go func() {
+			defer func() { <-calls; s.running.Done() }()
+			s.send(s.handle(ctx, req))
+		}()
+	}
+}
+
+// handle answers one request
+func (s *socket) handle(ctx context.Context, req socketRequest) socketMessage {
+	if req.Type == "subscribe" {
+		return s.subscribe(ctx, req)
+	}
+	if req.Type == "unsubscribe" {
+		return s.unsubscribe(req)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	ack := socketMessage{Type: "ack", ID: req.ID}
+	switch req.Type {
+	case "get":
+		res, err := s.client.Car(ctx, &carspb.CarRequest{Id: req.CarID})
+		if err != nil {
+			return socketError(req.ID, "", err)
+		}
+		car := rest.NewCar(res.GetResult(), visibility.LevelFromContext(ctx))
+		ack.Car = &car
+	case "list":
+		res, err := s.client.CarWithDeadline(ctx, &carspb.CarWithDeadlineRequest{})
+		if err != nil {
+			return socketError(req.ID, "", err)
+		}
+		for _, car := range res.GetResult() {
+			if req.Filter.matches(car, s.bodyOf(ctx)) {
+				ack.Cars = append(ack.Cars, rest.NewCar(car, visibility.LevelFromContext(ctx)))
+			}
+		}
+	case "hold":
+		hold := &carspb.PlaceHoldRequest{CarId: req.CarID}
+		if req.DurationSeconds != 0 {
+			hold.Duration = durationpb.New(time.Duration(req.DurationSeconds) * time.Second)
+		}
+		res, err := s.client.PlaceHold(ctx, hold)
+		if err != nil {
+			return socketError(req.ID, "", err)
+		}
+		h := rest.NewHold(res.GetResult())
+		ack.Hold = &h
+	case "release":
+		res, err := s.client.ReleaseHold(ctx, &carspb.ReleaseHoldRequest{HoldId: req.HoldID})
+		if err != nil {
+			return socketError(req.ID, "", err)
+		}
+		h := rest.NewHold(res.GetResult())
+		ack.Hold = &h
+	default:
+		return socketError(req.ID, "", status.Errorf(codes.InvalidArgument, "unknown request type %q", req.Type))
+	}
+	return ack
+}
+
+// subscribe starts a Watch call for the subscription, which runs until
+// it is unsubscribed, fails or the connection closes
+func (s *socket) subscribe(ctx context.Context, req socketRequest) socketMessage {
+	name := req.Subscription
+	if name == "" {
+		return socketError(req.ID, "", status.Error(codes.InvalidArgument, "subscription must be named"))
+	}
+	s.mu.Lock()
+	if _, taken := s.subscriptions[name]; taken {
+		s.mu.Unlock()
+		return socketError(req.ID, name, status.Error(codes.AlreadyExists, "subscription name is in use"))
+	}
+	if len(s.subscriptions) >= maxSubscriptions {
+		s.mu.Unlock()
+		return socketError(req.ID, name, status.Errorf(codes.ResourceExhausted, "at most %d subscriptions per connection", maxSubscriptions))
+	}
+	subCtx, cancel := context.WithCancel(ctx)
+	sub := &subscription{cancel: cancel}
+	s.subscriptions[name] = sub
+	s.mu.Unlock()
+
+	stream, err := watch(subCtx, s.client, req.AfterSequence)
+	if err != nil {
+		s.remove(name, sub)
+		return socketError(req.ID, name, err)
+	}
+	// the ack goes out before any event of the subscription
+	s.send(socketMessage{Type: "ack", ID: req.ID, Subscription: name})
+	bodyOf := s.bodyOf(subCtx)
+	go func() {
+		defer s.remove(name, sub)
+		for {
+			e, err := stream.Recv()
+			if err != nil {
+				if subCtx.Err() == nil {
+					s.send(socketError("", name, err))
+				}
+				return
+			}
+			if e.GetCar() != nil && !req.Filter.matches(e.GetCar(), bodyOf) {
+				continue
+			}
+			event := rest.NewEvent(e, visibility.LevelFromContext(subCtx))
+			s.send(socketMessage{Type: "event", Subscription: name, Event: &event})
+		}
+	}()
+	// already acknowledged
+	return socketMessage{}
+}
+
+// bodyOf returns a lookup of the catalog body style of a make and model,
+// which asks the microservice for each make's models once per connection
+func (s *socket) bodyOf(ctx context.Context) func(carMake, carModel string) string {
+	return func(carMake, carModel string) string {
+		key := strings.ToLower(carMake)
+		s.bodiesMu.Lock()
+		models, ok := s.bodies[key]
+		s.bodiesMu.Unlock()
+		if !ok {
+			callCtx, cancel := context.WithTimeout(ctx, s.timeout)
+			defer cancel()
+			res, err := s.client.ListModels(callCtx, &carspb.ListModelsRequest{Make: carMake})
+			switch status.Code(err) {
+			case codes.OK, codes.NotFound, codes.Unimplemented:
+			default:
+				// asked again for the next car
+				return ""
+			}
+			models = map[string]string{}
+			for _, m := range res.GetModels() {
+				for _, name := range append([]string{m.GetName()}, m.GetAliases()...) {
+					models[strings.ToLower(name)] = m.GetBody()
+				}
+			}
+			s.bodiesMu.Lock()
+			s.bodies[key] = models
+			s.bodiesMu.Unlock()
+		}
+		return models[strings.ToLower(carModel)]
+	}
+}
+
+func (s *socket) unsubscribe(req socketRequest) socketMessage {
+	if !s.remove(req.Subscription, nil) {
+		return socketError(req.ID, req.Subscription, status.Error(codes.NotFound, "no such subscription"))
+	}
+	return socketMessage{Type: "ack", ID: req.ID, Subscription: req.Subscription}
+}
+
+// remove cancels the named subscription, reporting whether it existed.
+// With sub set only that subscription is removed, not a later one that
+// reused its name.
+func (s *socket) remove(name string, sub *subscription) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.subscriptions[name]
+	if !ok || (sub != nil && current != sub) {
+		return false
+	}
+	current.cancel()
+	delete(s.subscriptions, name)
+	return true
+}
+
+// send writes msg, unless it is empty because it was already sent
+func (s *socket) send(msg socketMessage) {
+	if msg.Type == "" {
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	if err := s.conn.WriteJSON(msg); err != nil {
+		// the read loop notices the broken connection
+		s.conn.Close()
+	}
+}
+
+func (s *socket) ping(ctx context.Context, heartbeat time.Duration) {
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.writeMu.Lock()
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteTimeout))
+			s.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *socket) close(code int, reason string) {
+	s.writeMu.Lock()
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	s.writeMu.Unlock()
+	s.conn.Close()
+}
+
+// socketError answers a request, or reports a failed subscription, with
+// the status of err
+func socketError(id, subscription string, err error) socketMessage {
+	st := status.Convert(err)
+	if st.Code() == codes.Unknown || st.Code() == codes.Internal {
+		log.Printf("websocket call failed: %v", err)
+	}
+	return socketMessage{Type: "error", ID: id, Subscription: subscription, Code: st.Code().String(), Message: st.Message()}
+}
@@ -0,0 +1,209 @@
+package gateway
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+// socketClient serves the showroom calls from a fixed inventory
+type socketClient struct {
+	*watchClient
+	cars []*carspb.Car
+	hold *carspb.PlaceHoldRequest
+}
+
+func (c *socketClient) Car(ctx context.Context, in *carspb.CarRequest, opts ...grpc.CallOption) (*carspb.CarResponse, error) {
+	for _, car := range c.cars {
+		if car.Id == in.Id {
+			return &carspb.CarResponse{Result: car}, nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "car not found")
+}
+
+func (c *socketClient) CarWithDeadline(ctx context.Context, in *carspb.CarWithDeadlineRequest, opts ...grpc.CallOption) (*carspb.CarWithDeadlineResponse, error) {
+	return &carspb.CarWithDeadlineResponse{Result: c.cars}, nil
+}
+
+func (c *socketClient) PlaceHold(ctx context.Context, in *carspb.PlaceHoldRequest, opts ...grpc.CallOption) (*carspb.PlaceHoldResponse, error) {
+	c.hold = in
+	return &carspb.PlaceHoldResponse{Result: &carspb.Hold{
+		Id:         "h1",
+		CarId:      in.CarId,
+		HeldBy:     "kiosk-1",
+		ExpireTime: timestamppb.New(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)),
+	}}, nil
+}
+
+func (c *socketClient) ReleaseHold(ctx context.Context, in *carspb.ReleaseHoldRequest, opts ...grpc.CallOption) (*carspb.ReleaseHoldResponse, error) {
+	return nil, status.Error(codes.NotFound, "data: hold not found")
+}
+
+func (c *socketClient) ListModels(ctx context.Context, in *carspb.ListModelsRequest, opts ...grpc.CallOption) (*carspb.ListModelsResponse, error) {
+	if !strings.EqualFold(in.Make, "toyota") {
+		return nil, status.Error(codes.NotFound, "no such make")
+	}
+	return &carspb.ListModelsResponse{Models: []*carspb.CatalogModel{
+		{Name: "RAV4", Aliases: []string{"Rav 4"}, Body: "suv"},
+		{Name: "Camry", Body: "sedan"},
+	}}, nil
+}
+
+func dialSocket(t *testing.T, client carspb.CarServiceClient) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(SocketHandler(context.Background(), client, time.Second, time.Minute))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// call sends request and returns the next message received
+func call(t *testing.T, conn *websocket.Conn, request string) string {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(request)); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	return next(t, conn)
+}
+
+func next(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	_, b, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	return string(b)
+}
+
+func TestSocketCalls(t *testing.T) {
+	client := &socketClient{
+		watchClient: newWatchClient(),
+		cars: []*carspb.Car{
+			{Id: 1, Make: "Ford", Model: "F10"},
+			{Id: 3, Make: "Toyota", Model: "Rav4"},
+		},
+	}
+	conn := dialSocket(t, client)
+
+	tests := []struct {
+		request, want string
+	}{
+		{`{"id":"1","type":"get","car_id":"3"}`,
+			`{"type":"ack","id":"1","car":{"id":"3","make":"Toyota","model":"Rav4"}}`},
+		{`{"id":"2","type":"get","car_id":"9"}`,
+			`{"type":"error","id":"2","code":"NotFound","message":"car not found"}`},
+		{`{"id":"3","type":"list","filter":{"makes":["toyota"]}}`,
+			`{"type":"ack","id":"3","cars":[{"id":"3","make":"Toyota","model":"Rav4"}]}`},
+		{`{"id":"4","type":"hold","car_id":"3","duration_seconds":900}`,
+			`{"type":"ack","id":"4","hold":{"id":"h1","car_id":"3","held_by":"kiosk-1","expire_time":"2024-05-01T12:30:00Z"}}`},
+		{`{"id":"5","type":"release","hold_id":"h9"}`,
+			`{"type":"error","id":"5","code":"NotFound","message":"data: hold not found"}`},
+		{`{"id":"6","type":"buy"}`,
+			`{"type":"error","id":"6","code":"InvalidArgument","message":"unknown request type \"buy\""}`},
+		{`{"id":"7","type":"unsubscribe","subscription":"none"}`,
+			`{"type":"error","id":"7","subscription":"none","code":"NotFound","message":"no such subscription"}`},
+	}
+	for _, tt := range tests {
+		if got := call(t, conn, tt.request); got != tt.want+"\n" {
+			t.Errorf("Failed! %s: got %s, want %s", tt.request, got, tt.want)
+		}
+	}
+	if got := client.hold.GetDuration().AsDuration(); got != 15*time.Minute {
+		t.Errorf("Failed! hold duration %v, want 15m", got)
+	}
+
+	got := call(t, conn, `not json`)
+	if !strings.Contains(got, `"code":"InvalidArgument"`) {
+		t.Errorf("Failed! malformed request answered with %s", got)
+	}
+}
+
+func TestSocketBodyFilter(t *testing.T) {
+	client := &socketClient{
+		watchClient: newWatchClient(
+			&carspb.InventoryEvent{Sequence: 1, Type: carspb.EventType_EVENT_TYPE_UPDATED, Car: &carspb.Car{Id: 2, Make: "Toyota", Model: "Camry"}},
+			&carspb.InventoryEvent{Sequence: 2, Type: carspb.EventType_EVENT_TYPE_HELD, Car: &carspb.Car{Id: 3, Make: "Toyota", Model: "Rav4"}},
+		),
+		cars: []*carspb.Car{
+			{Id: 1, Make: "Ford", Model: "F10"},
+			{Id: 2, Make: "Toyota", Model: "Camry"},
+			{Id: 3, Make: "Toyota", Model: "Rav4"},
+		},
+	}
+	conn := dialSocket(t, client)
+
+	// only Toyota SUVs
+	want := `{"type":"ack","id":"1","cars":[{"id":"3","make":"Toyota","model":"Rav4"}]}` + "\n"
+	if got := call(t, conn, `{"id":"1","type":"list","filter":{"bodies":["SUV"]}}`); got != want {
+		t.Errorf("Failed! got %s, want %s", got, want)
+	}
+
+	request := `{"id":"2","type":"subscribe","subscription":"suvs","filter":{"makes":["toyota"],"bodies":["suv"]}}`
+	if got, want := call(t, conn, request), `{"type":"ack","id":"2","subscription":"suvs"}`+"\n"; got != want {
+		t.Errorf("Failed! got %s, want %s", got, want)
+	}
+	// the Camry event is filtered out
+	want = `{"type":"event","subscription":"suvs","event":{"sequence":"2","type":"held","car":{"id":"3","make":"Toyota","model":"Rav4"},"time":"1970-01-01T00:00:00Z"}}` + "\n"
+	if got := next(t, conn); got != want {
+		t.Errorf("Failed! got %s, want %s", got, want)
+	}
+}
+
+func TestSocketSubscription(t *testing.T) {
+	client := &socketClient{watchClient: newWatchClient(
+		&carspb.InventoryEvent{Sequence: 1, Type: carspb.EventType_EVENT_TYPE_UPDATED, Car: &carspb.Car{Id: 1, Make: "Ford", Model: "F10"}},
+		&carspb.InventoryEvent{Sequence: 2, Type: carspb.EventType_EVENT_TYPE_HELD, Car: &carspb.Car{Id: 3, Make: "Toyota", Model: "Rav4"}},
+	)}
+	conn := dialSocket(t, client)
+
+	request := `{"id":"1","type":"subscribe","subscription":"toyotas","filter":{"makes":["Toyota"],"models":["rav4","highlander"]}}`
+	if got, want := call(t, conn, request), `{"type":"ack","id":"1","subscription":"toyotas"}`+"\n"; got != want {
+		t.Errorf("Failed! got %s, want %s", got, want)
+	}
+	// the Ford event is filtered out
+	want := `{"type":"event","subscription":"toyotas","event":{"sequence":"2","type":"held","car":{"id":"3","make":"Toyota","model":"Rav4"},"time":"1970-01-01T00:00:00Z"}}` + "\n"
+	if got := next(t, conn); got != want {
+		t.Errorf("Failed! got %s, want %s", got, want)
+	}
+
+	if got := call(t, conn, `{"id":"2","type":"subscribe","subscription":"toyotas"}`); !strings.Contains(got, `"code":"AlreadyExists"`) {
+		t.Errorf("Failed! duplicate subscription answered with %s", got)
+	}
+
+	// unsubscribing ends the Watch call
+	if got, want := call(t, conn, `{"id":"3","type":"unsubscribe","subscription":"toyotas"}`), `{"type":"ack","id":"3","subscription":"toyotas"}`+"\n"; got != want {
+		t.Errorf("Failed! got %s, want %s", got, want)
+	}
+	select {
+	case <-client.done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Failed! Watch was not cancelled by unsubscribe")
+	}
+}
+
+func TestSocketSubscriptionRefused(t *testing.T) {
+	client := &socketClient{watchClient: newWatchClient()}
+	client.err = status.Error(codes.PermissionDenied, "viewer required")
+	conn := dialSocket(t, client)
+
+	want := `{"type":"error","id":"1","subscription":"all","code":"PermissionDenied","message":"viewer required"}` + "\n"
+	if got := call(t, conn, `{"id":"1","type":"subscribe","subscription":"all"}`); got != want {
+		t.Errorf("Failed! got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,438 @@
+/*
+Package graphql serves a GraphQL API over CarService, so clients can
+fetch cars and the entities related to them in one round trip.
+*/
+package graphql
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	gql "github.com/graph-gophers/graphql-go"
+	gqlerrors "github.com/graph-gophers/graphql-go/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/rest"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+)
+
+// Schema is the GraphQL schema served, in the schema definition language
+//
+//go:embed schema.graphql
+var Schema string
+
+const (
+	// maxDepth bounds how deeply queries may nest fields
+	maxDepth = 8
+	// maxRequestBytes bounds the size of a request body
+	maxRequestBytes = 64 << 10
+	// maxCars bounds the cars one query may resolve, as each level of
+	// nested comparables multiplies the cars of the level above
+	maxCars = 10000
+)
+
+// errTooManyCars refuses a query that resolves more than maxCars cars
+var errTooManyCars = &callError{
+	code:    codes.ResourceExhausted,
+	message: fmt.Sprintf("the query resolves more than %d cars, nest comparables less deeply or ask for fewer cars", maxCars),
+}
+
+var schema = gql.MustParseSchema(Schema, &resolver{},
+	gql.UseStringDescriptions(),
+	gql.MaxDepth(maxDepth),
+)
+
+// request is a GraphQL request, as a JSON body or query parameters
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+/*
+Handler serves GraphQL requests sent as a JSON body with POST or as
+query parameters with GET.  Each request gets timeout to finish its
+CarService calls; the lookups it makes are batched and cached for the
+request only.
+*/
+func Handler(client carspb.CarServiceClient, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if r.Method == http.MethodGet {
+			q := r.URL.Query()
+			req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+			if v := q.Get("variables"); v != "" {
+				if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+					writeError(w, http.StatusBadRequest, "variables must be a JSON object")
+					return
+				}
+			}
+		} else {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "request body must be a JSON object with a query")
+				return
+			}
+		}
+		if strings.TrimSpace(req.Query) == "" {
+			writeError(w, http.StatusBadRequest, "query is required")
+			return
+		}
+
+		w.Header().Set("content-type", "application/json")
+		// queries that do not validate are not executed at all
+		if errs := schema.ValidateWithVariables(req.Query, req.Variables); len(errs) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(&gql.Response{Errors: errs})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		l := &loaders{
+			cars:      newCarLoader(ctx, client),
+			listings:  newListingLoader(ctx, client),
+			inventory: &inventoryLoader{ctx: ctx, client: client},
+		}
+		res := schema.Exec(context.WithValue(ctx, loadersKey{}, l), req.Query, req.OperationName, req.Variables)
+		if l.resolved.Load() > maxCars {
+			// the partial result would repeat the error for every field
+			// refused, so the query is refused as a whole
+			res = &gql.Response{Errors: []*gqlerrors.QueryError{{
+				Message:    errTooManyCars.Error(),
+				Extensions: errTooManyCars.Extensions(),
+			}}}
+		}
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Printf("encoding GraphQL response: %v", err)
+		}
+	})
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"message": msg}},
+	})
+}
+
+type loadersKey struct{}
+
+// loaders are the per-request caches the resolvers fetch through
+type loaders struct {
+	cars      *batchLoader[*carspb.Car]
+	listings  *batchLoader[*carspb.Listing]
+	inventory *inventoryLoader
+	// resolved counts the cars resolved so far, against maxCars
+	resolved atomic.Int64
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// callError is a failed CarService call, reported with the name of its
+// gRPC status code in the error's extensions
+type callError struct {
+	code    codes.Code
+	message string
+}
+
+func (e *callError) Error() string {
+	return e.message
+}
+
+func (e *callError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code.String()}
+}
+
+func newCallError(err error) error {
+	st := status.Convert(err)
+	msg := st.Message()
+	switch st.Code() {
+	case codes.Unavailable:
+		msg = "car microservice is unavailable, try again shortly"
+	case codes.DeadlineExceeded:
+		msg = "Timeout was hit.  Deadline exceeded."
+	case codes.Unknown, codes.Internal:
+		log.Printf("GraphQL call failed: %v", err)
+		msg = "error retrieving Cars information"
+	}
+	return &callError{code: st.Code(), message: msg}
+}
+
+func parseID(id gql.ID) (int64, error) {
+	n, err := strconv.ParseInt(string(id), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("id %q is not valid", id)
+	}
+	return n, nil
+}
+
+type resolver struct{}
+
+func (*resolver) Car(ctx context.Context, args struct{ ID gql.ID }) (*carResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, err
+	}
+	car, err := loadersFrom(ctx).cars.load(id)
+	if err != nil {
+		return nil, newCallError(err)
+	}
+	if car == nil {
+		return nil, nil
+	}
+	return newCarResolver(ctx, car)
+}
+
+func (*resolver) Cars(ctx context.Context, args struct {
+	IDs   *[]gql.ID
+	Make  *string
+	Model *string
+}) ([]*carResolver, error) {
+	var cars []*carspb.Car
+	if args.IDs != nil {
+		ids := make([]int64, len(*args.IDs))
+		for i, id := range *args.IDs {
+			var err error
+			if ids[i], err = parseID(id); err != nil {
+				return nil, err
+			}
+		}
+		found, err := loadersFrom(ctx).cars.loadMany(ids)
+		if err != nil {
+			return nil, newCallError(err)
+		}
+		for _, car := range found {
+			if car != nil {
+				cars = append(cars, car)
+			}
+		}
+	} else {
+		all, err := loadersFrom(ctx).inventory.load()
+		if err != nil {
+			return nil, newCallError(err)
+		}
+		cars = all
+	}
+
+	result := []*carResolver{}
+	for _, car := range cars {
+		if matches(args.Make, car.GetMake()) && matches(args.Model, car.GetModel()) {
+			c, err := newCarResolver(ctx, car)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (*resolver) Comparables(ctx context.Context, args struct{ TradeIn tradeInInput }) ([]*carResolver, error) {
+	return comparables(ctx, args.TradeIn.Make, args.TradeIn.Model, 0)
+}
+
+// comparables returns the cars in stock with carMake and carModel, other
+// than the car with id except
+func comparables(ctx context.Context, carMake, carModel string, except int64) ([]*carResolver, error) {
+	all, err := loadersFrom(ctx).inventory.load()
+	if err != nil {
+		return nil, newCallError(err)
+	}
+	result := []*carResolver{}
+	for _, car := range all {
+		if car.GetId() != except && strings.EqualFold(car.GetMake(), carMake) && strings.EqualFold(car.GetModel(), carModel) {
+			c, err := newCarResolver(ctx, car)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+// matches reports whether want, when given, equals s ignoring case
+func matches(want *string, s string) bool {
+	return want == nil || strings.EqualFold(*want, s)
+}
+
+type tradeInInput struct {
+	Make  string
+	Model string
+}
+
+type carResolver struct {
+	car rest.Car
+}
+
+// newCarResolver resolves car, counting it against the query's maxCars
+func newCarResolver(ctx context.Context, car *carspb.Car) (*carResolver, error) {
+	if loadersFrom(ctx).resolved.Add(1) > maxCars {
+		return nil, errTooManyCars
+	}
+	return &carResolver{car: rest.NewCar(car, visibility.LevelFromContext(ctx))}, nil
+}
+
+func (c *carResolver) ID() gql.ID {
+	return gql.ID(strconv.FormatInt(c.car.Id, 10))
+}
+
+func (c *carResolver) Make() string {
+	return c.car.Make
+}
+
+func (c *carResolver) Model() string {
+	return c.car.Model
+}
+
+func (c *carResolver) Vin() string {
+	return c.car.Vin
+}
+
+func (c *carResolver) Year() int32 {
+	return c.car.Year
+}
+
+func (c *carResolver) Color() string {
+	return c.car.Color
+}
+
+func (c *carResolver) Mileage() string {
+	return strconv.FormatInt(c.car.Mileage, 10)
+}
+
+func (c *carResolver) AcquisitionCostCents() *string {
+	return formatCents(c.car.AcquisitionCostCents)
+}
+
+func (c *carResolver) MarginCents() *string {
+	return formatCents(c.car.MarginCents)
+}
+
+func (c *carResolver) TradeIn() *tradeInResolver {
+	return &tradeInResolver{make: c.car.Make, model: c.car.Model}
+}
+
+func (c *carResolver) Comparables(ctx context.Context) ([]*carResolver, error) {
+	return comparables(ctx, c.car.Make, c.car.Model, c.car.Id)
+}
+
+// listing returns what the showroom shows with the car, fetched in a batch
+// with the listings of the other cars in the query.  It is nil when the
+// car has gone since it was read.
+func (c *carResolver) listing(ctx context.Context) (*carspb.Listing, error) {
+	listing, err := loadersFrom(ctx).listings.load(c.car.Id)
+	if err != nil {
+		return nil, newCallError(err)
+	}
+	return listing, nil
+}
+
+func (c *carResolver) Dealer(ctx context.Context) (*dealerResolver, error) {
+	listing, err := c.listing(ctx)
+	if err != nil || listing.GetDealer() == nil {
+		return nil, err
+	}
+	return &dealerResolver{dealer: listing.GetDealer()}, nil
+}
+
+func (c *carResolver) Photos(ctx context.Context) ([]*photoResolver, error) {
+	listing, err := c.listing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := []*photoResolver{}
+	for _, photo := range listing.GetPhotos() {
+		result = append(result, &photoResolver{photo: photo})
+	}
+	return result, nil
+}
+
+func (c *carResolver) PriceHistory(ctx context.Context) ([]*pricePointResolver, error) {
+	listing, err := c.listing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := []*pricePointResolver{}
+	for _, point := range listing.GetPriceHistory() {
+		result = append(result, &pricePointResolver{point: point})
+	}
+	return result, nil
+}
+
+func formatCents(cents *int64) *string {
+	if cents == nil {
+		return nil
+	}
+	s := strconv.FormatInt(*cents, 10)
+	return &s
+}
+
+type tradeInResolver struct {
+	make, model string
+}
+
+func (t *tradeInResolver) Make() string {
+	return t.make
+}
+
+func (t *tradeInResolver) Model() string {
+	return t.model
+}
+
+type dealerResolver struct {
+	dealer *carspb.Dealer
+}
+
+func (d *dealerResolver) ID() gql.ID {
+	return gql.ID(strconv.FormatInt(d.dealer.GetId(), 10))
+}
+
+func (d *dealerResolver) Name() string {
+	return d.dealer.GetName()
+}
+
+func (d *dealerResolver) City() string {
+	return d.dealer.GetCity()
+}
+
+func (d *dealerResolver) Phone() string {
+	return d.dealer.GetPhone()
+}
+
+type photoResolver struct {
+	photo *carspb.Photo
+}
+
+func (p *photoResolver) URL() string {
+	return p.photo.GetUrl()
+}
+
+func (p *photoResolver) Caption() string {
+	return p.photo.GetCaption()
+}
+
+type pricePointResolver struct {
+	point *carspb.PricePoint
+}
+
+func (p *pricePointResolver) PriceCents() string {
+	return strconv.FormatInt(p.point.GetPriceCents(), 10)
+}
+
+func (p *pricePointResolver) Time() string {
+	return p.point.GetTime().AsTime().Format(time.RFC3339)
+}
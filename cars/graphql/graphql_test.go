@@ -0,0 +1,247 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+)
+
+// countingClient serves a fixed inventory and counts the calls made
+type countingClient struct {
+	carspb.CarServiceClient
+	cars []*carspb.Car
+	err  error
+
+	mu       sync.Mutex
+	batches  [][]int64
+	listings [][]int64
+	lists    int
+}
+
+func (c *countingClient) BatchGetCars(ctx context.Context, in *carspb.BatchGetCarsRequest, opts ...grpc.CallOption) (*carspb.BatchGetCarsResponse, error) {
+	c.mu.Lock()
+	c.batches = append(c.batches, in.Ids)
+	c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	res := &carspb.BatchGetCarsResponse{}
+	for _, id := range in.Ids {
+		for _, car := range c.cars {
+			if car.Id == id {
+				res.Result = append(res.Result, car)
+			}
+		}
+	}
+	return res, nil
+}
+
+func (c *countingClient) BatchGetListings(ctx context.Context, in *carspb.BatchGetListingsRequest, opts ...grpc.CallOption) (*carspb.BatchGetListingsResponse, error) {
+	c.mu.Lock()
+	c.listings = append(c.listings, in.CarIds)
+	c.mu.Unlock()
+	res := &carspb.BatchGetListingsResponse{}
+	for _, id := range in.CarIds {
+		for _, car := range c.cars {
+			if car.Id != id {
+				continue
+			}
+			listing := &carspb.Listing{CarId: id}
+			if id == 1 {
+				listing.Dealer = &carspb.Dealer{Id: 1, Name: "Harbor Motors", City: "Portland", Phone: "503-555-0100"}
+				listing.Photos = []*carspb.Photo{{Url: "https://example.com/1.jpg", Caption: "Front"}}
+				listing.PriceHistory = []*carspb.PricePoint{
+					{PriceCents: 3200000, Time: timestamppb.New(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))},
+				}
+			}
+			res.Result = append(res.Result, listing)
+		}
+	}
+	return res, nil
+}
+
+func (c *countingClient) CarWithDeadline(ctx context.Context, in *carspb.CarWithDeadlineRequest, opts ...grpc.CallOption) (*carspb.CarWithDeadlineResponse, error) {
+	c.mu.Lock()
+	c.lists++
+	c.mu.Unlock()
+	return &carspb.CarWithDeadlineResponse{Result: c.cars}, nil
+}
+
+func (c *countingClient) Car(ctx context.Context, in *carspb.CarRequest, opts ...grpc.CallOption) (*carspb.CarResponse, error) {
+	panic("lookups must be batched")
+}
+
+var testCars = []*carspb.Car{
+	{Id: 1, Make: "Ford", Model: "F10", AcquisitionCostCents: 2850000, MarginCents: 310000},
+	{Id: 2, Make: "Toyota", Model: "Camry"},
+	{Id: 3, Make: "Toyota", Model: "Rav4"},
+	{Id: 7, Make: "Toyota", Model: "Rav4"},
+}
+
+func query(t *testing.T, client carspb.CarServiceClient, q string, id *auth.Identity) (int, string) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"query": q})
+	req := httptest.NewRequest("POST", "/graphql", bytes.NewReader(body))
+	if id != nil {
+		req = req.WithContext(auth.NewContext(req.Context(), *id))
+	}
+	rec := httptest.NewRecorder()
+	Handler(client, time.Second).ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestLookupsAreBatched(t *testing.T) {
+	client := &countingClient{cars: testCars}
+	code, body := query(t, client, `{
+		a: car(id: "1") { id make }
+		b: car(id: "3") { id make }
+		c: car(id: "9") { id }
+		d: car(id: "1") { model }
+		cars(ids: ["2", "3", "9"]) { id }
+	}`, nil)
+	want := `{"data":{"a":{"id":"1","make":"Ford"},"b":{"id":"3","make":"Toyota"},"c":null,"d":{"model":"F10"},"cars":[{"id":"2"},{"id":"3"}]}}` + "\n"
+	if code != http.StatusOK || body != want {
+		t.Errorf("Failed! got %d %s, want %s", code, body, want)
+	}
+
+	var asked []int64
+	for _, b := range client.batches {
+		asked = append(asked, b...)
+	}
+	sort.Slice(asked, func(i, j int) bool { return asked[i] < asked[j] })
+	if len(client.batches) != 1 {
+		t.Errorf("Failed! expected one BatchGetCars call, got %v", client.batches)
+	}
+	if want := []int64{1, 2, 3, 9}; len(asked) != len(want) {
+		t.Errorf("Failed! each id should be fetched once, got %v", asked)
+	}
+}
+
+func TestListingsAreBatched(t *testing.T) {
+	client := &countingClient{cars: testCars}
+	code, body := query(t, client, `{
+		cars(ids: ["2", "3", "7"]) { id dealer { name } photos { url } }
+		car(id: "1") {
+			dealer { id name city phone }
+			photos { url caption }
+			priceHistory { priceCents time }
+		}
+	}`, nil)
+	want := `{"data":{"cars":[` +
+		`{"id":"2","dealer":null,"photos":[]},` +
+		`{"id":"3","dealer":null,"photos":[]},` +
+		`{"id":"7","dealer":null,"photos":[]}],` +
+		`"car":{"dealer":{"id":"1","name":"Harbor Motors","city":"Portland","phone":"503-555-0100"},` +
+		`"photos":[{"url":"https://example.com/1.jpg","caption":"Front"}],` +
+		`"priceHistory":[{"priceCents":"3200000","time":"2021-03-01T00:00:00Z"}]}}}` + "\n"
+	if code != http.StatusOK || body != want {
+		t.Errorf("Failed! got %d %s, want %s", code, body, want)
+	}
+	if len(client.listings) != 1 || len(client.listings[0]) != 4 {
+		t.Errorf("Failed! expected the listings of 4 cars in one BatchGetListings call, got %v", client.listings)
+	}
+}
+
+func TestComparablesListOnce(t *testing.T) {
+	client := &countingClient{cars: testCars}
+	code, body := query(t, client, `{
+		cars(make: "toyota") { id tradeIn { make model } comparables { id } }
+		comparables(tradeIn: {make: "TOYOTA", model: "rav4"}) { id }
+	}`, nil)
+	want := `{"data":{"cars":[` +
+		`{"id":"2","tradeIn":{"make":"Toyota","model":"Camry"},"comparables":[]},` +
+		`{"id":"3","tradeIn":{"make":"Toyota","model":"Rav4"},"comparables":[{"id":"7"}]},` +
+		`{"id":"7","tradeIn":{"make":"Toyota","model":"Rav4"},"comparables":[{"id":"3"}]}],` +
+		`"comparables":[{"id":"3"},{"id":"7"}]}}` + "\n"
+	if code != http.StatusOK || body != want {
+		t.Errorf("Failed! got %d %s, want %s", code, body, want)
+	}
+	if client.lists != 1 {
+		t.Errorf("Failed! expected the inventory to be listed once, got %d", client.lists)
+	}
+}
+
+func TestCostVisibility(t *testing.T) {
+	q := `{ car(id: "1") { acquisitionCostCents marginCents } }`
+	tests := []struct {
+		id   *auth.Identity
+		want string
+	}{
+		{nil, `{"data":{"car":{"acquisitionCostCents":null,"marginCents":null}}}`},
+		{&auth.Identity{Subject: "s", Roles: []auth.Role{auth.Sales}}, `{"data":{"car":{"acquisitionCostCents":null,"marginCents":"300000"}}}`},
+		{&auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}}, `{"data":{"car":{"acquisitionCostCents":"2850000","marginCents":"310000"}}}`},
+	}
+	for _, tt := range tests {
+		// the microservice filters by role before the gateway sees the car
+		cars := []*carspb.Car{visibility.Car(visibility.LevelFor(identity(tt.id)), testCars[0])}
+		if _, body := query(t, &countingClient{cars: cars}, q, tt.id); body != tt.want+"\n" {
+			t.Errorf("Failed! %v: got %s, want %s", tt.id, body, tt.want)
+		}
+	}
+}
+
+func identity(id *auth.Identity) auth.Identity {
+	if id == nil {
+		return auth.Identity{}
+	}
+	return *id
+}
+
+func TestErrors(t *testing.T) {
+	client := &countingClient{err: status.Error(codes.Unavailable, "down")}
+	code, body := query(t, client, `{ car(id: "1") { id } }`, nil)
+	want := `{"errors":[{"message":"car microservice is unavailable, try again shortly","path":["car"],"extensions":{"code":"Unavailable"}}],"data":{"car":null}}` + "\n"
+	if code != http.StatusOK || body != want {
+		t.Errorf("Failed! got %d %s, want %s", code, body, want)
+	}
+
+	if code, body := query(t, client, `{ car(id: "1") { price } }`, nil); code != http.StatusBadRequest {
+		t.Errorf("Failed! invalid query got %d %s", code, body)
+	}
+
+	req := httptest.NewRequest("GET", "/graphql?query="+url.QueryEscape(`{ cars(ids: ["x"]) { id } }`), nil)
+	rec := httptest.NewRecorder()
+	Handler(client, time.Second).ServeHTTP(rec, req)
+	if want := `{"errors":[{"message":"id \"x\" is not valid","path":["cars"]}],"data":null}`; rec.Code != http.StatusOK || rec.Body.String() != want+"\n" {
+		t.Errorf("Failed! got %s, want %s", rec.Body.String(), want)
+	}
+}
+
+func TestNestedComparablesRefused(t *testing.T) {
+	// each level lists the other 7 cars, so 7 levels resolve 7^7 cars
+	var cars []*carspb.Car
+	for id := int64(1); id <= 8; id++ {
+		cars = append(cars, &carspb.Car{Id: id, Make: "Toyota", Model: "RAV4"})
+	}
+	client := &countingClient{cars: cars}
+	code, body := query(t, client, `{ comparables(tradeIn: {make: "Toyota", model: "RAV4"}) {
+		comparables { comparables { comparables { comparables { comparables { comparables { id } } } } } }
+	} }`, nil)
+	want := `{"errors":[{"message":"the query resolves more than 10000 cars, nest comparables less deeply or ask for fewer cars","extensions":{"code":"ResourceExhausted"}}]}` + "\n"
+	if code != http.StatusOK || body != want {
+		t.Errorf("Failed! got %d %.300s, want %s", code, body, want)
+	}
+
+	// two levels are fine
+	code, body = query(t, client, `{ car(id: "1") { comparables { comparables { id } } } }`, nil)
+	if code != http.StatusOK || strings.Contains(body, "errors") {
+		t.Errorf("Failed! got %d %.300s", code, body)
+	}
+}
@@ -0,0 +1,161 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+const (
+	// batchWait is how long a lookup waits for others to join its batch
+	batchWait = time.Millisecond
+	// maxBatch is the most ids fetched by one batch call
+	maxBatch = 100
+)
+
+/*
+batchLoader collects the lookups by id made while resolving one query
+into calls to fetch, so a query naming many cars makes one call rather
+than one call per car.  Each id is fetched at most once per query.
+*/
+type batchLoader[T any] struct {
+	ctx context.Context
+	// fetch returns what it finds for ids, by id
+	fetch func(ctx context.Context, ids []int64) (map[int64]T, error)
+
+	mu    sync.Mutex
+	batch *batch[T]
+	// fetched maps each id asked for to the batch fetching it
+	fetched map[int64]*batch[T]
+}
+
+type batch[T any] struct {
+	ids   []int64
+	once  sync.Once
+	done  chan struct{}
+	found map[int64]T
+	err   error
+}
+
+func newBatchLoader[T any](ctx context.Context, fetch func(context.Context, []int64) (map[int64]T, error)) *batchLoader[T] {
+	return &batchLoader[T]{ctx: ctx, fetch: fetch, fetched: map[int64]*batch[T]{}}
+}
+
+// newCarLoader batches car lookups into BatchGetCars calls
+func newCarLoader(ctx context.Context, client carspb.CarServiceClient) *batchLoader[*carspb.Car] {
+	return newBatchLoader(ctx, func(ctx context.Context, ids []int64) (map[int64]*carspb.Car, error) {
+		res, err := client.BatchGetCars(ctx, &carspb.BatchGetCarsRequest{Ids: ids})
+		if err != nil {
+			return nil, err
+		}
+		cars := make(map[int64]*carspb.Car, len(res.GetResult()))
+		for _, car := range res.GetResult() {
+			cars[car.GetId()] = car
+		}
+		return cars, nil
+	})
+}
+
+// newListingLoader batches listing lookups into BatchGetListings calls
+func newListingLoader(ctx context.Context, client carspb.CarServiceClient) *batchLoader[*carspb.Listing] {
+	return newBatchLoader(ctx, func(ctx context.Context, ids []int64) (map[int64]*carspb.Listing, error) {
+		res, err := client.BatchGetListings(ctx, &carspb.BatchGetListingsRequest{CarIds: ids})
+		if err != nil {
+			return nil, err
+		}
+		listings := make(map[int64]*carspb.Listing, len(res.GetResult()))
+		for _, listing := range res.GetResult() {
+			listings[listing.GetCarId()] = listing
+		}
+		return listings, nil
+	})
+}
+
+// load returns what is found for id, or the zero value when nothing is
+func (l *batchLoader[T]) load(id int64) (T, error) {
+	found, err := l.loadMany([]int64{id})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return found[0], nil
+}
+
+// loadMany returns what is found for ids, with the zero value for those
+// nothing is found for
+func (l *batchLoader[T]) loadMany(ids []int64) ([]T, error) {
+	batches := make([]*batch[T], len(ids))
+	l.mu.Lock()
+	for i, id := range ids {
+		b, ok := l.fetched[id]
+		if !ok {
+			b = l.current()
+			b.ids = append(b.ids, id)
+			l.fetched[id] = b
+			if len(b.ids) >= maxBatch {
+				l.batch = nil
+				go l.dispatch(b)
+			}
+		}
+		batches[i] = b
+	}
+	l.mu.Unlock()
+
+	found := make([]T, len(ids))
+	for i, b := range batches {
+		<-b.done
+		if b.err != nil {
+			return nil, b.err
+		}
+		found[i] = b.found[ids[i]]
+	}
+	return found, nil
+}
+
+// current returns the batch being collected, starting one if needed.
+// l.mu must be held.
+func (l *batchLoader[T]) current() *batch[T] {
+	if l.batch == nil {
+		b := &batch[T]{done: make(chan struct{})}
+		l.batch = b
+		time.AfterFunc(batchWait, func() { l.dispatch(b) })
+	}
+	return l.batch
+}
+
+// dispatch stops b collecting ids and fetches them, once
+func (l *batchLoader[T]) dispatch(b *batch[T]) {
+	l.mu.Lock()
+	if l.batch == b {
+		l.batch = nil
+	}
+	l.mu.Unlock()
+	b.once.Do(func() {
+		defer close(b.done)
+		b.found, b.err = l.fetch(l.ctx, b.ids)
+	})
+}
+
+/*
+inventoryLoader lists the inventory at most once per query, however
+many fields need it
+*/
+type inventoryLoader struct {
+	ctx    context.Context
+	client carspb.CarServiceClient
+
+	once sync.Once
+	cars []*carspb.Car
+	err  error
+}
+
+func (l *inventoryLoader) load() ([]*carspb.Car, error) {
+	l.once.Do(func() {
+		var res *carspb.CarWithDeadlineResponse
+		res, l.err = l.client.CarWithDeadline(l.ctx, &carspb.CarWithDeadlineRequest{})
+		l.cars = res.GetResult()
+	})
+	return l.cars, l.err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carservice"
+	"github.com/simrie/go-grpc-car-service/cars/catalog"
+	"github.com/simrie/go-grpc-car-service/cars/config"
+	"github.com/simrie/go-grpc-car-service/cars/data"
+	"github.com/simrie/go-grpc-car-service/cars/metrics"
+)
+
+// embeddedTarget is the address the embedded microservice is dialled at;
+// the name is never resolved
+const embeddedTarget = "passthrough:///embedded"
+
+/*
+embeddedMicroservice is CarService running inside the REST service,
+reached over an in-memory bufconn listener instead of TCP, so one
+process serves everything
+*/
+type embeddedMicroservice struct {
+	server *grpc.Server
+	health *health.Server
+	lis    *bufconn.Listener
+}
+
+/*
+startEmbeddedMicroservice starts CarService with the microservice
+settings in cfg.  Its Watch streams and health checks end when ctx is
+done.
+*/
+func startEmbeddedMicroservice(ctx context.Context, cfg config.Microservice) (*embeddedMicroservice, error) {
+	names, err := catalog.Load(cfg.Catalog.File)
+	if err != nil {
+		return nil, err
+	}
+	metrics.RegisterMicroservice()
+	m := &embeddedMicroservice{
+		// only this process can dial it, so the identities it is sent
+		// are the REST service's own
+		server: grpc.NewServer(carservice.ServerOptions(cfg.EnforceRoles, auth.AnyCaller)...),
+		health: health.NewServer(),
+		lis:    bufconn.Listen(1 << 20),
+	}
+	carservice.Register(m.server, carservice.New(ctx.Done(), names, cfg.Catalog.RejectUnknown), m.health)
+	go carservice.WatchRepositoryHealth(ctx, m.health, cfg.HealthInterval)
+	go func() {
+		if err := m.server.Serve(m.lis); err != nil {
+			log.Printf("embedded microservice stopped: %v", err)
+		}
+	}()
+	return m, nil
+}
+
+// dial connects to the embedded microservice, whatever the address
+func (m *embeddedMicroservice) dial(ctx context.Context, _ string) (net.Conn, error) {
+	return m.lis.DialContext(ctx)
+}
+
+/*
+stop drains the calls to the embedded microservice for up to grace and
+closes the repository.  Its health service should already report
+NOT_SERVING.
+*/
+func (m *embeddedMicroservice) stop(grace time.Duration) {
+	carservice.GracefulStop(m.server, grace)
+	if err := data.Close(); err != nil {
+		log.Printf("closing repository: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/config"
+)
+
+func TestEmbeddedMicroservice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m, err := startEmbeddedMicroservice(ctx, config.Default().Microservice)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer m.server.Stop()
+
+	conn, err := dialMicroservice(embeddedTarget, insecure.NewCredentials(), grpc.WithContextDialer(m.dial))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer conn.Close()
+	router, err := newRouter(ctx, carspb.NewCarServiceClient(conn), healthpb.NewHealthClient(conn), config.Default().Gateway)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+
+	// the repository health is reported as soon as it has been checked
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Failed! /readyz answered %d, want 200", rec.Code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/car/2", nil))
+	if want := `{"id":"2","make":"Toyota","model":"Camry"}`; rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != want {
+		t.Errorf("Failed! got %d %s, want 200 %s", rec.Code, rec.Body.String(), want)
+	}
+}
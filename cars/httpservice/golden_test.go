@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/config"
+)
+
+// Run with -update to rewrite the golden files after an intended change
+// to the public JSON
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// fakeCarClient answers calls from fixed data without a microservice
+type fakeCarClient struct {
+	carspb.CarServiceClient
+	cars []*carspb.Car
+	err  error
+}
+
+func (c *fakeCarClient) Car(ctx context.Context, in *carspb.CarRequest, opts ...grpc.CallOption) (*carspb.CarResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	for _, car := range c.cars {
+		if car.Id == in.Id {
+			return &carspb.CarResponse{Result: car}, nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "no such car")
+}
+
+func (c *fakeCarClient) CarWithDeadline(ctx context.Context, in *carspb.CarWithDeadlineRequest, opts ...grpc.CallOption) (*carspb.CarWithDeadlineResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &carspb.CarWithDeadlineResponse{Result: c.cars}, nil
+}
+
+var goldenCars = []*carspb.Car{
+	{Id: 1, Make: "Ford", Model: "F10", AcquisitionCostCents: 2850000, MarginCents: 310000},
+	{Id: 2, Make: "Toyota", Model: "Camry", AcquisitionCostCents: 1920000, MarginCents: 0},
+}
+
+func TestPublicJSON(t *testing.T) {
+	tests := []struct {
+		golden string
+		path   string
+		accept string
+		roles  []auth.Role
+		err    error
+	}{
+		{"car_anonymous", "/car/1", "", nil, nil},
+		{"car_sales", "/car/1", "", []auth.Role{auth.Sales}, nil},
+		{"car_manager", "/car/1", "", []auth.Role{auth.Manager}, nil},
+		{"cars_viewer", "/cars", "", []auth.Role{auth.Viewer}, nil},
+		{"cars_admin", "/cars", "", []auth.Role{auth.Admin}, nil},
+		{"cars_empty", "/cars", "", nil, nil},
+		{"car_bad_id", "/car/abc", "", nil, nil},
+		{"cars_unavailable", "/cars", "", nil, status.Error(codes.Unavailable, "down")},
+		{"cars_sales_csv", "/cars", "text/csv", []auth.Role{auth.Sales}, nil},
+		{"car_viewer_csv", "/car/1?format=csv", "", []auth.Role{auth.Viewer}, nil},
+		{"cars_manager_xml", "/cars", "application/xml", []auth.Role{auth.Manager}, nil},
+		{"car_viewer_xml", "/car/2", "text/xml", []auth.Role{auth.Viewer}, nil},
+		{"cars_unavailable_xml", "/cars?format=xml", "", nil, status.Error(codes.Unavailable, "down")},
+		{"cars_not_acceptable", "/cars", "image/png", nil, nil},
+	}
+	for _, tt := range tests {
+		client := &fakeCarClient{cars: goldenCars, err: tt.err}
+		if tt.golden == "cars_empty" {
+			client.cars = nil
+		}
+		router, err := newRouter(context.Background(), client, &fakeHealthClient{}, config.Default().Gateway)
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if tt.roles != nil {
+			req = req.WithContext(auth.NewContext(req.Context(), auth.Identity{Subject: "test", Roles: tt.roles}))
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		got := []byte(http.StatusText(rec.Code) + "\n" + rec.Header().Get("content-type") + "\n")
+		got = append(got, rec.Body.Bytes()...)
+		path := filepath.Join("testdata", tt.golden+".golden")
+		if *update {
+			if err := os.WriteFile(path, got, 0o644); err != nil {
+				t.Fatalf("Failed! %v", err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed! %v (run go test -update to create it)", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Failed! %s: public JSON changed\ngot:\n%s\nwant:\n%s", tt.golden, got, want)
+		}
+	}
+}
+
+func TestProtobufFormat(t *testing.T) {
+	router, err := newRouter(context.Background(), &fakeCarClient{cars: goldenCars}, &fakeHealthClient{}, config.Default().Gateway)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	req := httptest.NewRequest("GET", "/cars", nil)
+	req.Header.Set("Accept", "application/x-protobuf")
+	req = req.WithContext(auth.NewContext(req.Context(), auth.Identity{Subject: "test", Roles: []auth.Role{auth.Sales}}))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("content-type"); ct != "application/x-protobuf" {
+		t.Errorf("Failed! content-type %q", ct)
+	}
+	var res carspb.CarWithDeadlineResponse
+	if err := proto.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if len(res.Result) != 2 || res.Result[0].Make != "Ford" {
+		t.Fatalf("Failed! unexpected response %v", &res)
+	}
+	if res.Result[0].AcquisitionCostCents != 0 || res.Result[0].MarginCents != 300000 {
+		t.Errorf("Failed! sales should see only a rounded margin, got %v", res.Result[0])
+	}
+}
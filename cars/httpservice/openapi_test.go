@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/simrie/go-grpc-car-service/cars/config"
+	"github.com/simrie/go-grpc-car-service/cars/openapi"
+)
+
+// undocumented lists routes that are not part of the API itself
+var undocumented = map[string]bool{
+	"GET /docs/": true,
+}
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openapi.Spec, &spec); err != nil {
+		t.Fatalf("Failed! the OpenAPI document is not valid JSON: %v", err)
+	}
+	documented := map[string]bool{}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	router, err := newRouter(context.Background(), &fakeCarClient{}, &fakeHealthClient{}, config.Default().Gateway)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	registered := map[string]bool{}
+	err = router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			// subrouters have no path of their own
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			t.Errorf("Failed! route %s does not restrict its methods", path)
+			return nil
+		}
+		for _, method := range methods {
+			registered[method+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+
+	var missing, stale []string
+	for route := range registered {
+		if !documented[route] && !undocumented[route] {
+			missing = append(missing, route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			stale = append(stale, route)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	if len(missing) > 0 {
+		t.Errorf("Failed! routes missing from openapi.json: %v", missing)
+	}
+	if len(stale) > 0 {
+		t.Errorf("Failed! openapi.json describes routes that are not registered: %v", stale)
+	}
+}
+
+func TestDocsServed(t *testing.T) {
+	router, err := newRouter(context.Background(), &fakeCarClient{}, &fakeHealthClient{}, config.Default().Gateway)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	for _, path := range []string{"/openapi.json", "/docs/", "/docs/swagger-initializer.js", "/docs/swagger-ui-bundle.js"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("Failed! GET %s answered %d", path, rec.Code)
+		}
+		if path == "/docs/swagger-initializer.js" && !strings.Contains(rec.Body.String(), `"/openapi.json"`) {
+			t.Errorf("Failed! Swagger UI does not load /openapi.json")
+		}
+	}
+}
@@ -2,174 +2,400 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
+	"github.com/simrie/go-grpc-car-service/cars/apikey"
+	"github.com/simrie/go-grpc-car-service/cars/auth"
 	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/config"
+	"github.com/simrie/go-grpc-car-service/cars/gateway"
+	"github.com/simrie/go-grpc-car-service/cars/graphql"
+	"github.com/simrie/go-grpc-car-service/cars/metrics"
+	"github.com/simrie/go-grpc-car-service/cars/openapi"
+	"github.com/simrie/go-grpc-car-service/cars/tlsconfig"
+	"github.com/simrie/go-grpc-car-service/cars/tracing"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
+	cfg, err := config.Load("rest_server", os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		cfg.Write(os.Stdout)
+		return
+	}
+	requestTimeout = cfg.Gateway.RequestTimeout
+
 	fmt.Println("REST service starting.")
 
-	// Create a connection object to the microservice
-	clientConnectionObject, err := grpc.Dial("localhost:50051", grpc.WithInsecure())
+	// SIGINT or SIGTERM starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Spans are always created so trace context reaches the microservice,
+	// but they are only printed when asked for
+	var traceOut io.Writer = io.Discard
+	if cfg.Trace {
+		traceOut = os.Stdout
+	}
+	tp, err := tracing.NewStdoutProvider("rest_server", traceOut)
 	if err != nil {
-		log.Fatalf("Dial error %v", err)
+		log.Fatalf("cannot create tracer provider: %v", err)
 	}
 
-	defer clientConnectionObject.Close()
+	// Create a connection object to the microservice.  No connection is
+	// made until the first call, so the microservice may start later;
+	// calls made while it is down fail fast and are answered with a 503.
+	creds, err := microserviceCredentials(cfg.Gateway.MicroserviceTLS, cfg.Gateway.MicroserviceAddr)
+	if err != nil {
+		log.Fatalf("cannot set up TLS to the microservice: %v", err)
+	}
+	target := cfg.Gateway.MicroserviceAddr
+	var dialOpts []grpc.DialOption
+	var embedded *embeddedMicroservice
+	if cfg.Gateway.EmbedMicroservice {
+		// Single binary: the microservice runs here, reached in memory
+		if embedded, err = startEmbeddedMicroservice(ctx, cfg.Microservice); err != nil {
+			log.Fatalf("cannot start the embedded microservice: %v", err)
+		}
+		target = embeddedTarget
+		dialOpts = append(dialOpts, grpc.WithContextDialer(embedded.dial))
+	}
+	clientConnectionObject, err := dialMicroservice(target, creds, dialOpts...)
+	if err != nil {
+		log.Fatalf("Dial error %v", err)
+	}
 
 	// This is the client object for making microservice gRPC calls
 	client := carspb.NewCarServiceClient(clientConnectionObject)
 
-	fmt.Printf("Created the gRPCclient %f", client)
-	doUnary(client)
-
-	doUnaryWithDeadline(client, 5*time.Second) // should complete
-	//doUnaryWithDeadline(client, 1*time.Millisecond) // should timeout
+	metrics.RegisterGateway()
 
-	router := mux.NewRouter()
-	router.HandleFunc("/car/microservice", MicroserviceHandlerSelector(client, "car/microservice")).Methods("GET")
-	router.HandleFunc("/cars", MicroserviceHandlerSelector(client, "cars")).Methods("GET")
-	router.HandleFunc("/car/{id}", MicroserviceHandlerSelector(client, "car/{id}")).Methods("GET")
-	http.ListenAndServe(":8080", router)
-
-}
-
-func doUnary(c carspb.CarServiceClient) {
-	fmt.Println("\n...Starting to do a Unary RPC...")
-	req := &carspb.CarRequest{
-		Id: int64(2),
+	// Car routes need a bearer token when authentication is configured;
+	// the caller's identity is forwarded to the microservice
+	var apiMiddleware []mux.MiddlewareFunc
+	authCfg := cfg.Gateway.Auth
+	// API keys are checked first; requests without one fall through to
+	// bearer token authentication when that is configured too
+	var keys *apikey.Store
+	if keysCfg := cfg.Gateway.APIKeys; keysCfg.KeysFile != "" {
+		keys, err = apikey.Load(keysCfg.KeysFile, keysCfg.UsageFile)
+		if err != nil {
+			log.Fatalf("cannot set up API keys: %v", err)
+		}
+		apiMiddleware = append(apiMiddleware, keys.Middleware(authCfg.JWKSFile != ""))
+		go keys.Run(ctx, keysCfg.FlushInterval)
+	}
+	if authCfg.JWKSFile != "" {
+		verifier, err := auth.NewVerifier(authCfg.JWKSFile, authCfg.Issuer, authCfg.Audience)
+		if err != nil {
+			log.Fatalf("cannot set up authentication: %v", err)
+		}
+		apiMiddleware = append(apiMiddleware, verifier.Middleware)
 	}
-	// context.Background() initializes a new, non-nil context
-	// to be passed between server APIs
-	res, err := c.Car(context.Background(), req)
+	router, err := newRouter(ctx, client, healthpb.NewHealthClient(clientConnectionObject), cfg.Gateway, apiMiddleware...)
 	if err != nil {
-		log.Fatalf("\nerror while calling Car RPC: %v", err)
+		log.Fatalf("cannot set up routes: %v", err)
 	}
-	log.Printf("\nResponse from Car: %v", res.Result)
-}
 
-func doUnaryWithDeadline(c carspb.CarServiceClient, timeout time.Duration) {
-	fmt.Println("\n...Starting to do a Unary With Deadline RPC...")
-	req := &carspb.CarWithDeadlineRequest{
-		Id: int64(0),
+	srv := &http.Server{Addr: cfg.Gateway.ListenAddr, Handler: router}
+	var proxy *grpc.Server
+	var proxyCalls inflightCalls
+	stopProxy := make(chan struct{})
+	if cfg.Gateway.ServeGRPC {
+		// gRPC calls on the REST listener go on to the microservice,
+		// authenticated like the car routes
+		proxy = gateway.NewProxy(clientConnectionObject, stopProxy)
+		grpcHandler := proxyCalls.track(proxy)
+		for i := len(apiMiddleware) - 1; i >= 0; i-- {
+			grpcHandler = apiMiddleware[i](grpcHandler)
+		}
+		// measured and traced like the routes, by gRPC method
+		grpcHandler = metrics.HTTPMiddleware(tracing.HTTPMiddleware(grpcHandler))
+		srv.Handler = gateway.Multiplex(grpcHandler, router)
+		if !cfg.Gateway.TLS.Enabled() {
+			// gRPC needs HTTP/2, which without TLS is cleartext h2c
+			h2s := &http2.Server{}
+			if err := http2.ConfigureServer(srv, h2s); err != nil {
+				log.Fatalf("cannot set up HTTP/2: %v", err)
+			}
+			srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+		}
 	}
-	// We initialize the context with the a timeout
-	// to be passed between server APIs
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-
-	defer cancel()
+	if tlsCfg := cfg.Gateway.TLS; tlsCfg.Enabled() {
+		srv.TLSConfig, err = tlsconfig.ServerConfig(tlsconfig.Files{
+			CertFile: tlsCfg.CertFile,
+			KeyFile:  tlsCfg.KeyFile,
+		})
+		if err != nil {
+			log.Fatalf("cannot set up TLS: %v", err)
+		}
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.Gateway.TLS.Enabled() {
+			// the certificate comes from srv.TLSConfig so it can be reloaded
+			serveErr <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		serveErr <- srv.ListenAndServe()
+	}()
 
-	res, err := c.CarWithDeadline(ctx, req)
-	if err != nil {
+	select {
+	case err := <-serveErr:
+		log.Fatalf("Failed to serve %v", err)
+	case <-ctx.Done():
+	}
+	stop()
+	log.Printf("REST service shutting down, draining requests for up to %v", cfg.GracePeriod)
+	if embedded != nil {
+		// /readyz fails from now on so load balancers stop sending work
+		embedded.health.Shutdown()
+	}
 
-		statusErr, ok := status.FromError(err)
-		if ok {
-			// this is a gRPC error
-			if statusErr.Code() == codes.DeadlineExceeded {
-				fmt.Println("Timeout was hit.  Deadline exceeded")
-			} else {
-				log.Fatalf("Unexpected gRPC status error: %v", statusErr)
-			}
+	// Stop accepting connections and wait for in-flight requests
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.GracePeriod)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Printf("grace period expired, closing remaining connections: %v", err)
+		srv.Close()
+	}
+	if proxy != nil {
+		// h2c connections are taken over from srv, so the calls proxied
+		// on them are waited for here
+		if !proxyCalls.drain(drainCtx) {
+			log.Printf("grace period expired, cancelling remaining gRPC calls")
+			close(stopProxy)
+			endCtx, cancelEnd := context.WithTimeout(context.Background(), time.Second)
+			proxyCalls.drain(endCtx)
+			cancelEnd()
+		}
+		proxy.Stop()
+	}
 
-		} else {
-			// regular error
-			log.Fatalf("\nerror while calling Cars RPC: %v", err)
+	// Requests are drained, so the usage counts are final
+	if keys != nil {
+		if err := keys.Usage().Flush(); err != nil {
+			log.Printf("saving API key usage: %v", err)
 		}
-		// return on any err so we do not try to print a non-existant res.Result
-		return
 	}
-	log.Printf("\nResponse from Cars: %v", res.Result)
+
+	// Requests are drained, so the microservice connection can go
+	if err := clientConnectionObject.Close(); err != nil {
+		log.Printf("closing microservice connection: %v", err)
+	}
+	if embedded != nil {
+		embedded.stop(cfg.GracePeriod)
+	}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := tp.Shutdown(flushCtx); err != nil {
+		log.Printf("flushing trace spans: %v", err)
+	}
+	log.Printf("REST service stopped.")
 }
 
 /*
-	GetCarMicroserviceHandler sends a reqeust id to the gRPC service
-	and returns a Car item with that id if found
+newRouter returns the REST service's routes.  apiMiddleware authenticates
+the car routes; the health, metrics and documentation routes stay open.
+Every route must be described in the OpenAPI document.
 */
-func GetCarMicroserviceHandler(c carspb.CarServiceClient, response http.ResponseWriter, request *http.Request) {
-	response.Header().Set("content-type", "application/json")
-	vars := mux.Vars(request)
-	idParam, ok := vars["id"]
-	if !ok {
-		response.WriteHeader(400)
-		response.Write([]byte(`{ "message": "id parameter not defined" }`))
-		return
-	}
-	//convert idParam from string to int64
-	id, _ := strconv.Atoi(idParam)
-	if !ok {
-		response.WriteHeader(400)
-		response.Write([]byte(`{ "message": "id parameter is not an integer" }`))
-		return
-	}
+func newRouter(ctx context.Context, client carspb.CarServiceClient, health healthpb.HealthClient, gw config.Gateway, apiMiddleware ...mux.MiddlewareFunc) (*mux.Router, error) {
+	router := mux.NewRouter()
+	router.Use(metrics.HTTPMiddleware, tracing.HTTPMiddleware)
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
+	router.HandleFunc("/healthz", LivenessHandler).Methods("GET")
+	router.HandleFunc("/readyz", ReadinessHandler(health, gw.ReadinessTimeout)).Methods("GET")
+	router.Handle("/openapi.json", openapi.Handler()).Methods("GET")
+	router.Handle("/docs", http.RedirectHandler("/docs/", http.StatusMovedPermanently)).Methods("GET")
+	router.PathPrefix("/docs/").Handler(http.StripPrefix("/docs", openapi.DocsHandler("/openapi.json"))).Methods("GET")
 
-	carReq := carspb.CarRequest{
-		Id: int64(id),
+	// Car routes come from the google.api.http annotations in cars.proto.
+	// Each is registered on its own so metrics are labelled by route.
+	transcoder, err := gateway.NewHandler(client, requestTimeout)
+	if err != nil {
+		return nil, err
 	}
-	// context.Background() initializes a new, non-nil context
-	// to be passed between server APIs
-	res, err := c.Car(context.Background(), &carReq)
+	routes, err := gateway.Routes()
 	if err != nil {
-		log.Printf("\nerror while calling Car RPC: %v", err)
-		status := http.StatusBadRequest
-		response.WriteHeader(status)
-		response.Write([]byte(`{ "message": "error retrieving Cars information"}`))
-		return
+		return nil, err
+	}
+	api := router.NewRoute().Subrouter()
+	api.Use(apiMiddleware...)
+	// cost fields are only shown as far as the microservice reports it
+	// filtered them for the caller, which it does not when it did not
+	// accept the forwarded identity
+	api.Use(visibility.Middleware)
+	// Routes whose bodies are not a message are written by hand.  Event
+	// streams and sockets end when ctx is done so shutdown is not held up.
+	api.Handle("/cars/events", gateway.EventsHandler(ctx, client, gw.EventsHeartbeat)).Methods("GET")
+	api.Handle("/cars/socket", gateway.SocketHandler(ctx, client, requestTimeout, gw.EventsHeartbeat)).Methods("GET")
+	api.Handle("/graphql", graphql.Handler(client, requestTimeout)).Methods("GET", "POST")
+	api.Handle("/cars:import", gateway.ImportHandler(client, requestTimeout)).Methods("POST")
+	api.Handle("/cars:export", gateway.ExportHandler(client)).Methods("GET")
+	api.HandleFunc("/car/microservice", HandlerPlaceholder).Methods("GET")
+	for _, route := range routes {
+		api.Handle(route.Path, transcoder).Methods(route.Method)
 	}
+	return router, nil
+}
 
-	json.NewEncoder(response).Encode(res)
+/*
+inflightCalls counts the requests in flight through the handlers it
+tracks.  Once it is drained new requests are refused, as gRPC calls that
+are UNAVAILABLE, so the count only falls.
+*/
+type inflightCalls struct {
+	mu       sync.Mutex
+	calls    int
+	draining bool
+	// idle is closed once draining with no calls left
+	idle chan struct{}
 }
 
-func GetCarsMicroserviceHandler(c carspb.CarServiceClient, response http.ResponseWriter, request *http.Request) {
-	response.Header().Set("content-type", "application/json")
+// track counts the requests to handler
+func (f *inflightCalls) track(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.start() {
+			// a trailers-only gRPC response
+			w.Header().Set("content-type", "application/grpc")
+			w.Header().Set("grpc-status", strconv.Itoa(int(codes.Unavailable)))
+			w.Header().Set("grpc-message", "the REST service is shutting down")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		defer f.finish()
+		handler.ServeHTTP(w, r)
+	})
+}
 
-	carReq := &carspb.CarWithDeadlineRequest{
-		Id: int64(0),
+func (f *inflightCalls) start() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.draining {
+		return false
 	}
-	timeout := 4 * time.Second
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-
-	defer cancel()
+	f.calls++
+	return true
+}
 
-	res, err := c.CarWithDeadline(ctx, carReq)
-	if err != nil {
+func (f *inflightCalls) finish() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls--
+	if f.draining && f.calls == 0 {
+		close(f.idle)
+	}
+}
 
-		statusErr, ok := status.FromError(err)
-		humanMsg := ""
-		if ok {
-			// this is a gRPC error
-			if statusErr.Code() == codes.DeadlineExceeded {
-				humanMsg = "Timeout was hit.  Deadline exceeded."
-				log.Printf("%s: %v", humanMsg, statusErr)
-			} else {
-				humanMsg = "Unexpected status error"
-				log.Printf("%s: %v", "Unexpected gRPC status error from Cars service", statusErr)
-			}
+/*
+drain refuses new calls and waits for those in flight to finish,
+reporting false if ctx is done first.  It may be called again to wait
+some more.
+*/
+func (f *inflightCalls) drain(ctx context.Context) bool {
+	f.mu.Lock()
+	if !f.draining {
+		f.draining = true
+		f.idle = make(chan struct{})
+		if f.calls == 0 {
+			close(f.idle)
+		}
+	}
+	idle := f.idle
+	f.mu.Unlock()
+	select {
+	case <-idle:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
-		} else {
-			// regular error
-			humanMsg = "error retrieving Cars information"
-			log.Printf("\nerror while calling Cars RPC: %v", err)
+/*
+microserviceCredentials returns the transport credentials for reaching
+the microservice at addr: TLS, with a client certificate for mutual TLS
+when one is configured, or plaintext when TLS is not configured.  The
+server is checked against the host in addr unless t names another.
+*/
+func microserviceCredentials(t config.TLS, addr string) (credentials.TransportCredentials, error) {
+	if !t.Enabled() {
+		return insecure.NewCredentials(), nil
+	}
+	serverName := t.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
 		}
-		// return on any err so we do not try to print a non-existant res.Result
-		response.WriteHeader(400)
-		msg := fmt.Sprintf(`{ "message": %s }`, humanMsg)
-		response.Write([]byte(msg))
-		return
+		serverName = host
+	}
+	clientTLS, err := tlsconfig.ClientConfig(tlsconfig.Files{
+		CertFile: t.CertFile,
+		KeyFile:  t.KeyFile,
+		CAFile:   t.CAFile,
+	}, serverName)
+	if err != nil {
+		return nil, err
 	}
+	return credentials.NewTLS(clientTLS), nil
+}
 
-	json.NewEncoder(response).Encode(res)
+/*
+dialMicroservice creates a lazily connected client connection to the gRPC
+microservice at target.  While the microservice is unreachable the
+connection keeps retrying with exponential backoff, and recovers on its
+own once the microservice comes up.  opts are added to the defaults.
+*/
+func dialMicroservice(target string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	return grpc.NewClient(target, append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backendBackoff,
+			MinConnectTimeout: 5 * time.Second,
+		}),
+		grpc.WithChainUnaryInterceptor(tracing.UnaryClientInterceptor, auth.UnaryClientInterceptor, visibility.UnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(tracing.StreamClientInterceptor, auth.StreamClientInterceptor, visibility.StreamClientInterceptor),
+	}, opts...)...)
+}
+
+// requestTimeout is the deadline for calls to the microservice
+var requestTimeout = 4 * time.Second
+
+// backendBackoff paces reconnection attempts to the microservice
+var backendBackoff = backoff.Config{
+	BaseDelay:  500 * time.Millisecond,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+	MaxDelay:   10 * time.Second,
 }
 
 /*
@@ -180,23 +406,39 @@ func HandlerPlaceholder(response http.ResponseWriter, request *http.Request) {
 }
 
 /*
-MicroserviceHandlerSelector ties the api endpoint to a function
+LivenessHandler reports that the REST service is running
 */
-func MicroserviceHandlerSelector(c carspb.CarServiceClient, endpoint string) http.HandlerFunc {
-	var fn http.HandlerFunc
-	switch endpoint {
-	case "cars":
-		fn = func(w http.ResponseWriter, r *http.Request) {
-			GetCarsMicroserviceHandler(c, w, r)
-		}
-	case "car/{id}":
-		fn = func(w http.ResponseWriter, r *http.Request) {
-			GetCarMicroserviceHandler(c, w, r)
+func LivenessHandler(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("content-type", "application/json")
+	response.Write([]byte(`{ "status": "ok" }`))
+}
+
+/*
+ReadinessHandler reports whether the REST service can serve car requests,
+which it can only do while the gRPC microservice reports itself healthy
+*/
+func ReadinessHandler(c healthpb.HealthClient, timeout time.Duration) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("content-type", "application/json")
+
+		ctx, cancel := context.WithTimeout(request.Context(), timeout)
+		defer cancel()
+
+		res, err := c.Check(ctx, &healthpb.HealthCheckRequest{
+			Service: carspb.CarService_ServiceDesc.ServiceName,
+		})
+		if err != nil {
+			log.Printf("readiness check failed: %v", err)
+			response.WriteHeader(http.StatusServiceUnavailable)
+			response.Write([]byte(`{ "status": "unavailable", "message": "car microservice is unreachable" }`))
+			return
 		}
-	default:
-		fn = func(w http.ResponseWriter, r *http.Request) {
-			HandlerPlaceholder(w, r)
+		if res.Status != healthpb.HealthCheckResponse_SERVING {
+			response.WriteHeader(http.StatusServiceUnavailable)
+			msg := fmt.Sprintf(`{ "status": "unavailable", "message": "car microservice is %s" }`, res.Status)
+			response.Write([]byte(msg))
+			return
 		}
+		response.Write([]byte(`{ "status": "ok" }`))
 	}
-	return http.HandlerFunc(fn)
 }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carservice"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/catalog"
+	"github.com/simrie/go-grpc-car-service/cars/config"
+)
+
+// fakeCarServer answers every CarWithDeadline call with an empty list
+type fakeCarServer struct {
+	carspb.UnimplementedCarServiceServer
+}
+
+func (*fakeCarServer) CarWithDeadline(ctx context.Context, req *carspb.CarWithDeadlineRequest) (*carspb.CarWithDeadlineResponse, error) {
+	return &carspb.CarWithDeadlineResponse{}, nil
+}
+
+// fakeHealthClient answers health checks with a fixed response
+type fakeHealthClient struct {
+	healthpb.HealthClient
+	status healthpb.HealthCheckResponse_ServingStatus
+	err    error
+}
+
+func (c *fakeHealthClient) Check(ctx context.Context, in *healthpb.HealthCheckRequest, opts ...grpc.CallOption) (*healthpb.HealthCheckResponse, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &healthpb.HealthCheckResponse{Status: c.status}, nil
+}
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *fakeHealthClient
+		want   int
+	}{
+		{"serving", &fakeHealthClient{status: healthpb.HealthCheckResponse_SERVING}, http.StatusOK},
+		{"not serving", &fakeHealthClient{status: healthpb.HealthCheckResponse_NOT_SERVING}, http.StatusServiceUnavailable},
+		{"unreachable", &fakeHealthClient{err: errors.New("connection refused")}, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		ReadinessHandler(tt.client, time.Second)(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code != tt.want {
+			t.Errorf("Failed! %s: expected %d, got %d", tt.name, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestPlaceholderRoute(t *testing.T) {
+	router, err := newRouter(context.Background(), &fakeCarClient{}, &fakeHealthClient{}, config.Default().Gateway)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/car/microservice", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("Failed! expected an empty 200 from the placeholder, got %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestSplitDeploymentWithoutMutualTLS(t *testing.T) {
+	names, err := catalog.Load("")
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	manager := auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}}
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), manager)))
+		})
+	}
+
+	tests := []struct {
+		name  string
+		trust auth.Trust
+		car   string
+		cost  string
+		hold  codes.Code
+	}{
+		// the microservice only believes identities sent with a verified
+		// client certificate, so the manager's is dropped
+		{"without a client certificate", auth.VerifiedClient,
+			`{"id":"1","make":"Ford","model":"F10"}`,
+			`{"data":{"car":{"acquisitionCostCents":null,"marginCents":null}}}`,
+			codes.Unauthenticated},
+		{"trusted", auth.AnyCaller,
+			`{"id":"1","make":"Ford","model":"F10","acquisition_cost_cents":"2850000","margin_cents":"310000"}`,
+			`{"data":{"car":{"acquisitionCostCents":"2850000","marginCents":"310000"}}}`,
+			codes.OK},
+	}
+	for _, tt := range tests {
+		lis := bufconn.Listen(1 << 20)
+		s := grpc.NewServer(carservice.ServerOptions(false, tt.trust)...)
+		carspb.RegisterCarServiceServer(s, carservice.New(nil, names, false))
+		go s.Serve(lis)
+		conn, err := dialMicroservice("passthrough:///microservice", insecure.NewCredentials(),
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }))
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		client := carspb.NewCarServiceClient(conn)
+		router, err := newRouter(context.Background(), client, &fakeHealthClient{}, config.Default().Gateway, mux.MiddlewareFunc(authenticate))
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", "/car/1", nil))
+		if got := strings.TrimSpace(rec.Body.String()); rec.Code != http.StatusOK || got != tt.car {
+			t.Errorf("Failed! %s: got %d %s, want %s", tt.name, rec.Code, got, tt.car)
+		}
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ car(id: \"1\") { acquisitionCostCents marginCents } }"}`)))
+		if got := strings.TrimSpace(rec.Body.String()); got != tt.cost {
+			t.Errorf("Failed! %s: got GraphQL %s, want %s", tt.name, got, tt.cost)
+		}
+
+		// a hold placed without the caller's identity could be released
+		// by anyone
+		res, err := client.PlaceHold(auth.NewContext(context.Background(), manager), &carspb.PlaceHoldRequest{CarId: 1})
+		if status.Code(err) != tt.hold {
+			t.Errorf("Failed! %s: placing a hold got %v, want %v", tt.name, err, tt.hold)
+		}
+		if err == nil {
+			client.ReleaseHold(auth.NewContext(context.Background(), manager), &carspb.ReleaseHoldRequest{HoldId: res.Result.Id})
+		}
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestGatewayToleratesLateBackend(t *testing.T) {
+	// reserve an address for a microservice that is not running yet
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	conn, err := dialMicroservice(addr, insecure.NewCredentials())
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer conn.Close()
+	router, err := newRouter(context.Background(), carspb.NewCarServiceClient(conn), &fakeHealthClient{}, config.Default().Gateway)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	handler := router.ServeHTTP
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/cars", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Failed! expected 503 while the microservice is down, got %d", rec.Code)
+	}
+
+	lis, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	s := grpc.NewServer()
+	carspb.RegisterCarServiceServer(s, &fakeCarServer{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		rec = httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/cars", nil))
+		if rec.Code == http.StatusOK {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Failed! gateway did not recover once the microservice started, last status %d", rec.Code)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestInflightCallsDrain(t *testing.T) {
+	var calls inflightCalls
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := calls.track(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/cars.CarService/Car", nil))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if calls.drain(ctx) {
+		t.Fatalf("Failed! drain finished with a call in flight")
+	}
+
+	// calls arriving once draining has started are refused
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/cars.CarService/Car", nil))
+	if got := rec.Header().Get("grpc-status"); got != "14" {
+		t.Errorf("Failed! a call while draining should be UNAVAILABLE, got grpc-status %q", got)
+	}
+
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !calls.drain(ctx) {
+		t.Errorf("Failed! drain did not finish once the call ended")
+	}
+}
@@ -0,0 +1,251 @@
+/*
+Package importer reads vehicle spreadsheets, as CSV or JSON, into cars
+and plans what a bulk import of them changes in the inventory
+*/
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+/*
+Format is a file format cars can be imported from
+*/
+type Format int
+
+const (
+	// CSV has a header row naming its columns, in any order
+	CSV Format = iota + 1
+	// JSON is an array of objects with the same names as the CSV columns
+	JSON
+)
+
+/*
+Columns are the fields an imported car may have.  make and model are
+required; a row with an id updates that car instead of adding one, and
+keeps the stored value of any other column it leaves out.
+*/
+var Columns = []string{"id", "make", "model", "acquisition_cost_cents", "margin_cents", "vin", "year", "color", "mileage"}
+
+// ErrTooManyRows is returned by Parse for a file with more rows than allowed
+var ErrTooManyRows = errors.New("importer: too many rows")
+
+/*
+Row is one car read from a file
+*/
+type Row struct {
+	// Number is the row's position among the cars in the file, from 1;
+	// a CSV header is not counted
+	Number int
+	Car    models.Car
+	// Missing are the columns the row has no value for: those not in the
+	// CSV header, or left out of or null in a JSON object
+	Missing map[string]bool
+	// Err is why the row could not be read or is not a valid car
+	Err error
+}
+
+/*
+Parse reads the cars in r.  Problems with a single row are reported in
+that row's Err and the rest are still read; an error is only returned
+when the file as a whole cannot be read, or it has more than maxRows
+rows.
+*/
+func Parse(r io.Reader, format Format, maxRows int) ([]Row, error) {
+	var rows []Row
+	var err error
+	switch format {
+	case CSV:
+		rows, err = parseCSV(r, maxRows)
+	case JSON:
+		rows, err = parseJSON(r, maxRows)
+	default:
+		return nil, fmt.Errorf("importer: unknown format %d", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		if rows[i].Err == nil {
+			rows[i].Err = validate(rows[i].Car)
+		}
+	}
+	return rows, nil
+}
+
+func parseCSV(r io.Reader, maxRows int) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("importer: reading the CSV header: %w", err)
+	}
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !known(name) {
+			return nil, fmt.Errorf("importer: unknown CSV column %q; columns are %s", name, strings.Join(Columns, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("importer: CSV column %q appears twice", name)
+		}
+		seen[name] = true
+		header[i] = name
+	}
+
+	var rows []Row
+	for n := 1; ; n++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("importer: reading CSV: %w", err)
+		}
+		if n > maxRows {
+			return nil, ErrTooManyRows
+		}
+		row := Row{Number: n}
+		if len(rec) != len(header) {
+			row.Err = fmt.Errorf("has %d fields, the header has %d", len(rec), len(header))
+		} else {
+			fields := map[string]string{}
+			for i, name := range header {
+				fields[name] = strings.TrimSpace(rec[i])
+			}
+			row.Car, row.Err = fromFields(fields)
+			row.Missing = missing(fields)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func parseJSON(r io.Reader, maxRows int) ([]Row, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, errors.New("importer: JSON must be an array of cars")
+	}
+	var rows []Row
+	for n := 1; dec.More(); n++ {
+		if n > maxRows {
+			return nil, ErrTooManyRows
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("importer: reading JSON: %w", err)
+		}
+		row := Row{Number: n}
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil || obj == nil {
+			row.Err = errors.New("is not a JSON object")
+			rows = append(rows, row)
+			continue
+		}
+		fields := map[string]string{}
+		for name, value := range obj {
+			if !known(name) {
+				row.Err = fmt.Errorf("has unknown field %q", name)
+				break
+			}
+			// numbers may be sent as strings, as the REST API writes
+			// 64-bit integers
+			var s string
+			if err := json.Unmarshal(value, &s); err != nil {
+				s = string(value)
+			}
+			if string(value) != "null" {
+				fields[name] = strings.TrimSpace(s)
+			}
+		}
+		if row.Err == nil {
+			row.Car, row.Err = fromFields(fields)
+			row.Missing = missing(fields)
+		}
+		rows = append(rows, row)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("importer: reading JSON: %w", err)
+	}
+	return rows, nil
+}
+
+// fromFields builds a car from the named fields of a row
+func fromFields(fields map[string]string) (models.Car, error) {
+	car := models.Car{
+		TradeIn: models.TradeIn{Make: fields["make"], Model: fields["model"]},
+		Vin:     models.NormalizeVIN(fields["vin"]),
+		Color:   fields["color"],
+	}
+	ints := []struct {
+		name string
+		to   *int64
+	}{
+		{"id", &car.Id},
+		{"acquisition_cost_cents", &car.AcquisitionCostCents},
+		{"margin_cents", &car.MarginCents},
+		{"mileage", &car.Mileage},
+	}
+	for _, n := range ints {
+		s := fields[n.name]
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return car, fmt.Errorf("%s %q is not a whole number", n.name, s)
+		}
+		*n.to = v
+	}
+	if s := fields["year"]; s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return car, fmt.Errorf("year %q is not a whole number", s)
+		}
+		car.Year = int32(year)
+	}
+	return car, nil
+}
+
+// missing returns the columns fields has no value for
+func missing(fields map[string]string) map[string]bool {
+	m := map[string]bool{}
+	for _, c := range Columns {
+		if _, ok := fields[c]; !ok {
+			m[c] = true
+		}
+	}
+	return m
+}
+
+// validate checks a car read from a row, as CreateCar and UpdateCar do
+func validate(car models.Car) error {
+	switch {
+	case car.Make == "" || car.Model == "":
+		return errors.New("make and model are required")
+	case car.Id < 0:
+		return errors.New("id cannot be negative")
+	case car.AcquisitionCostCents < 0 || car.MarginCents < 0:
+		return errors.New("costs cannot be negative")
+	}
+	return models.CheckVehicle(car)
+}
+
+func known(name string) bool {
+	for _, c := range Columns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
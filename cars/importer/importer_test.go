@@ -0,0 +1,170 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+func TestParseCSV(t *testing.T) {
+	in := "Model, make ,margin_cents\n" +
+		"Camry,Toyota,245000\n" +
+		"Fit,Honda,lots\n" +
+		"Fit\n" +
+		",Honda,1\n"
+	rows, err := Parse(strings.NewReader(in), CSV, 10)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("Failed! got %d rows, want 4", len(rows))
+	}
+	want := models.Car{TradeIn: models.TradeIn{Make: "Toyota", Model: "Camry"}, MarginCents: 245000}
+	if rows[0].Err != nil || rows[0].Car != want {
+		t.Errorf("Failed! row 1 is %+v, want %+v", rows[0], want)
+	}
+	for i, msg := range []string{`margin_cents "lots" is not a whole number`, "has 1 fields, the header has 3", "make and model are required"} {
+		if rows[i+1].Err == nil || rows[i+1].Err.Error() != msg || rows[i+1].Number != i+2 {
+			t.Errorf("Failed! row %d: got %v, want %q", i+2, rows[i+1].Err, msg)
+		}
+	}
+
+	if _, err := Parse(strings.NewReader("make,colour\n"), CSV, 10); err == nil {
+		t.Errorf("Failed! expected an error for an unknown column")
+	}
+	if _, err := Parse(strings.NewReader(in), CSV, 3); !errors.Is(err, ErrTooManyRows) {
+		t.Errorf("Failed! expected ErrTooManyRows, got %v", err)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	in := `[
+		{"id": "3", "make": "Toyota", "model": "Rav4", "acquisition_cost_cents": 2480000},
+		{"make": "Honda", "model": "Fit", "colour": "red"},
+		"Fit",
+		{"make": "Honda", "model": "Fit", "margin_cents": -5}
+	]`
+	rows, err := Parse(strings.NewReader(in), JSON, 10)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	want := models.Car{Id: 3, TradeIn: models.TradeIn{Make: "Toyota", Model: "Rav4"}, AcquisitionCostCents: 2480000}
+	if len(rows) != 4 || rows[0].Err != nil || rows[0].Car != want {
+		t.Fatalf("Failed! unexpected rows %+v", rows)
+	}
+	for i, msg := range []string{`has unknown field "colour"`, "is not a JSON object", "costs cannot be negative"} {
+		if rows[i+1].Err == nil || rows[i+1].Err.Error() != msg {
+			t.Errorf("Failed! row %d: got %v, want %q", i+2, rows[i+1].Err, msg)
+		}
+	}
+
+	in = `[
+		{"make": "Honda", "model": "Fit", "vin": " jhmgd38477s000001 ", "year": 2007, "color": "White", "mileage": "98000"},
+		{"make": "Honda", "model": "Fit", "vin": "JHMGD38477S00000O"},
+		{"make": "Honda", "model": "Fit", "year": 1885},
+		{"make": "Honda", "model": "Fit", "mileage": -1}
+	]`
+	rows, err = Parse(strings.NewReader(in), JSON, 10)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	want = models.Car{TradeIn: models.TradeIn{Make: "Honda", Model: "Fit"}, Vin: "JHMGD38477S000001", Year: 2007, Color: "White", Mileage: 98000}
+	if len(rows) != 4 || rows[0].Err != nil || rows[0].Car != want {
+		t.Fatalf("Failed! unexpected rows %+v", rows)
+	}
+	for i, msg := range []string{"vin must be 17 letters and digits, without I, O or Q", "year is not a model year", "mileage cannot be negative"} {
+		if rows[i+1].Err == nil || rows[i+1].Err.Error() != msg {
+			t.Errorf("Failed! row %d: got %v, want %q", i+2, rows[i+1].Err, msg)
+		}
+	}
+
+	if _, err := Parse(strings.NewReader(`{"make": "Honda"}`), JSON, 10); err == nil {
+		t.Errorf("Failed! expected an error for JSON that is not an array")
+	}
+	if _, err := Parse(strings.NewReader(`[{"make": "Honda"},`), JSON, 10); err == nil {
+		t.Errorf("Failed! expected an error for truncated JSON")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	inventory := []models.Car{
+		{Id: 1, TradeIn: models.TradeIn{Make: "Ford", Model: "F10"}, MarginCents: 310000},
+		{Id: 2, TradeIn: models.TradeIn{Make: "Toyota", Model: "Camry"}},
+	}
+	car := func(id int64, make, model string, margin int64) models.Car {
+		return models.Car{Id: id, TradeIn: models.TradeIn{Make: make, Model: model}, MarginCents: margin}
+	}
+	rows := []Row{
+		{Number: 1, Car: car(0, "Kia", "Soul", 100)},
+		{Number: 2, Car: car(0, "kia", "SOUL", 100)},
+		{Number: 3, Car: car(0, "Kia", "Soul", 200)},
+		{Number: 4, Car: car(1, "Ford", "F10", 320000)},
+		{Number: 5, Car: car(1, "Ford", "F10", 320000)},
+		{Number: 6, Car: car(1, "Ford", "F10", 330000)},
+		{Number: 7, Car: car(2, "Toyota", "Camry", 0)},
+		{Number: 8, Car: car(9, "Honda", "Fit", 0)},
+		{Number: 9, Err: errors.New("make and model are required")},
+	}
+	results := Plan(rows, inventory)
+	want := []struct {
+		action      Action
+		duplicateOf int
+	}{
+		{Create, 0}, {Duplicate, 1}, {Create, 0}, {Update, 0}, {Duplicate, 4}, {Invalid, 0}, {Unchanged, 0}, {Invalid, 0}, {Invalid, 0},
+	}
+	for i, w := range want {
+		if results[i].Action != w.action || results[i].DuplicateOf != w.duplicateOf {
+			t.Errorf("Failed! row %d is %v of %d, want %v of %d", i+1, results[i].Action, results[i].DuplicateOf, w.action, w.duplicateOf)
+		}
+	}
+	if results[7].Err == nil || results[7].Err.Error() != "no car with id 9" {
+		t.Errorf("Failed! unexpected error for an unknown id: %v", results[7].Err)
+	}
+
+	changes := Changes(results)
+	if len(changes) != 3 || changes[0].Model != "Soul" || changes[2].Id != 1 {
+		t.Errorf("Failed! unexpected changes %v", changes)
+	}
+}
+
+func TestPlanKeepsMissingColumns(t *testing.T) {
+	stored := models.Car{
+		Id: 1, TradeIn: models.TradeIn{Make: "Ford", Model: "F10"},
+		AcquisitionCostCents: 2850000, MarginCents: 310000, Vin: "1FTEW1EP5MFA00001", Year: 2021, Color: "Blue", Mileage: 12000,
+	}
+	with := func(change func(*models.Car)) models.Car {
+		car := stored
+		change(&car)
+		return car
+	}
+	tests := []struct {
+		format Format
+		in     string
+		want   models.Car
+	}{
+		// columns left out keep their stored values
+		{CSV, "id,make,model,color\n1,Ford,F10,Red\n", with(func(c *models.Car) { c.Color = "Red" })},
+		// as do null JSON fields
+		{JSON, `[{"id": 1, "make": "Ford", "model": "F10", "margin_cents": null, "mileage": 15000}]`, with(func(c *models.Car) { c.Mileage = 15000 })},
+		// while an empty CSV cell clears its column
+		{CSV, "id,make,model,color,margin_cents\n1,Ford,F10,,0\n", with(func(c *models.Car) { c.Color, c.MarginCents = "", 0 })},
+	}
+	for _, tt := range tests {
+		rows, err := Parse(strings.NewReader(tt.in), tt.format, 10)
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		results := Plan(rows, []models.Car{stored})
+		if results[0].Action != Update || results[0].Car != tt.want {
+			t.Errorf("Failed! %q: got %v %+v, want update to %+v", tt.in, results[0].Action, results[0].Car, tt.want)
+		}
+	}
+
+	// a row leaving out every column it could change changes nothing
+	rows, _ := Parse(strings.NewReader("id,make,model\n1,Ford,F10\n"), CSV, 10)
+	if results := Plan(rows, []models.Car{stored}); results[0].Action != Unchanged {
+		t.Errorf("Failed! got %v, want unchanged", results[0].Action)
+	}
+}
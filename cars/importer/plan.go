@@ -0,0 +1,146 @@
+package importer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/simrie/go-grpc-car-service/cars/models"
+)
+
+/*
+Action is what importing a row does to the inventory
+*/
+type Action int
+
+const (
+	// Create adds the row's car
+	Create Action = iota + 1
+	// Update changes the car with the row's id to the row's values
+	Update
+	// Unchanged is an update to a car that already matches the row
+	Unchanged
+	// Duplicate repeats an earlier row and is skipped
+	Duplicate
+	// Invalid rows cannot be imported and are skipped
+	Invalid
+)
+
+func (a Action) String() string {
+	switch a {
+	case Create:
+		return "create"
+	case Update:
+		return "update"
+	case Unchanged:
+		return "unchanged"
+	case Duplicate:
+		return "duplicate"
+	case Invalid:
+		return "invalid"
+	}
+	return "unknown"
+}
+
+/*
+Result is what importing one row does
+*/
+type Result struct {
+	Row
+	Action Action
+	// DuplicateOf is the number of the earlier row a Duplicate repeats
+	DuplicateOf int
+}
+
+/*
+Plan decides what importing rows does to inventory, the cars stored
+now.  Rows without an id add a car, and rows with one update that car,
+keeping its stored value for each column the row is missing; an id
+matching no car is invalid.  A row repeating an earlier one exactly is a
+duplicate, as is a later update to the same car with the same fields,
+while a later update with different fields is invalid.
+*/
+func Plan(rows []Row, inventory []models.Car) []Result {
+	stored := make(map[int64]models.Car, len(inventory))
+	for _, car := range inventory {
+		stored[car.Id] = car
+	}
+	firstCreate := map[models.Car]int{}
+	firstUpdate := map[int64]Row{}
+
+	results := make([]Result, 0, len(rows))
+	for _, row := range rows {
+		res := Result{Row: row}
+		switch {
+		case row.Err != nil:
+			res.Action = Invalid
+		case row.Car.Id == 0:
+			key := row.Car
+			key.Make, key.Model, key.Color = strings.ToLower(key.Make), strings.ToLower(key.Model), strings.ToLower(key.Color)
+			if first, ok := firstCreate[key]; ok {
+				res.Action, res.DuplicateOf = Duplicate, first
+			} else {
+				firstCreate[key] = row.Number
+				res.Action = Create
+			}
+		default:
+			current, ok := stored[row.Car.Id]
+			if ok {
+				row.Car = fill(row.Car, current, row.Missing)
+				res.Car = row.Car
+			}
+			first, repeated := firstUpdate[row.Car.Id]
+			switch {
+			case !ok:
+				res.Action, res.Err = Invalid, fmt.Errorf("no car with id %d", row.Car.Id)
+			case repeated && first.Car == row.Car:
+				res.Action, res.DuplicateOf = Duplicate, first.Number
+			case repeated:
+				res.Action, res.Err = Invalid, fmt.Errorf("car %d is already changed by row %d", row.Car.Id, first.Number)
+			case current == row.Car:
+				firstUpdate[row.Car.Id] = row
+				res.Action = Unchanged
+			default:
+				firstUpdate[row.Car.Id] = row
+				res.Action = Update
+			}
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// fill returns car with the values of stored for the columns missing
+// from its row
+func fill(car, stored models.Car, missing map[string]bool) models.Car {
+	for column := range missing {
+		switch column {
+		case "acquisition_cost_cents":
+			car.AcquisitionCostCents = stored.AcquisitionCostCents
+		case "margin_cents":
+			car.MarginCents = stored.MarginCents
+		case "vin":
+			car.Vin = stored.Vin
+		case "year":
+			car.Year = stored.Year
+		case "color":
+			car.Color = stored.Color
+		case "mileage":
+			car.Mileage = stored.Mileage
+		}
+	}
+	return car
+}
+
+/*
+Changes returns the cars to write for results, in row order: those to
+create, with no id, and those to update
+*/
+func Changes(results []Result) []models.Car {
+	var cars []models.Car
+	for _, res := range results {
+		if res.Action == Create || res.Action == Update {
+			cars = append(cars, res.Car)
+		}
+	}
+	return cars
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/simrie/go-grpc-car-service/cars/apikey"
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+)
+
+/*
+key_issuer creates a new API key for a partner.  It prints the key, to
+hand to the partner, and the entry to add to the REST service's keys
+file, which only records the key's hash.
+
+"key_issuer usage" instead prints how many requests each key made on a
+day, from the REST service's usage file.
+*/
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+/*
+run issues a key, or with "usage" reports usage, for the command line
+args and returns the exit code: 0 on success, 1 when the key cannot be
+made or the usage file read, and 2 for a usage mistake
+*/
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "usage" {
+		return usage(args[1:], stdout, stderr)
+	}
+
+	fs := flag.NewFlagSet("key_issuer", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	name := fs.String("name", "", "partner name the key is issued to")
+	ratePerSecond := fs.Float64("rate", 5, "requests per second allowed for the key, 0 for no limit")
+	burst := fs.Int("burst", 10, "requests allowed in a burst above the rate")
+	dailyQuota := fs.Int64("daily-quota", 10000, "requests allowed per UTC day, 0 for no quota")
+	roles := fs.String("roles", string(auth.Viewer), "comma separated roles granted to the key")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	if *name == "" {
+		fmt.Fprintln(stderr, "a -name for the key is required")
+		return 2
+	}
+
+	key, hash, err := apikey.Generate()
+	if err != nil {
+		fmt.Fprintf(stderr, "cannot generate key: %v\n", err)
+		return 1
+	}
+
+	entry := apikey.Key{
+		Name:          *name,
+		SHA256:        hash,
+		RatePerSecond: *ratePerSecond,
+		Burst:         *burst,
+		DailyQuota:    *dailyQuota,
+	}
+	for _, r := range strings.Split(*roles, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			entry.Roles = append(entry.Roles, auth.Role(r))
+		}
+	}
+	out, err := yaml.Marshal([]apikey.Key{entry})
+	if err != nil {
+		fmt.Fprintf(stderr, "cannot encode key entry: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "API key for %s (give this to the partner, it is not stored anywhere):\n\n  %s\n\n", *name, key)
+	fmt.Fprintf(stdout, "Add this entry under \"keys:\" in the keys file:\n\n%s", out)
+	return 0
+}
+
+/*
+usage prints the requests counted for each key on a UTC day, busiest
+key first.  The REST service saves its counts every flush interval, so
+the last few seconds of today may not be in the file yet.
+*/
+func usage(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("usage", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	file := fs.String("file", "api-key-usage.json", "usage file of the REST service (gateway.api_keys.usage_file)")
+	day := fs.String("day", time.Now().UTC().Format(time.DateOnly), "UTC day to report, as YYYY-MM-DD")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	if _, err := time.Parse(time.DateOnly, *day); err != nil {
+		fmt.Fprintf(stderr, "-day %q is not a YYYY-MM-DD date\n", *day)
+		return 2
+	}
+	// LoadUsage starts empty without a file, which would hide a wrong path
+	if _, err := os.Stat(*file); err != nil {
+		fmt.Fprintf(stderr, "cannot read usage file: %v\n", err)
+		return 1
+	}
+	u, err := apikey.LoadUsage(*file)
+	if err != nil {
+		fmt.Fprintf(stderr, "cannot load usage file: %v\n", err)
+		return 1
+	}
+
+	report := u.Report(*day)
+	names := make([]string, 0, len(report))
+	for name := range report {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if report[names[i]] != report[names[j]] {
+			return report[names[i]] > report[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	if len(names) == 0 {
+		fmt.Fprintf(stdout, "No requests recorded on %s\n", *day)
+		return 0
+	}
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "KEY\tREQUESTS\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%d\n", name, report[name])
+	}
+	w.Flush()
+	return 0
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/simrie/go-grpc-car-service/cars/apikey"
+)
+
+// keyIssuer runs a command line and returns its exit code and output
+func keyIssuer(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestIssueKey(t *testing.T) {
+	code, out, errOut := keyIssuer("-name", "partner-a", "-rate", "2", "-burst", "4", "-daily-quota", "100", "-roles", "viewer, manager")
+	if code != 0 {
+		t.Fatalf("Failed! exited %d: %s", code, errOut)
+	}
+	key := regexp.MustCompile(`(?m)^  (cars_[A-Za-z0-9_-]{43})$`).FindStringSubmatch(out)
+	if key == nil {
+		t.Fatalf("Failed! no key in output:\n%s", out)
+	}
+	i := strings.Index(out, "- name: partner-a")
+	if i < 0 {
+		t.Fatalf("Failed! no keys file entry in output:\n%s", out)
+	}
+	entry := out[i:]
+	for _, want := range []string{"sha256: " + apikey.Hash(key[1]), "rate_per_second: 2", "burst: 4", "daily_quota: 100", "- viewer", "- manager"} {
+		if !strings.Contains(entry, want) {
+			t.Errorf("Failed! entry is missing %q:\n%s", want, entry)
+		}
+	}
+	if strings.Contains(entry, key[1]) {
+		t.Errorf("Failed! the keys file entry holds the key itself:\n%s", entry)
+	}
+
+	// the entry, added to a keys file, admits requests with the key
+	dir := t.TempDir()
+	keysPath := filepath.Join(dir, "keys.yaml")
+	if err := os.WriteFile(keysPath, []byte("keys:\n"+entry), 0o600); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	store, err := apikey.Load(keysPath, "")
+	if err != nil {
+		t.Fatalf("Failed! keys file with the printed entry does not load: %v", err)
+	}
+	handler := store.Middleware(false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("GET", "/cars", nil)
+	req.Header.Set(apikey.Header, key[1])
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Failed! the issued key was answered with %d", rec.Code)
+	}
+
+	// every key is new
+	if _, again, _ := keyIssuer("-name", "partner-a"); strings.Contains(again, key[1]) {
+		t.Errorf("Failed! the same key was issued twice")
+	}
+}
+
+func TestIssueKeyErrors(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{nil, "a -name for the key is required"},
+		{[]string{"-rate", "2"}, "a -name for the key is required"},
+		{[]string{"-name", "p", "-burst", "many"}, "invalid value"},
+		{[]string{"-name", "p", "-colour", "red"}, "flag provided but not defined"},
+	}
+	for _, tt := range tests {
+		code, out, errOut := keyIssuer(tt.args...)
+		if code != 2 || out != "" || !strings.Contains(errOut, tt.wantErr) {
+			t.Errorf("Failed! %v: exited %d with %q, %q", tt.args, code, out, errOut)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	u, err := apikey.LoadUsage(path)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	for name, n := range map[string]int{"partner-a": 2, "partner-b": 3} {
+		for i := 0; i < n; i++ {
+			u.Take("2021-05-01", name, 0)
+		}
+	}
+	u.Take("2021-05-02", "partner-a", 0)
+	if err := u.Flush(); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+
+	code, out, errOut := keyIssuer("usage", "-file", path, "-day", "2021-05-01")
+	if want := "KEY        REQUESTS\npartner-b  3\npartner-a  2\n"; code != 0 || out != want {
+		t.Errorf("Failed! exited %d with %q %s, want %q", code, out, errOut, want)
+	}
+	code, out, _ = keyIssuer("usage", "-file", path, "-day", "2021-05-03")
+	if code != 0 || out != "No requests recorded on 2021-05-03\n" {
+		t.Errorf("Failed! exited %d with %q", code, out)
+	}
+
+	tests := []struct {
+		args     []string
+		wantCode int
+		wantErr  string
+	}{
+		{[]string{"-file", path, "-day", "05/01/2021"}, 2, `-day "05/01/2021" is not a YYYY-MM-DD date`},
+		{[]string{"-file", path, "-days", "2021-05-01"}, 2, "flag provided but not defined"},
+		{[]string{"-file", filepath.Join(filepath.Dir(path), "missing.json")}, 1, "cannot read usage file"},
+	}
+	for _, tt := range tests {
+		code, _, errOut := keyIssuer(append([]string{"usage"}, tt.args...)...)
+		if code != tt.wantCode || !strings.Contains(errOut, tt.wantErr) {
+			t.Errorf("Failed! %v: exited %d with %q", tt.args, code, errOut)
+		}
+	}
+}
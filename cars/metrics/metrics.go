@@ -0,0 +1,252 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+const namespace = "cars"
+
+var (
+	// REST gateway metrics, labelled by mux route template or gRPC method
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency, by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	httpRequestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "HTTP requests currently being served, by route.",
+	}, []string{"route"})
+
+	// gRPC microservice metrics, labelled by full method name
+	grpcRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "Number of gRPC calls handled, by method and status code.",
+	}, []string{"method", "code"})
+
+	grpcRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "gRPC call latency, by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	grpcRequestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "grpc",
+		Name:      "requests_in_flight",
+		Help:      "gRPC calls currently being served, by method.",
+	}, []string{"method"})
+
+	// data layer metrics
+	inventorySize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "data",
+		Name:      "inventory_size",
+		Help:      "Number of car records returned by the last full inventory read.",
+	})
+
+	lookupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "data",
+		Name:      "lookup_duration_seconds",
+		Help:      "Data layer lookup latency, by operation.",
+		Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1},
+	}, []string{"operation"})
+
+	registry = prometheus.NewRegistry()
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+/*
+RegisterGateway adds the REST gateway collectors to the registry
+exposed by Handler
+*/
+func RegisterGateway() {
+	registry.MustRegister(httpRequestsTotal, httpRequestDuration, httpRequestsInFlight)
+}
+
+/*
+RegisterMicroservice adds the gRPC and data layer collectors to the
+registry exposed by Handler
+*/
+func RegisterMicroservice() {
+	registry.MustRegister(grpcRequestsTotal, grpcRequestDuration, grpcRequestsInFlight,
+		inventorySize, lookupDuration)
+}
+
+/*
+Handler serves the registered metrics in the Prometheus exposition format
+*/
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streaming handlers can flush through the middleware
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush lets gRPC, which needs an http.Flusher, be served through the
+// middleware
+func (r *statusRecorder) Flush() {
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Hijack lets WebSocket handlers take over the connection
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// grpcMethods are the gRPC methods labelled by name when served over
+// HTTP.  Any other path a client sends counts as unknown, so clients
+// cannot add labels of their own.
+var grpcMethods = methodPaths(carspb.CarService_ServiceDesc, healthpb.Health_ServiceDesc)
+
+func methodPaths(services ...grpc.ServiceDesc) map[string]bool {
+	paths := map[string]bool{}
+	for _, sd := range services {
+		for _, m := range sd.Methods {
+			paths["/"+sd.ServiceName+"/"+m.MethodName] = true
+		}
+		for _, s := range sd.Streams {
+			paths["/"+sd.ServiceName+"/"+s.StreamName] = true
+		}
+	}
+	return paths
+}
+
+/*
+HTTPMiddleware records request counts, latency and in-flight requests
+for every route served by a mux router, and for gRPC calls served over
+HTTP, which are labelled by their method when it is a CarService or
+health method
+*/
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if strings.HasPrefix(r.Header.Get("content-type"), "application/grpc") && grpcMethods[r.URL.Path] {
+			route = r.URL.Path
+		}
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+
+		inFlight := httpRequestsInFlight.WithLabelValues(route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		code := strconv.Itoa(rec.status)
+		httpRequestsTotal.WithLabelValues(route, r.Method, code).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
+
+/*
+UnaryServerInterceptor records call counts, latency and in-flight calls
+for unary gRPC methods
+*/
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	done := observeRPC(info.FullMethod)
+	resp, err := handler(ctx, req)
+	done(err)
+	return resp, err
+}
+
+/*
+StreamServerInterceptor records call counts, latency and in-flight calls
+for streaming gRPC methods
+*/
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	done := observeRPC(info.FullMethod)
+	err := handler(srv, ss)
+	done(err)
+	return err
+}
+
+func observeRPC(method string) func(error) {
+	inFlight := grpcRequestsInFlight.WithLabelValues(method)
+	inFlight.Inc()
+	start := time.Now()
+	return func(err error) {
+		inFlight.Dec()
+		code := status.Code(err).String()
+		grpcRequestsTotal.WithLabelValues(method, code).Inc()
+		grpcRequestDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+	}
+}
+
+/*
+SetInventorySize records the number of records in the inventory
+*/
+func SetInventorySize(n int) {
+	inventorySize.Set(float64(n))
+}
+
+/*
+ObserveLookup records the latency of a data layer operation that began
+at start. It is meant to be deferred at the top of the operation.
+*/
+func ObserveLookup(operation string, start time.Time) {
+	lookupDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(HTTPMiddleware)
+	router.HandleFunc("/car/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/car/7", nil))
+
+	if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("/car/{id}", "GET", "404")); got != 1 {
+		t.Errorf("Failed! expected 1 request for /car/{id} 404, got %v", got)
+	}
+	if got := testutil.ToFloat64(httpRequestsInFlight.WithLabelValues("/car/{id}")); got != 0 {
+		t.Errorf("Failed! expected no requests in flight, got %v", got)
+	}
+}
+
+func TestHTTPMiddlewareGRPC(t *testing.T) {
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	defer s.Stop()
+	srv := httptest.NewServer(h2c.NewHandler(HTTPMiddleware(s), &http2.Server{}))
+	defer srv.Close()
+
+	conn, err := grpc.NewClient(strings.TrimPrefix(srv.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Failed! a gRPC call through the middleware failed: %v", err)
+	}
+
+	if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("/grpc.health.v1.Health/Check", "POST", "200")); got != 1 {
+		t.Errorf("Failed! expected 1 request for the gRPC method, got %v", got)
+	}
+
+	// methods that are not served share one label however many are tried
+	before := testutil.CollectAndCount(httpRequestsTotal)
+	for _, method := range []string{"/made.Up/One", "/made.Up/Two"} {
+		conn.Invoke(context.Background(), method, &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{})
+	}
+	if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("unknown", "POST", "200")); got != 2 {
+		t.Errorf("Failed! expected 2 requests labelled unknown, got %v", got)
+	}
+	if n := testutil.CollectAndCount(httpRequestsTotal); n != before+1 {
+		t.Errorf("Failed! expected 1 more label set, got %d more", n-before)
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/cars.CarService/Car"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "no such car")
+	}
+
+	UnaryServerInterceptor(context.Background(), nil, info, handler)
+
+	if got := testutil.ToFloat64(grpcRequestsTotal.WithLabelValues(info.FullMethod, "NotFound")); got != 1 {
+		t.Errorf("Failed! expected 1 NotFound call, got %v", got)
+	}
+}
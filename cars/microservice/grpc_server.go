@@ -2,106 +2,189 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carservice"
+	"github.com/simrie/go-grpc-car-service/cars/catalog"
+	"github.com/simrie/go-grpc-car-service/cars/config"
 	"github.com/simrie/go-grpc-car-service/cars/data"
-	"github.com/simrie/go-grpc-car-service/cars/models"
+	"github.com/simrie/go-grpc-car-service/cars/metrics"
+	"github.com/simrie/go-grpc-car-service/cars/tlsconfig"
+	"github.com/simrie/go-grpc-car-service/cars/tracing"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
 )
 
-// Error if dummy struct does not implement unimplementedGreetServiceServer
-type server struct {
-	carspb.UnimplementedCarServiceServer
-}
+func main() {
+	cfg, err := config.Load("grpc_server", os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		cfg.Write(os.Stdout)
+		return
+	}
 
-func (*server) Car(ctx context.Context, req *carspb.CarRequest) (*carspb.CarResponse, error) {
-	fmt.Printf("Car function was invoked with %v\n", req)
+	fmt.Println("Microservice starting.")
 
-	id := req.Id
+	// SIGINT or SIGTERM starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	rec, err := data.GetRecordById(id)
-	if err != nil {
-		return nil, err
+	// Spans are always created so trace context keeps flowing,
+	// but they are only printed when asked for
+	var traceOut io.Writer = io.Discard
+	if cfg.Trace {
+		traceOut = os.Stdout
 	}
-
-	// convert result to *carspb.Car
-	var result *carspb.Car
-	result, err = ConvertCarToCarpb(rec)
+	tp, err := tracing.NewStdoutProvider("grpc_server", traceOut)
 	if err != nil {
-		return nil, err
+		log.Fatalf("cannot create tracer provider: %v", err)
 	}
 
-	res := &carspb.CarResponse{
-		Result: result,
+	lis, err := net.Listen("tcp", cfg.Microservice.ListenAddr)
+	if err != nil {
+		log.Fatalf("cannot listen to grpc port for tcp: %v", err)
 	}
-	return res, nil
-}
-
-func (*server) CarWithDeadline(ctx context.Context, req *carspb.CarWithDeadlineRequest) (*carspb.CarWithDeadlineResponse, error) {
-	fmt.Printf("CarsWithDeadline function was invoked with %v\n", req)
 
-	// Check to see if the timeout occurred
-	for i := 0; i < 3; i++ {
-		if ctx.Err() == context.Canceled {
-			msg := "Client cancelled the request (timeout)"
-			fmt.Println(msg)
-			return nil, status.Error(codes.DeadlineExceeded, msg)
+	// Metrics are served over plain HTTP on their own port
+	metrics.RegisterMicroservice()
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", metrics.Handler())
+	metricsServer := &http.Server{Addr: cfg.Microservice.MetricsAddr, Handler: metricsMux}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics listener stopped: %v", err)
 		}
-		time.Sleep(1 * time.Second)
+	}()
+
+	// Only callers with a verified client certificate may forward an
+	// identity.  The gRPC-Web server has no credentials, which only apply
+	// to a listener of its own, and takes every identity it is given, as
+	// its middleware drops any a browser sends.
+	serverOpts := carservice.ServerOptions(cfg.Microservice.EnforceRoles, auth.VerifiedClient)
+	webServerOpts := carservice.ServerOptions(cfg.Microservice.EnforceRoles, auth.AnyCaller)
+	if tlsCfg := cfg.Microservice.TLS; tlsCfg.Enabled() {
+		// With a client CA every caller must present a certificate it signed
+		serverTLS, err := tlsconfig.ServerConfig(tlsconfig.Files{
+			CertFile: tlsCfg.CertFile,
+			KeyFile:  tlsCfg.KeyFile,
+			CAFile:   tlsCfg.CAFile,
+		})
+		if err != nil {
+			log.Fatalf("cannot set up TLS: %v", err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(serverTLS)))
 	}
+	s := grpc.NewServer(serverOpts...)
 
-	recs, err := data.GetAllRecords()
+	names, err := catalog.Load(cfg.Microservice.Catalog.File)
 	if err != nil {
-		return nil, err
+		log.Fatalf("cannot load the catalog: %v", err)
 	}
 
-	// convert result to *carspb.Car
-	var results []*carspb.Car
-	for _, v := range recs {
-		var result *carspb.Car
-		result, err = ConvertCarToCarpb(v)
-		if err == nil {
-			results = append(results, result)
+	// Standard grpc.health.v1 service reflecting repository health
+	healthServer := health.NewServer()
+	cars := carservice.New(ctx.Done(), names, cfg.Microservice.Catalog.RejectUnknown)
+	carservice.Register(s, cars, healthServer)
+	go carservice.WatchRepositoryHealth(ctx, healthServer, cfg.Microservice.HealthInterval)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.Serve(lis)
+	}()
+
+	// gRPC-Web calls from browsers run on a server of their own so they
+	// can be stopped separately on shutdown
+	var webServer *http.Server
+	var webGRPC *grpc.Server
+	if webCfg := cfg.Microservice.GRPCWeb; webCfg.ListenAddr != "" {
+		var verifier *auth.Verifier
+		if webCfg.Auth.JWKSFile != "" {
+			verifier, err = auth.NewVerifier(webCfg.Auth.JWKSFile, webCfg.Auth.Issuer, webCfg.Auth.Audience)
+			if err != nil {
+				log.Fatalf("cannot set up gRPC-Web authentication: %v", err)
+			}
+		}
+		webGRPC = grpc.NewServer(webServerOpts...)
+		carservice.Register(webGRPC, cars, healthServer)
+		webServer = &http.Server{Handler: grpcWebHandler(webGRPC, webCfg.AllowedOrigins, verifier)}
+		if tlsCfg := cfg.Microservice.TLS; tlsCfg.Enabled() {
+			// browsers cannot present client certificates, so only the
+			// server certificate is used
+			webServer.TLSConfig, err = tlsconfig.ServerConfig(tlsconfig.Files{
+				CertFile: tlsCfg.CertFile,
+				KeyFile:  tlsCfg.KeyFile,
+			})
+			if err != nil {
+				log.Fatalf("cannot set up gRPC-Web TLS: %v", err)
+			}
 		}
+		webLis, err := net.Listen("tcp", webCfg.ListenAddr)
+		if err != nil {
+			log.Fatalf("cannot listen to grpc-web port for tcp: %v", err)
+		}
+		go func() {
+			var err error
+			if webServer.TLSConfig != nil {
+				err = webServer.ServeTLS(webLis, "", "")
+			} else {
+				err = webServer.Serve(webLis)
+			}
+			if err != http.ErrServerClosed {
+				serveErr <- err
+			}
+		}()
 	}
 
-	res := &carspb.CarWithDeadlineResponse{
-		Result: results,
+	select {
+	case err := <-serveErr:
+		log.Fatalf("Failed to serve %v", err)
+	case <-ctx.Done():
 	}
-	return res, nil
-}
-
-func ConvertCarToCarpb(car models.Car) (*carspb.Car, error) {
-	var carpb carspb.Car
-	carpb.Id = car.Id
-	carpb.Make = car.Make
-	carpb.Model = car.Model
-	return &carpb, nil
-}
-
-func main() {
-	fmt.Println("Microservice starting.")
-
-	// Here we test the grpc code generated from cars.proto
-
-	lis, err := net.Listen("tcp", "0.0.0.0:50051")
-	if err != nil {
-		log.Fatalf("cannot listen to grpc port for tcp: %v", err)
+	stop()
+	log.Printf("Microservice shutting down, draining calls for up to %v", cfg.GracePeriod)
+
+	// Report NOT_SERVING so gateways stop sending work, then drain
+	healthServer.Shutdown()
+	webStopped := make(chan struct{})
+	go func() {
+		defer close(webStopped)
+		if webServer != nil {
+			stopGRPCWeb(webServer, webGRPC, cfg.GracePeriod)
+		}
+	}()
+	carservice.GracefulStop(s, cfg.GracePeriod)
+	<-webStopped
+
+	// Metrics stay available until the calls are drained so the final
+	// counts can still be scraped
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("metrics listener shutdown: %v", err)
 	}
-
-	s := grpc.NewServer()
-
-	carspb.RegisterCarServiceServer(s, &server{})
-
-	if err := s.Serve(lis); err != nil {
-		log.Fatalf("Failed to serve %v", err)
+	if err := tp.Shutdown(shutdownCtx); err != nil {
+		log.Printf("flushing trace spans: %v", err)
 	}
-
+	if err := data.Close(); err != nil {
+		log.Printf("closing repository: %v", err)
+	}
+	log.Printf("Microservice stopped.")
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"google.golang.org/grpc"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+)
+
+// grpcWebRequestHeaders are the headers browsers may send with a call
+var grpcWebRequestHeaders = []string{
+	"authorization", "content-type", "grpc-timeout", "x-grpc-web", "x-user-agent",
+}
+
+/*
+grpcWebHandler serves the services registered on s to browsers over
+gRPC-Web, both unary and server-streaming calls.  Pages from
+allowedOrigins, a comma separated list or * for any, may call it across
+origins.  Callers are identified by their bearer token when verifier is
+set and are otherwise anonymous; identity headers they send themselves
+are dropped.
+*/
+func grpcWebHandler(s *grpc.Server, allowedOrigins string, verifier *auth.Verifier) http.Handler {
+	origins := map[string]bool{}
+	for _, origin := range strings.Split(allowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins[origin] = true
+		}
+	}
+	web := grpcweb.WrapHandler(auth.MetadataMiddleware(verifier, s),
+		grpcweb.WithOriginFunc(func(origin string) bool {
+			return origins["*"] || origins[origin]
+		}),
+		grpcweb.WithAllowedRequestHeaders(grpcWebRequestHeaders),
+		grpcweb.WithCorsForRegisteredEndpointsOnly(true),
+		grpcweb.WithEndpointsFunc(func() []string {
+			return grpcweb.ListGRPCResources(s)
+		}),
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// plain HTTP and gRPC requests are not passed on to s
+		if !web.IsGrpcWebRequest(r) && !web.IsAcceptableGrpcCorsRequest(r) {
+			http.NotFound(w, r)
+			return
+		}
+		web.ServeHTTP(w, r)
+	})
+}
+
+/*
+stopGRPCWeb waits up to grace for the gRPC-Web calls in flight, then
+stops s, cancelling any still running.  s cannot be drained like a
+server on its own listener, as its HTTP transports do not support it.
+*/
+func stopGRPCWeb(hs *http.Server, s *grpc.Server, grace time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := hs.Shutdown(ctx); err != nil {
+		log.Printf("gRPC-Web calls still running after %v, cancelling them", grace)
+	}
+	s.Stop()
+	hs.Close()
+}
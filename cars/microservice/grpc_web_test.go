@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carservice"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/catalog"
+)
+
+const testOrigin = "https://showroom.example"
+
+func startGRPCWeb(t *testing.T) *httptest.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.UnaryIdentityInterceptor),
+		grpc.StreamInterceptor(auth.StreamIdentityInterceptor))
+	carspb.RegisterCarServiceServer(s, carservice.New(nil, catalog.Builtin(), false))
+	srv := httptest.NewServer(grpcWebHandler(s, "https://other.example, "+testOrigin, nil))
+	t.Cleanup(func() {
+		srv.Close()
+		s.Stop()
+	})
+	return srv
+}
+
+// grpcWebRequest builds a gRPC-Web call of method with msg as its one
+// length-prefixed message
+func grpcWebRequest(t *testing.T, ctx context.Context, url, method string, msg proto.Message) *http.Request {
+	t.Helper()
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	body := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(b)))
+	req, err := http.NewRequestWithContext(ctx, "POST", url+"/cars.CarService/"+method, bytes.NewReader(append(body, b...)))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	req.Header.Set("content-type", "application/grpc-web+proto")
+	req.Header.Set("x-grpc-web", "1")
+	req.Header.Set("origin", testOrigin)
+	return req
+}
+
+// readFrame reads one gRPC-Web frame, reporting whether it holds trailers
+func readFrame(t *testing.T, r io.Reader) ([]byte, bool) {
+	t.Helper()
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatalf("Failed! reading frame: %v", err)
+	}
+	b := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatalf("Failed! reading frame: %v", err)
+	}
+	return b, header[0]&0x80 != 0
+}
+
+func TestGRPCWebUnary(t *testing.T) {
+	srv := startGRPCWeb(t)
+
+	req := grpcWebRequest(t, context.Background(), srv.URL, "Car", &carspb.CarRequest{Id: 1})
+	// identity headers from the browser are not trusted
+	req.Header.Set("x-cars-subject", "mallory")
+	req.Header.Set("x-cars-roles", "manager")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer res.Body.Close()
+	if got := res.Header.Get("Access-Control-Allow-Origin"); got != testOrigin {
+		t.Errorf("Failed! Access-Control-Allow-Origin %q, want %q", got, testOrigin)
+	}
+
+	b, trailers := readFrame(t, res.Body)
+	if trailers {
+		t.Fatalf("Failed! expected a message, got trailers %q", b)
+	}
+	var car carspb.CarResponse
+	if err := proto.Unmarshal(b, &car); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if car.Result.GetId() != 1 || car.Result.GetAcquisitionCostCents() != 0 {
+		t.Errorf("Failed! got %v, want car 1 without cost data", car.Result)
+	}
+	if b, trailers := readFrame(t, res.Body); !trailers || !strings.Contains(string(b), "grpc-status: 0") {
+		t.Errorf("Failed! got final frame %q, want OK trailers", b)
+	}
+}
+
+func TestGRPCWebServerStreaming(t *testing.T) {
+	srv := startGRPCWeb(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// sequence numbers from an earlier run get a reset event straight away
+	res, err := http.DefaultClient.Do(grpcWebRequest(t, ctx, srv.URL, "Watch", &carspb.WatchRequest{AfterSequence: 1 << 40}))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer res.Body.Close()
+
+	b, trailers := readFrame(t, res.Body)
+	if trailers {
+		t.Fatalf("Failed! expected an event, got trailers %q", b)
+	}
+	var e carspb.InventoryEvent
+	if err := proto.Unmarshal(b, &e); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if e.Type != carspb.EventType_EVENT_TYPE_RESET {
+		t.Errorf("Failed! got %v, want a reset event", &e)
+	}
+}
+
+func TestGRPCWebCORS(t *testing.T) {
+	srv := startGRPCWeb(t)
+
+	tests := []struct {
+		origin string
+		want   string
+	}{
+		{testOrigin, testOrigin},
+		{"https://elsewhere.example", ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("OPTIONS", srv.URL+"/cars.CarService/Watch", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "authorization,content-type,x-grpc-web")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		res.Body.Close()
+		if got := res.Header.Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("Failed! %s: Access-Control-Allow-Origin %q, want %q", tt.origin, got, tt.want)
+		}
+	}
+
+	// only gRPC-Web calls and their preflights reach the gRPC server
+	res, err := http.Get(srv.URL + "/cars.CarService/Car")
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Failed! plain GET answered %d, want 404", res.StatusCode)
+	}
+}
@@ -6,4 +6,12 @@ Car describes a car to retrieve from the database
 type Car struct {
 	TradeIn
 	Id int64 `json:"id"`
+	// Vehicle details, each empty or zero when not known
+	Vin     string `json:"vin"`
+	Year    int32  `json:"year"`
+	Color   string `json:"color"`
+	Mileage int64  `json:"mileage"`
+	// Internal cost data; see the visibility package for who may see it
+	AcquisitionCostCents int64 `json:"acquisition_cost_cents"`
+	MarginCents          int64 `json:"margin_cents"`
 }
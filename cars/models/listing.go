@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+/*
+Dealer describes a dealership with cars in stock
+*/
+type Dealer struct {
+	Id    int64  `json:"id"`
+	Name  string `json:"name"`
+	City  string `json:"city"`
+	Phone string `json:"phone"`
+}
+
+/*
+Photo is a picture of a car
+*/
+type Photo struct {
+	URL     string `json:"url"`
+	Caption string `json:"caption"`
+}
+
+/*
+PricePoint is the price a car was offered at from Time on
+*/
+type PricePoint struct {
+	PriceCents int64     `json:"price_cents"`
+	Time       time.Time `json:"time"`
+}
+
+/*
+Listing describes what the showroom shows with a car besides the car
+itself
+*/
+type Listing struct {
+	CarId int64 `json:"car_id"`
+	// Dealer is nil when the dealer with the car is not known
+	Dealer *Dealer `json:"dealer"`
+	Photos []Photo `json:"photos"`
+	// PriceHistory is oldest first
+	PriceHistory []PricePoint `json:"price_history"`
+}
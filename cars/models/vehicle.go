@@ -0,0 +1,41 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// FirstModelYear is the year of the first production car
+const FirstModelYear = 1886
+
+/*
+NormalizeVIN returns vin as it is stored: upper case, without
+surrounding space
+*/
+func NormalizeVIN(vin string) string {
+	return strings.ToUpper(strings.TrimSpace(vin))
+}
+
+/*
+CheckVehicle returns an error describing the first of car's vehicle
+details that cannot be right.  A VIN, when given, is 17 letters and
+digits other than I, O and Q; the year is a model year no later than
+next year's.  Details that are not known are not checked.
+*/
+func CheckVehicle(car Car) error {
+	if vin := NormalizeVIN(car.Vin); vin != "" {
+		if len(vin) != 17 || strings.ContainsFunc(vin, func(r rune) bool {
+			return !(r >= '0' && r <= '9' || r >= 'A' && r <= 'Z') || r == 'I' || r == 'O' || r == 'Q'
+		}) {
+			return errors.New("vin must be 17 letters and digits, without I, O or Q")
+		}
+	}
+	if car.Year != 0 && (car.Year < FirstModelYear || int(car.Year) > time.Now().Year()+1) {
+		return errors.New("year is not a model year")
+	}
+	if car.Mileage < 0 {
+		return errors.New("mileage cannot be negative")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package openapi
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strings"
+
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+/*
+Spec is the OpenAPI 3 document describing the REST service.  Keep it in
+step with the routes registered by rest_server; a test there checks that
+every route is described.
+*/
+//go:embed openapi.json
+var Spec []byte
+
+/*
+Handler serves Spec
+*/
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		w.Write(Spec)
+	})
+}
+
+// initializer replaces the one shipped with Swagger UI, which points at
+// the Petstore example
+const initializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: %q,
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    plugins: [SwaggerUIBundle.plugins.DownloadUrl],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+/*
+DocsHandler serves the embedded Swagger UI, showing the document at
+specURL.  It expects paths relative to where it is mounted, so mount it
+behind http.StripPrefix.
+*/
+func DocsHandler(specURL string) http.Handler {
+	files := http.FileServer(http.FS(swaggerFiles.FS))
+	script := fmt.Sprintf(initializer, specURL)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.TrimPrefix(r.URL.Path, "/") == "swagger-initializer.js" {
+			w.Header().Set("content-type", "text/javascript; charset=utf-8")
+			w.Write([]byte(script))
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
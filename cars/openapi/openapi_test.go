@@ -0,0 +1,50 @@
+package openapi
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// refs collects every $ref in a decoded JSON document
+func refs(v interface{}, found *[]string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				*found = append(*found, ref)
+			}
+			refs(value, found)
+		}
+	case []interface{}:
+		for _, value := range v {
+			refs(value, found)
+		}
+	}
+}
+
+func TestSpecReferencesResolve(t *testing.T) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(Spec, &doc); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if doc["openapi"] != "3.0.3" {
+		t.Errorf("Failed! unexpected openapi version %v", doc["openapi"])
+	}
+	var found []string
+	refs(doc, &found)
+	for _, ref := range found {
+		var node interface{} = doc
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			m, ok := node.(map[string]interface{})
+			if !ok {
+				node = nil
+				break
+			}
+			node = m[part]
+		}
+		if node == nil {
+			t.Errorf("Failed! %s does not resolve", ref)
+		}
+	}
+}
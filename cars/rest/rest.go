@@ -0,0 +1,225 @@
+package rest
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+)
+
+/*
+Car is the REST representation of a car.
+
+The field names and encoding follow the canonical protobuf JSON mapping
+with the original field names, so 64-bit integers are strings.  Unlike
+encoding generated structs, every field the caller may see is always
+present, even when it is zero; cost fields the caller may not see, and
+vehicle details the car does not have, are left out.
+*/
+type Car struct {
+	XMLName xml.Name `json:"-" xml:"car"`
+	Id      int64    `json:"id,string" xml:"id"`
+	Make    string   `json:"make" xml:"make"`
+	Model   string   `json:"model" xml:"model"`
+	Vin     string   `json:"vin,omitempty" xml:"vin,omitempty"`
+	Year    int32    `json:"year,omitempty" xml:"year,omitempty"`
+	Color   string   `json:"color,omitempty" xml:"color,omitempty"`
+	Mileage int64    `json:"mileage,omitempty,string" xml:"mileage,omitempty"`
+	// AcquisitionCostCents is only present for managers and admins
+	AcquisitionCostCents *int64 `json:"acquisition_cost_cents,omitempty,string" xml:"acquisition_cost_cents,omitempty"`
+	// MarginCents is present for sales, rounded down, and for managers and admins
+	MarginCents *int64 `json:"margin_cents,omitempty,string" xml:"margin_cents,omitempty"`
+}
+
+/*
+CarList is the REST representation of a list of cars.  Cars is never
+null, so an empty list encodes as [] in JSON.
+*/
+type CarList struct {
+	XMLName xml.Name `json:"-" xml:"cars"`
+	Cars    []Car    `json:"cars" xml:"car"`
+}
+
+/*
+Error is the body of every error response
+*/
+type Error struct {
+	XMLName xml.Name `json:"-" xml:"error"`
+	Message string   `json:"message" xml:"message"`
+}
+
+/*
+NewCar converts car to its REST representation as a caller at level may
+see it
+*/
+func NewCar(car *carspb.Car, level visibility.Level) Car {
+	car = visibility.Car(level, car)
+	c := Car{
+		Id:      car.GetId(),
+		Make:    car.GetMake(),
+		Model:   car.GetModel(),
+		Vin:     car.GetVin(),
+		Year:    car.GetYear(),
+		Color:   car.GetColor(),
+		Mileage: car.GetMileage(),
+	}
+	if level >= visibility.Coarse {
+		margin := car.GetMarginCents()
+		c.MarginCents = &margin
+	}
+	if level >= visibility.Full {
+		cost := car.GetAcquisitionCostCents()
+		c.AcquisitionCostCents = &cost
+	}
+	return c
+}
+
+/*
+NewCarList converts cars to their REST representation as a caller at
+level may see them
+*/
+func NewCarList(cars []*carspb.Car, level visibility.Level) CarList {
+	list := CarList{Cars: make([]Car, 0, len(cars))}
+	for _, car := range cars {
+		list.Cars = append(list.Cars, NewCar(car, level))
+	}
+	return list
+}
+
+/*
+Event is the REST representation of an inventory change.  Type is
+created, updated, deleted, held, released or reset; a reset carries no
+car and means the client should reload the inventory.
+*/
+type Event struct {
+	Sequence uint64 `json:"sequence,string"`
+	Type     string `json:"type"`
+	Car      *Car   `json:"car,omitempty"`
+	// Hold is present for held and released events
+	Hold *Hold `json:"hold,omitempty"`
+	// Time is RFC 3339 in UTC, as in protobuf JSON
+	Time string `json:"time"`
+}
+
+/*
+NewEvent converts event to its REST representation as a caller at level
+may see it
+*/
+func NewEvent(event *carspb.InventoryEvent, level visibility.Level) Event {
+	e := Event{
+		Sequence: event.GetSequence(),
+		Type:     strings.ToLower(strings.TrimPrefix(event.GetType().String(), "EVENT_TYPE_")),
+		Time:     timestamp(event.GetTime()),
+	}
+	if event.GetCar() != nil {
+		car := NewCar(event.GetCar(), level)
+		e.Car = &car
+	}
+	if event.GetHold() != nil {
+		hold := NewHold(event.GetHold())
+		e.Hold = &hold
+	}
+	return e
+}
+
+/*
+Hold is the REST representation of a hold on a car
+*/
+type Hold struct {
+	Id     string `json:"id"`
+	CarId  int64  `json:"car_id,string"`
+	HeldBy string `json:"held_by"`
+	// ExpireTime is RFC 3339 in UTC, as in protobuf JSON
+	ExpireTime string `json:"expire_time"`
+}
+
+/*
+NewHold converts hold to its REST representation
+*/
+func NewHold(hold *carspb.Hold) Hold {
+	return Hold{
+		Id:         hold.GetId(),
+		CarId:      hold.GetCarId(),
+		HeldBy:     hold.GetHeldBy(),
+		ExpireTime: timestamp(hold.GetExpireTime()),
+	}
+}
+
+// timestamp formats ts as the protobuf JSON mapping does: RFC 3339 in UTC
+// with 0, 3, 6 or 9 fractional digits
+func timestamp(ts *timestamppb.Timestamp) string {
+	t := ts.AsTime()
+	switch {
+	case t.Nanosecond() == 0:
+		return t.Format("2006-01-02T15:04:05Z")
+	case t.Nanosecond()%1e6 == 0:
+		return t.Format("2006-01-02T15:04:05.000Z")
+	case t.Nanosecond()%1e3 == 0:
+		return t.Format("2006-01-02T15:04:05.000000Z")
+	}
+	return t.Format("2006-01-02T15:04:05.000000000Z")
+}
+
+/*
+ImportReport is the REST representation of a bulk import: what each row
+of the file does, and how many rows do each thing
+*/
+type ImportReport struct {
+	DryRun     bool        `json:"dry_run"`
+	Created    int32       `json:"created"`
+	Updated    int32       `json:"updated"`
+	Unchanged  int32       `json:"unchanged"`
+	Duplicates int32       `json:"duplicates"`
+	Invalid    int32       `json:"invalid"`
+	Rows       []ImportRow `json:"rows"`
+}
+
+/*
+ImportRow is what importing one row does.  Action is create, update,
+unchanged, duplicate or invalid.
+*/
+type ImportRow struct {
+	Row    int32  `json:"row"`
+	Action string `json:"action"`
+	Car    *Car   `json:"car,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// DuplicateOf is the earlier row a duplicate repeats
+	DuplicateOf int32 `json:"duplicate_of,omitempty"`
+	// Warnings are why a row that is imported may be wrong, such as a
+	// make or model that is not in the catalog
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+/*
+NewImportReport converts an import's result to its REST representation
+as a caller at level may see it
+*/
+func NewImportReport(res *carspb.ImportCarsResponse, level visibility.Level) ImportReport {
+	report := ImportReport{
+		DryRun:     res.GetDryRun(),
+		Created:    res.GetCreated(),
+		Updated:    res.GetUpdated(),
+		Unchanged:  res.GetUnchanged(),
+		Duplicates: res.GetDuplicates(),
+		Invalid:    res.GetInvalid(),
+		Rows:       make([]ImportRow, 0, len(res.GetRows())),
+	}
+	for _, r := range res.GetRows() {
+		row := ImportRow{
+			Row:         r.GetRow(),
+			Action:      strings.ToLower(strings.TrimPrefix(r.GetAction().String(), "IMPORT_ACTION_")),
+			Error:       r.GetError(),
+			DuplicateOf: r.GetDuplicateOf(),
+			Warnings:    r.GetWarnings(),
+		}
+		if r.GetCar() != nil {
+			car := NewCar(r.GetCar(), level)
+			row.Car = &car
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	return report
+}
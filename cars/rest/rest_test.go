@@ -0,0 +1,88 @@
+package rest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/visibility"
+)
+
+func decode(t *testing.T, b []byte) map[string]interface{} {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("Failed! %v in %s", err, b)
+	}
+	return m
+}
+
+// With full visibility a Car must encode exactly as protojson encodes
+// the message, so REST and transcoded gRPC responses agree
+func TestCarMatchesProtoJSON(t *testing.T) {
+	for _, car := range []*carspb.Car{
+		{Id: 1, Make: "Ford", Model: "F10", AcquisitionCostCents: 2850000, MarginCents: 310000, Vin: "1FTEW1EP5JFA12345", Year: 2018, Color: "White", Mileage: 64000},
+		{Id: 9007199254740993},
+	} {
+		want, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(car)
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		got, err := json.Marshal(NewCar(car, visibility.Full))
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		// vehicle details the car does not have are left out
+		wantFields := decode(t, want)
+		for _, field := range []string{"vin", "year", "color", "mileage"} {
+			if v := wantFields[field]; v == "" || v == "0" || v == float64(0) {
+				delete(wantFields, field)
+			}
+		}
+		if !reflect.DeepEqual(decode(t, got), wantFields) {
+			t.Errorf("Failed! got %s, protojson gives %s", got, want)
+		}
+	}
+}
+
+func TestNewCarListIsNeverNull(t *testing.T) {
+	got, err := json.Marshal(NewCarList(nil, visibility.Public))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if string(got) != `{"cars":[]}` {
+		t.Errorf("Failed! got %s", got)
+	}
+}
+
+func TestEventMatchesProtoJSON(t *testing.T) {
+	for _, nanos := range []int{0, 5e8, 123456000, 1} {
+		at := timestamppb.New(time.Date(2024, 5, 1, 12, 0, 0, nanos, time.UTC))
+		event := &carspb.InventoryEvent{
+			Sequence: 42,
+			Type:     carspb.EventType_EVENT_TYPE_HELD,
+			Car:      &carspb.Car{Id: 3, Make: "Toyota", Model: "Rav4", Vin: "2T3P1RFV8MW123456", Year: 2021, Color: "Blue", Mileage: 31000},
+			Time:     at,
+			Hold:     &carspb.Hold{Id: "h1", CarId: 3, HeldBy: "kiosk-1", ExpireTime: at},
+		}
+		want, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(event)
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		got, err := json.Marshal(NewEvent(event, visibility.Public))
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		wantMap := decode(t, want)
+		// enum names are shortened for REST clients
+		wantMap["type"] = "held"
+		if !reflect.DeepEqual(decode(t, got), wantMap) {
+			t.Errorf("Failed! got %s, protojson gives %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+Files names the PEM files used to set up one side of a TLS connection.
+
+For a server, CAFile holds the authorities that sign client certificates,
+and setting it requires every client to present one (mutual TLS).
+For a client, CAFile holds the authorities that sign server certificates;
+the system roots are used when it is empty.
+*/
+type Files struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// fileStamp identifies one version of a file on disk
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stamp(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+/*
+Reloader holds a certificate and CA pool loaded from Files and reloads
+them whenever the files change on disk, so certificates can be rotated
+without restarting.  Files are checked at every handshake; if a reload
+fails the previous certificate and pool stay in use.
+*/
+type Reloader struct {
+	files Files
+
+	mu     sync.Mutex
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+	stamps map[string]fileStamp
+}
+
+/*
+NewReloader loads files, failing if any of them cannot be read
+*/
+func NewReloader(files Files) (*Reloader, error) {
+	if (files.CertFile == "") != (files.KeyFile == "") {
+		return nil, errors.New("tls: certificate and key files must be given together")
+	}
+	r := &Reloader{files: files, stamps: map[string]fileStamp{}}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload rereads any file whose stamp has changed
+func (r *Reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := func(path string) (bool, fileStamp, error) {
+		st, err := stamp(path)
+		if err != nil {
+			return false, st, err
+		}
+		return st != r.stamps[path], st, nil
+	}
+
+	if r.files.CertFile != "" {
+		certChanged, certStamp, err := changed(r.files.CertFile)
+		if err != nil {
+			return err
+		}
+		keyChanged, keyStamp, err := changed(r.files.KeyFile)
+		if err != nil {
+			return err
+		}
+		if certChanged || keyChanged {
+			cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+			if err != nil {
+				return fmt.Errorf("tls: loading key pair: %v", err)
+			}
+			r.cert = &cert
+			r.stamps[r.files.CertFile] = certStamp
+			r.stamps[r.files.KeyFile] = keyStamp
+		}
+	}
+
+	if r.files.CAFile != "" {
+		caChanged, caStamp, err := changed(r.files.CAFile)
+		if err != nil {
+			return err
+		}
+		if caChanged {
+			pem, err := os.ReadFile(r.files.CAFile)
+			if err != nil {
+				return err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("tls: no certificates found in %s", r.files.CAFile)
+			}
+			r.pool = pool
+			r.stamps[r.files.CAFile] = caStamp
+		}
+	}
+	return nil
+}
+
+// current returns the latest certificate and pool, reloading them first if needed
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	if err := r.reload(); err != nil {
+		fmt.Fprintf(os.Stderr, "tls: keeping previous certificates, reload failed: %v\n", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, r.pool
+}
+
+/*
+ServerConfig returns a TLS configuration for a listener.  It requires a
+certificate, and verifies client certificates when a CA file is set.
+*/
+func ServerConfig(files Files) (*tls.Config, error) {
+	if files.CertFile == "" {
+		return nil, errors.New("tls: a server needs a certificate and key")
+	}
+	r, err := NewReloader(files)
+	if err != nil {
+		return nil, err
+	}
+
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	if files.CAFile == "" {
+		base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		}
+		return base, nil
+	}
+
+	// A fresh config per handshake picks up a rotated client CA pool
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, pool := r.current()
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool,
+		}, nil
+	}
+	return base, nil
+}
+
+/*
+ClientConfig returns a TLS configuration for dialling serverName, a host
+name or IP address.  It presents a client certificate when one is set,
+and verifies the server against the CA file, or the system roots when
+there is none.  With a CA file serverName is required.
+*/
+func ClientConfig(files Files, serverName string) (*tls.Config, error) {
+	if files.CAFile != "" && serverName == "" {
+		return nil, errors.New("tls: a server name is needed to verify the server")
+	}
+	r, err := NewReloader(files)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+	if files.CertFile != "" {
+		c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		}
+	}
+	if files.CAFile != "" {
+		// Standard verification uses a fixed RootCAs pool, so it is
+		// replaced by an equivalent check against the current pool.  The
+		// name is checked against serverName rather than the connection
+		// state, whose ServerName is empty when dialling an IP address.
+		c.InsecureSkipVerify = true
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			_, pool := r.current()
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("tls: server presented no certificate")
+			}
+			opts := x509.VerifyOptions{
+				DNSName:       serverName,
+				Roots:         pool,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,255 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// authority is a locally generated CA for tests
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	return key
+}
+
+func newAuthority(t *testing.T) *authority {
+	key := newKey(t)
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "cars test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate for name, a host name or IP address,
+// signed by a to dir, returning its files
+func (a *authority) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) Files {
+	key := newKey(t)
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	files := Files{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+		CAFile:   filepath.Join(dir, name+"-ca.crt"),
+	}
+	write(t, files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	write(t, files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	write(t, files.CAFile, a.pem)
+	return files
+}
+
+func write(t *testing.T, path string, b []byte) {
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+}
+
+// startHealthServer serves the gRPC health service over TLS on an in-memory listener
+func startHealthServer(t *testing.T, serverFiles Files) *bufconn.Listener {
+	serverTLS, err := ServerConfig(serverFiles)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)))
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis
+}
+
+func check(lis *bufconn.Listener, clientTLS *tls.Config) error {
+	conn, err := grpc.NewClient("passthrough:///localhost",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(credentials.NewTLS(clientTLS)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCA, clientCA := newAuthority(t), newAuthority(t)
+	serverFiles := serverCA.issue(t, dir, "localhost", x509.ExtKeyUsageServerAuth)
+	clientFiles := clientCA.issue(t, dir, "rest_server", x509.ExtKeyUsageClientAuth)
+
+	// server verifies clients against the client CA, client verifies the server against the server CA
+	lis := startHealthServer(t, Files{CertFile: serverFiles.CertFile, KeyFile: serverFiles.KeyFile, CAFile: clientFiles.CAFile})
+
+	clientTLS, err := ClientConfig(Files{CertFile: clientFiles.CertFile, KeyFile: clientFiles.KeyFile, CAFile: serverFiles.CAFile}, "localhost")
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	if err := check(lis, clientTLS); err != nil {
+		t.Errorf("Failed! client with a valid certificate was refused: %v", err)
+	}
+
+	anonymousTLS, _ := ClientConfig(Files{CAFile: serverFiles.CAFile}, "localhost")
+	if err := check(lis, anonymousTLS); err == nil {
+		t.Errorf("Failed! client without a certificate was accepted")
+	}
+
+	strangerFiles := newAuthority(t).issue(t, t.TempDir(), "stranger", x509.ExtKeyUsageClientAuth)
+	strangerTLS, _ := ClientConfig(Files{CertFile: strangerFiles.CertFile, KeyFile: strangerFiles.KeyFile, CAFile: serverFiles.CAFile}, "localhost")
+	if err := check(lis, strangerTLS); err == nil {
+		t.Errorf("Failed! client with a certificate from an unknown CA was accepted")
+	}
+}
+
+func TestClientRejectsUnknownServer(t *testing.T) {
+	dir := t.TempDir()
+	serverFiles := newAuthority(t).issue(t, dir, "localhost", x509.ExtKeyUsageServerAuth)
+	otherFiles := newAuthority(t).issue(t, dir, "other", x509.ExtKeyUsageServerAuth)
+	lis := startHealthServer(t, Files{CertFile: serverFiles.CertFile, KeyFile: serverFiles.KeyFile})
+
+	clientTLS, _ := ClientConfig(Files{CAFile: otherFiles.CAFile}, "localhost")
+	if err := check(lis, clientTLS); err == nil {
+		t.Errorf("Failed! server with a certificate from an unknown CA was trusted")
+	}
+}
+
+func TestClientChecksServerName(t *testing.T) {
+	dir := t.TempDir()
+	ca := newAuthority(t)
+	tests := []struct {
+		certName, dialName string
+		ok                 bool
+	}{
+		{"localhost", "localhost", true},
+		{"127.0.0.1", "127.0.0.1", true},
+		{"evil.example", "localhost", false},
+		// an IP address sends no server name, which must not skip the check
+		{"evil.example", "127.0.0.1", false},
+		{"127.0.0.1", "10.0.0.5", false},
+		{"127.0.0.1", "localhost", false},
+	}
+	for _, tt := range tests {
+		serverFiles := ca.issue(t, dir, tt.certName, x509.ExtKeyUsageServerAuth)
+		lis := startHealthServer(t, Files{CertFile: serverFiles.CertFile, KeyFile: serverFiles.KeyFile})
+		clientTLS, err := ClientConfig(Files{CAFile: serverFiles.CAFile}, tt.dialName)
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		if err := check(lis, clientTLS); (err == nil) != tt.ok {
+			t.Errorf("Failed! certificate for %s dialled as %s: got %v, want success %v", tt.certName, tt.dialName, err, tt.ok)
+		}
+	}
+
+	if _, err := ClientConfig(Files{CAFile: filepath.Join(dir, "localhost-ca.crt")}, ""); err == nil {
+		t.Errorf("Failed! expected an error verifying against a CA without a server name")
+	}
+}
+
+func TestServerCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newAuthority(t)
+	files := ca.issue(t, dir, "localhost", x509.ExtKeyUsageServerAuth)
+
+	serverTLS, err := ServerConfig(Files{CertFile: files.CertFile, KeyFile: files.KeyFile})
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	clientTLS, _ := ClientConfig(Files{CAFile: files.CAFile}, "localhost")
+	peerSerial := func() *big.Int {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), clientTLS)
+		if err != nil {
+			t.Fatalf("Failed! %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+
+	before := peerSerial()
+	ca.issue(t, dir, "localhost", x509.ExtKeyUsageServerAuth)
+	after := peerSerial()
+	if before.Cmp(after) == 0 {
+		t.Errorf("Failed! server kept certificate %v after it was replaced on disk", before)
+	}
+}
+
+func TestNewReloaderErrors(t *testing.T) {
+	if _, err := NewReloader(Files{CertFile: "only.crt"}); err == nil {
+		t.Errorf("Failed! certificate without key was accepted")
+	}
+	if _, err := NewReloader(Files{CAFile: "/does/not/exist"}); err == nil {
+		t.Errorf("Failed! missing CA file was accepted")
+	}
+}
@@ -0,0 +1,145 @@
+package tracing_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+	"github.com/simrie/go-grpc-car-service/cars/data"
+	"github.com/simrie/go-grpc-car-service/cars/tracing"
+)
+
+type carServer struct {
+	carspb.UnimplementedCarServiceServer
+}
+
+func (*carServer) Car(ctx context.Context, req *carspb.CarRequest) (*carspb.CarResponse, error) {
+	car, err := data.GetRecordByIdContext(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &carspb.CarResponse{Result: &carspb.Car{Id: car.Id, Make: car.Make, Model: car.Model}}, nil
+}
+
+func TestTracePropagatesFromHandlerToData(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.NewProvider("test", exporter)
+	defer tp.Shutdown(context.Background())
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.UnaryInterceptor(tracing.UnaryServerInterceptor))
+	carspb.RegisterCarServiceServer(s, &carServer{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer conn.Close()
+	client := carspb.NewCarServiceClient(conn)
+
+	router := mux.NewRouter()
+	router.Use(tracing.HTTPMiddleware)
+	router.HandleFunc("/car/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := client.Car(r.Context(), &carspb.CarRequest{Id: 2}); err != nil {
+			t.Errorf("Failed! %v", err)
+		}
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/car/2", nil))
+
+	spans := exporter.GetSpans()
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	handler, ok := byName["GET /car/{id}"]
+	if !ok {
+		t.Fatalf("Failed! no handler span in %v", names(spans))
+	}
+	for _, name := range []string{"/cars.CarService/Car", "data.GetRecordById", "data.GetAllRecords"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("Failed! no %s span in %v", name, names(spans))
+			continue
+		}
+		if span.SpanContext.TraceID() != handler.SpanContext.TraceID() {
+			t.Errorf("Failed! %s is not part of the handler's trace", name)
+		}
+	}
+}
+
+func TestHTTPMiddlewareContinuesTraceparent(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.NewProvider("test", exporter)
+	defer tp.Shutdown(context.Background())
+
+	router := mux.NewRouter()
+	router.Use(tracing.HTTPMiddleware)
+	router.HandleFunc("/cars", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest("GET", "/cars", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Failed! expected one span, got %v", names(spans))
+	}
+	if got := spans[0].SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Failed! span did not continue the incoming trace, got trace id %s", got)
+	}
+}
+
+func TestHTTPMiddlewareTracesGRPC(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := tracing.NewProvider("test", exporter)
+	defer tp.Shutdown(context.Background())
+
+	s := grpc.NewServer()
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	defer s.Stop()
+	srv := httptest.NewServer(h2c.NewHandler(tracing.HTTPMiddleware(s), &http2.Server{}))
+	defer srv.Close()
+
+	conn, err := grpc.NewClient(strings.TrimPrefix(srv.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed! %v", err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Failed! a gRPC call through the middleware failed: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "POST /grpc.health.v1.Health/Check" {
+		t.Errorf("Failed! expected a span for the gRPC method, got %v", names(spans))
+	}
+}
+
+func names(spans tracetest.SpanStubs) []string {
+	var result []string
+	for _, span := range spans {
+		result = append(result, span.Name)
+	}
+	return result
+}
+
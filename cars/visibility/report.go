@@ -0,0 +1,151 @@
+package visibility
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+/*
+Header is the response metadata in which the microservice reports the
+level it filtered cars at.  It is lower than the caller's when the
+microservice did not accept the identity the REST service forwarded, as
+happens without mutual TLS between them.
+*/
+const Header = "x-cars-visibility"
+
+type reportKey struct{}
+
+// report keeps the lowest level reported for the calls made for one
+// request
+type report struct {
+	mu       sync.Mutex
+	level    Level
+	reported bool
+}
+
+func reportFrom(ctx context.Context) *report {
+	r, _ := ctx.Value(reportKey{}).(*report)
+	return r
+}
+
+// add records the level reported in md.  A level that cannot be read
+// counts as public.
+func (r *report) add(md metadata.MD) {
+	values := md.Get(Header)
+	if r == nil || len(values) == 0 {
+		return
+	}
+	level := Public
+	if n, err := strconv.Atoi(values[0]); err == nil && Level(n) >= Public && Level(n) <= Full {
+		level = Level(n)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.reported || level < r.level {
+		r.level, r.reported = level, true
+	}
+}
+
+// lowest returns the lowest level reported, if any was
+func (r *report) lowest() (Level, bool) {
+	if r == nil {
+		return Public, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.level, r.reported
+}
+
+/*
+Track returns ctx recording the levels the microservice reports for
+calls made with it, which LevelFromContext then keeps to
+*/
+func Track(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reportKey{}, &report{})
+}
+
+/*
+Middleware tracks the levels reported for the calls made while handling
+each request
+*/
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(Track(r.Context())))
+	})
+}
+
+/*
+UnaryServerInterceptor reports the level the call's results are filtered
+at in the Header response metadata
+*/
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	grpc.SetHeader(ctx, levelHeader(ctx))
+	return handler(ctx, req)
+}
+
+/*
+StreamServerInterceptor reports the level a stream's results are
+filtered at in the Header response metadata
+*/
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ss.SetHeader(levelHeader(ss.Context()))
+	return handler(srv, ss)
+}
+
+func levelHeader(ctx context.Context) metadata.MD {
+	return metadata.Pairs(Header, strconv.Itoa(int(LevelFromContext(ctx))))
+}
+
+/*
+UnaryClientInterceptor records the level the microservice reports for a
+call made with a context from Track
+*/
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	r := reportFrom(ctx)
+	if r == nil {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	var md metadata.MD
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&md))...)
+	r.add(md)
+	return err
+}
+
+/*
+StreamClientInterceptor records the level the microservice reports for
+a stream opened with a context from Track, once its first message
+arrives
+*/
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	r := reportFrom(ctx)
+	if err != nil || r == nil {
+		return cs, err
+	}
+	return &reportingStream{ClientStream: cs, report: r}, nil
+}
+
+// reportingStream records the stream's header metadata with the first
+// message, when it is sure to have arrived
+type reportingStream struct {
+	grpc.ClientStream
+	report *report
+	once   sync.Once
+}
+
+func (s *reportingStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.once.Do(func() {
+			if md, err := s.Header(); err == nil {
+				s.report.add(md)
+			}
+		})
+	}
+	return err
+}
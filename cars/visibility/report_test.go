@@ -0,0 +1,45 @@
+package visibility
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+)
+
+func TestReportedLevelCaps(t *testing.T) {
+	manager := auth.NewContext(context.Background(), auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}})
+	if got := LevelFromContext(Track(manager)); got != Full {
+		t.Errorf("Failed! nothing reported yet: got level %d, want %d", got, Full)
+	}
+
+	tests := []struct {
+		reported []string
+		want     Level
+	}{
+		{[]string{"2"}, Full},
+		{[]string{"2", "1"}, Coarse},
+		{[]string{"0", "2"}, Public},
+		{[]string{"full"}, Public},
+		{[]string{"7"}, Public},
+	}
+	for _, tt := range tests {
+		ctx := Track(manager)
+		for _, level := range tt.reported {
+			reportFrom(ctx).add(metadata.Pairs(Header, level))
+		}
+		if got := LevelFromContext(ctx); got != tt.want {
+			t.Errorf("Failed! reported %v: got level %d, want %d", tt.reported, got, tt.want)
+		}
+	}
+
+	// a reported level never raises the caller's own
+	sales := auth.NewContext(context.Background(), auth.Identity{Subject: "s", Roles: []auth.Role{auth.Sales}})
+	ctx := Track(sales)
+	reportFrom(ctx).add(metadata.Pairs(Header, "2"))
+	if got := LevelFromContext(ctx); got != Coarse {
+		t.Errorf("Failed! got level %d, want %d", got, Coarse)
+	}
+}
@@ -0,0 +1,99 @@
+package visibility
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+// MarginStepCents is the granularity margins are rounded down to for sales
+const MarginStepCents = 50000
+
+/*
+Level says how much of a car's internal cost data a caller may see
+*/
+type Level int
+
+const (
+	// Public callers see no cost data
+	Public Level = iota
+	// Coarse callers see the margin rounded down to MarginStepCents,
+	// but not the acquisition cost
+	Coarse
+	// Full callers see all cost data
+	Full
+)
+
+/*
+LevelFor returns the visibility level of id: full for managers and
+admins, coarse for sales, and public for everyone else
+*/
+func LevelFor(id auth.Identity) Level {
+	switch {
+	case id.Has(auth.Manager):
+		return Full
+	case id.Has(auth.Sales):
+		return Coarse
+	default:
+		return Public
+	}
+}
+
+/*
+LevelFromContext returns the visibility level of the identity carried
+by ctx; callers without an identity are public.  When ctx tracks the
+levels the microservice reported, the level is no higher than the lowest
+of them, so fields the microservice left out are not shown as zero.
+*/
+func LevelFromContext(ctx context.Context) Level {
+	level := Public
+	if id, ok := auth.FromContext(ctx); ok {
+		level = LevelFor(id)
+	}
+	if reported, ok := reportFrom(ctx).lowest(); ok && reported < level {
+		level = reported
+	}
+	return level
+}
+
+/*
+Car returns car as a caller at level may see it.  car itself is never
+modified; a copy is returned when fields have to be stripped or masked.
+*/
+func Car(level Level, car *carspb.Car) *carspb.Car {
+	if car == nil || level == Full {
+		return car
+	}
+	visible := proto.Clone(car).(*carspb.Car)
+	visible.AcquisitionCostCents = 0
+	if level == Coarse {
+		visible.MarginCents -= visible.MarginCents % MarginStepCents
+	} else {
+		visible.MarginCents = 0
+	}
+	return visible
+}
+
+/*
+Cars returns cars as a caller at level may see them
+*/
+func Cars(level Level, cars []*carspb.Car) []*carspb.Car {
+	if level == Full {
+		return cars
+	}
+	visible := make([]*carspb.Car, len(cars))
+	for i, car := range cars {
+		visible[i] = Car(level, car)
+	}
+	return visible
+}
+
+/*
+Filter returns cars as the caller identified in ctx may see them
+*/
+func Filter(ctx context.Context, cars ...*carspb.Car) []*carspb.Car {
+	return Cars(LevelFromContext(ctx), cars)
+}
@@ -0,0 +1,55 @@
+package visibility
+
+import (
+	"context"
+	"testing"
+
+	"github.com/simrie/go-grpc-car-service/cars/auth"
+	"github.com/simrie/go-grpc-car-service/cars/carspb"
+)
+
+func testCar() *carspb.Car {
+	return &carspb.Car{
+		Id:                   1,
+		Make:                 "Ford",
+		Model:                "F10",
+		AcquisitionCostCents: 2850000,
+		MarginCents:          310000,
+	}
+}
+
+func TestFilterByRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		wantCost int64
+		wantMarg int64
+	}{
+		{"anonymous", context.Background(), 0, 0},
+		{"viewer", auth.NewContext(context.Background(), auth.Identity{Subject: "v", Roles: []auth.Role{auth.Viewer}}), 0, 0},
+		{"sales", auth.NewContext(context.Background(), auth.Identity{Subject: "s", Roles: []auth.Role{auth.Sales}}), 0, 300000},
+		{"manager", auth.NewContext(context.Background(), auth.Identity{Subject: "m", Roles: []auth.Role{auth.Manager}}), 2850000, 310000},
+		{"admin", auth.NewContext(context.Background(), auth.Identity{Subject: "a", Roles: []auth.Role{auth.Admin}}), 2850000, 310000},
+		{"unknown role", auth.NewContext(context.Background(), auth.Identity{Subject: "x", Roles: []auth.Role{"owner"}}), 0, 0},
+	}
+	for _, tt := range tests {
+		car := testCar()
+		got := Filter(tt.ctx, car)[0]
+		if got.AcquisitionCostCents != tt.wantCost || got.MarginCents != tt.wantMarg {
+			t.Errorf("Failed! %s: got cost %d margin %d, want cost %d margin %d",
+				tt.name, got.AcquisitionCostCents, got.MarginCents, tt.wantCost, tt.wantMarg)
+		}
+		if got.Id != 1 || got.Make != "Ford" || got.Model != "F10" {
+			t.Errorf("Failed! %s: public fields changed: %v", tt.name, got)
+		}
+		if car.AcquisitionCostCents != 2850000 || car.MarginCents != 310000 {
+			t.Errorf("Failed! %s: the original car was modified", tt.name)
+		}
+	}
+}
+
+func TestCarNil(t *testing.T) {
+	if got := Car(Public, nil); got != nil {
+		t.Errorf("Failed! expected nil, got %v", got)
+	}
+}
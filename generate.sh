@@ -12,9 +12,13 @@ pkg_path=${pkg}/${pkg}pb/${pkg}.proto
 
 # --go_out flag generates in ${pkg}.pb.go
 # --go_grpc_out flag generates ${pkg}_grpc.pb.go
+# --grpc-gateway_out flag generates ${pkg}.pb.gw.go, the REST transcoding
+#   for the google.api.http annotations (go install
+#   github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-grpc-gateway@v2.24.0)
+# -I third_party finds google/api/annotations.proto
 
-protoc --go_out=paths=source_relative:. --go-grpc_out=. --go-grpc_opt=paths=source_relative ${pkg_path}
-echo "make sure two files in ${pkg}/${pkg}pb/ were generated: ${pkg}_grpc.pb.go and ${pkg}.pb.go"
+protoc -I . -I third_party --go_out=paths=source_relative:. --go-grpc_out=. --go-grpc_opt=paths=source_relative --grpc-gateway_out=paths=source_relative:. ${pkg_path}
+echo "make sure three files in ${pkg}/${pkg}pb/ were generated: ${pkg}_grpc.pb.go, ${pkg}.pb.go and ${pkg}.pb.gw.go"
 
 
 # Command from Udemy tutorial not working with Windows protoc.exe